@@ -0,0 +1,495 @@
+package args
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// The name of the file written to the root of an extracted archive. It
+	// contains the digest of the archive the directory was extracted from.
+	ArchiveMarkerFilename = ".jbuild_archive"
+)
+
+var (
+	// The archive formats which can be extracted, keyed by file extension.
+	archiveExtensions = []string{".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar", ".zip"}
+)
+
+// isArchiveUrl returns true iff the given URL looks like it points to an
+// archive we know how to extract.
+func isArchiveUrl(url string) bool {
+	return archiveExtension(url) != ""
+}
+
+// archiveExtension returns the archive extension of the given URL, or "" if the
+// URL doesn't look like an archive.
+func archiveExtension(url string) string {
+	url = strings.ToLower(strings.SplitN(url, "?", 2)[0])
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(url, ext) {
+			return ext
+		}
+	}
+
+	return ""
+}
+
+// DownloadCacheDir returns the directory in which downloaded archives are kept.
+// Archives in this directory are named after their SHA-256 digest, so the same
+// archive is only ever downloaded once.
+func DownloadCacheDir(args *Args) string {
+	return filepath.Join(args.ExternalRepoDir, ".cache", "downloads")
+}
+
+// openUrl opens the given URL for reading. file:// URLs may be relative, in
+// which case they are relative to `baseDir`.
+func openUrl(baseDir, url string) (io.ReadCloser, error) {
+	if strings.HasPrefix(url, "file://") {
+		path := filepath.FromSlash(strings.TrimPrefix(url, "file://"))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+
+		return os.Open(path)
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("GET %s: %s", url, resp.Status))
+	}
+
+	return resp.Body, nil
+}
+
+// fileDigest returns the hex encoded SHA-256 digest of the file at `path`.
+func fileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// downloadUrl downloads a single URL into the download cache and returns the
+// path to the cached file along with its digest.
+func downloadUrl(args *Args, repo *ExternalRepo, url string) (string, string, error) {
+	cacheDir := DownloadCacheDir(args)
+	reader, err := openUrl(repo.baseDir(args), url)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer reader.Close()
+
+	// Download into a temporary file first, so that a partial download never
+	// ends up in the cache.
+	tmpFile, err := ioutil.TempFile(cacheDir, "download-")
+	if err != nil {
+		return "", "", err
+	}
+
+	defer os.Remove(tmpFile.Name())
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmpFile, hasher), reader)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	cachePath := filepath.Join(cacheDir, digest)
+	if err := os.Rename(tmpFile.Name(), cachePath); err != nil {
+		return "", "", err
+	}
+
+	return cachePath, digest, nil
+}
+
+// downloadArchive makes sure the archive for `repo` is in the download cache
+// and returns the path to it, along with its digest. Each URL is tried in turn
+// until one of them matches the expected checksum.
+func downloadArchive(args *Args, repo *ExternalRepo) (string, string, error) {
+	cacheDir := DownloadCacheDir(args)
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", "", err
+	}
+
+	// If we know what we are looking for, check the cache first.
+	if repo.Sha256 != "" {
+		cachePath := filepath.Join(cacheDir, repo.Sha256)
+		if digest, err := fileDigest(cachePath); err == nil {
+			if digest == repo.Sha256 {
+				return cachePath, digest, nil
+			}
+
+			// Somehow the cache has been corrupted; throw it away.
+			os.Remove(cachePath)
+		}
+	}
+
+	errs := make([]string, 0, len(repo.Urls))
+	for _, url := range repo.Urls {
+		if args.Offline && !strings.HasPrefix(url, "file://") {
+			errs = append(errs, fmt.Sprintf("%s: not downloaded while offline", url))
+			continue
+		}
+
+		fmt.Printf("Downloading %s...\n", url)
+		cachePath, digest, err := downloadUrl(args, repo, url)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", url, err))
+			continue
+		}
+
+		if repo.Sha256 != "" && digest != repo.Sha256 {
+			os.Remove(cachePath)
+			errs = append(errs, fmt.Sprintf(
+				"%s: checksum mismatch, expected sha256 %s but got %s",
+				url, repo.Sha256, digest))
+			continue
+		}
+
+		if repo.Sha256 == "" {
+			fmt.Printf(
+				"Warning: external repo '%s' has no sha256; add sha256: \"%s\" to pin it.\n",
+				repo.Path, digest)
+		}
+
+		return cachePath, digest, nil
+	}
+
+	if args.Offline {
+		errs = append(errs,
+			"Run 'jbuild vendor' while online to make it available offline.")
+	}
+
+	return "", "", errors.New(fmt.Sprintf(
+		"Could not download external repo '%s':\n  %s",
+		repo.Path, strings.Join(errs, "\n  ")))
+}
+
+// insideDir returns true iff `path` is `dir` or somewhere within it.
+func insideDir(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// extractPath returns the location within `dir` to extract the archive member
+// `name` to, making sure it doesn't escape `dir`. Members can't be written
+// through symlinks extracted before them either, as they could point anywhere.
+func extractPath(dir, name string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if !insideDir(dir, path) {
+		return "", errors.New(fmt.Sprintf("Archive member '%s' is outside the archive", name))
+	}
+
+	for parent := path; parent != dir; parent = filepath.Dir(parent) {
+		if stat, err := os.Lstat(parent); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+			return "", errors.New(fmt.Sprintf("Archive member '%s' is written through a symlink", name))
+		}
+	}
+
+	return path, nil
+}
+
+// extractSymlink makes the symlink at `path` to `target`, for the archive member
+// `name`. The target must be relative and stay within `dir`.
+func extractSymlink(dir, path, name, target string) error {
+	resolved := filepath.Join(filepath.Dir(path), filepath.FromSlash(target))
+	if filepath.IsAbs(filepath.FromSlash(target)) || !insideDir(dir, resolved) {
+		return errors.New(fmt.Sprintf(
+			"Archive member '%s' links to '%s', which is outside the archive", name, target))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.Symlink(target, path)
+}
+
+// extractTar extracts a tar stream into `dir`.
+func extractTar(reader io.Reader, dir string) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path, err := extractPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode)|0600)
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(file, tarReader)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+
+			if err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if err := extractSymlink(dir, path, header.Name, header.Linkname); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			// Hard links name another member of the archive.
+			target, err := extractPath(dir, header.Linkname)
+			if err != nil {
+				return err
+			}
+
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			if err := os.Link(target, path); err != nil {
+				return err
+			}
+
+		case tar.TypeXGlobalHeader:
+			// Only metadata (like the commit of archives made by git), so skip it.
+
+		default:
+			return errors.New(fmt.Sprintf(
+				"Archive member '%s' has an unsupported type '%c'", header.Name, header.Typeflag))
+		}
+	}
+}
+
+// extractZip extracts the zip file at `archivePath` into `dir`.
+func extractZip(archivePath, dir string) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+
+	defer zipReader.Close()
+	for _, member := range zipReader.File {
+		path, err := extractPath(dir, member.Name)
+		if err != nil {
+			return err
+		}
+
+		if member.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		memberReader, err := member.Open()
+		if err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, member.Mode()|0600)
+		if err != nil {
+			memberReader.Close()
+			return err
+		}
+
+		_, err = io.Copy(file, memberReader)
+		memberReader.Close()
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractArchive extracts the archive at `archivePath` (of type `ext`) into
+// `dir`.
+func extractArchive(archivePath, ext, dir string) error {
+	switch ext {
+	case ".zip":
+		return extractZip(archivePath, dir)
+
+	case ".tar.xz", ".txz":
+		// There is no xz support in the standard library, so use the system tool.
+		cmd := exec.Command("xz", "-dc", archivePath)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+
+		if err := extractTar(stdout, dir); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+
+		return cmd.Wait()
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	if ext == ".tar" {
+		return extractTar(file, dir)
+	}
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	defer gzipReader.Close()
+	return extractTar(gzipReader, dir)
+}
+
+// fetchArchive downloads and extracts an archive based external repo. The
+// archive is extracted next to its final location and then moved into place,
+// so a failed extraction never leaves a half-populated repo behind.
+func fetchArchive(args *Args, repo *ExternalRepo) error {
+	repoDir := filepath.Join(args.ExternalRepoDir, strings.Trim(repo.Path, "/"))
+	repo.FsDir = repoDir
+
+	// Work out which patches should be applied.
+	patches, err := loadPatches(args, repo)
+	if err != nil {
+		return err
+	}
+
+	// If this repo has already been extracted from the right archive (with the
+	// right patches), then there is nothing to do.
+	marker, err := ioutil.ReadFile(filepath.Join(repoDir, ArchiveMarkerFilename))
+	if err == nil && appliedPatchDigest(repoDir) == patchDigest(repo, patches) {
+		extractedDigest := strings.TrimSpace(string(marker))
+		if repo.Sha256 == extractedDigest || (repo.Sha256 == "" && !args.UpdateExternals) {
+			return nil
+		}
+	}
+
+	archivePath, digest, err := downloadArchive(args, repo)
+	if err != nil {
+		return err
+	}
+
+	// Find the extension from whichever URL has one; mirrors may not.
+	ext := ""
+	for _, url := range repo.Urls {
+		if ext = archiveExtension(url); ext != "" {
+			break
+		}
+	}
+
+	if ext == "" {
+		return errors.New(fmt.Sprintf(
+			"Could not determine the archive type of external repo '%s'", repo.Path))
+	}
+
+	fmt.Printf("Extracting %s...\n", repo.Path)
+	if err := os.MkdirAll(filepath.Dir(repoDir), 0755); err != nil {
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(repoDir), filepath.Base(repoDir)+".extract-")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+	if err := extractArchive(archivePath, ext, tmpDir); err != nil {
+		return errors.New(fmt.Sprintf(
+			"Could not extract external repo '%s': %s", repo.Path, err))
+	}
+
+	root := tmpDir
+	if repo.StripPrefix != "" {
+		root = filepath.Join(tmpDir, filepath.FromSlash(repo.StripPrefix))
+		if !insideDir(tmpDir, root) {
+			return errors.New(fmt.Sprintf(
+				"strip_prefix '%s' of external repo '%s' is outside the archive",
+				repo.StripPrefix, repo.Path))
+		}
+
+		if stat, err := os.Lstat(root); err != nil || !stat.IsDir() {
+			return errors.New(fmt.Sprintf(
+				"strip_prefix '%s' not found in archive for external repo '%s'",
+				repo.StripPrefix, repo.Path))
+		}
+	}
+
+	if err := applyPatches(repo, root, patches); err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(root, ArchiveMarkerFilename), []byte(digest+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	// Swap the new directory into place.
+	if err := os.RemoveAll(repoDir); err != nil {
+		return err
+	}
+
+	return os.Rename(root, repoDir)
+}
@@ -0,0 +1,110 @@
+package args
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeTar makes a tar stream from `headers`. Regular files contain their name.
+func makeTar(t *testing.T, headers ...tar.Header) *bytes.Buffer {
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	for _, header := range headers {
+		header.Mode = 0644
+		content := []byte(header.Name)
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(content))
+		}
+
+		require.NoError(t, writer.WriteHeader(&header))
+		if header.Typeflag == tar.TypeReg {
+			_, err := writer.Write(content)
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, writer.Close())
+	return &buffer
+}
+
+// extractTestTar extracts `headers` into a new directory inside a new parent
+// directory, returning both.
+func extractTestTar(t *testing.T, headers ...tar.Header) (string, string, error) {
+	parent, err := ioutil.TempDir("", "jbuild-archive")
+	require.NoError(t, err)
+
+	dir := filepath.Join(parent, "repo")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	return parent, dir, extractTar(makeTar(t, headers...), dir)
+}
+
+func TestExtractTarLinks(t *testing.T) {
+	parent, dir, err := extractTestTar(t,
+		tar.Header{Name: "src/lib.cc", Typeflag: tar.TypeReg},
+		tar.Header{Name: "include/lib.cc", Typeflag: tar.TypeSymlink, Linkname: "../src/lib.cc"},
+		tar.Header{Name: "copy.cc", Typeflag: tar.TypeLink, Linkname: "src/lib.cc"})
+	defer os.RemoveAll(parent)
+	require.NoError(t, err)
+
+	for _, name := range []string{"include/lib.cc", "copy.cc"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		require.NoError(t, err)
+		assert.Equal(t, "src/lib.cc", string(content))
+	}
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	archives := map[string][]tar.Header{
+		"absolute symlink": {
+			{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "/"}},
+		"escaping symlink": {
+			{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "../.."}},
+		"write through symlink": {
+			{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "evil/x", Typeflag: tar.TypeReg}},
+		"escaping hard link": {
+			{Name: "evil", Typeflag: tar.TypeLink, Linkname: "../outside"}},
+	}
+
+	for name, headers := range archives {
+		parent, _, err := extractTestTar(t, headers...)
+		assert.Error(t, err, name)
+		_, err = os.Stat(filepath.Join(parent, "x"))
+		assert.True(t, os.IsNotExist(err), name)
+		os.RemoveAll(parent)
+	}
+}
+
+func TestMakeExternalRepoRejectsBadArchiveOptions(t *testing.T) {
+	url := "https://example.com/lib.tar.gz"
+	repos := map[string]map[string]interface{}{
+		"sha256 path":         {"url": url, "sha256": "../../../some/file"},
+		"short sha256":        {"url": url, "sha256": "abc123"},
+		"sha256 not a string": {"url": url, "sha256": 12.0},
+		"absolute prefix":     {"url": url, "strip_prefix": "/etc"},
+		"escaping prefix":     {"url": url, "strip_prefix": "lib/../../x"},
+		"urls not a list":     {"urls": url},
+		"url not a string":    {"urls": []interface{}{url, 1.0}},
+	}
+
+	for name, repoJson := range repos {
+		_, err := makeExternalRepo("", "//third_party/lib", repoJson)
+		if assert.Error(t, err, name) {
+			assert.Contains(t, err.Error(), "//third_party/lib", name)
+		}
+	}
+
+	repo, err := makeExternalRepo("", "//third_party/lib", map[string]interface{}{
+		"url": url, "sha256": strings.ToUpper(testArchiveSha256), "strip_prefix": "lib-1.0"})
+	require.NoError(t, err)
+	assert.Equal(t, testArchiveSha256, repo.Sha256)
+	assert.Equal(t, ArchiveRepo, repo.Type)
+}
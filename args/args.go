@@ -1,412 +1,617 @@
-// Args is where all flags used anywhere are defined. This is useful for many
-// flags which have no clear point at which they can be defined, and provides a
-// simple way of adding new flags.
-package args
-
-import (
-	"errors"
-	"flag"
-	"fmt"
-	"io/ioutil"
-	"os"
-	"os/exec"
-	"os/user"
-	"path/filepath"
-	"runtime"
-	"strings"
-
-	"github.com/hjson/hjson-go"
-)
-
-type Args struct {
-	// General flags.
-	DryRun bool
-
-	// Input/Output options.
-	OutputDir    string
-	GenOutputDir string
-	WorkspaceDir string
-
-	// Workspace options.
-	BaseWorkspaceFiles string
-	WorkspaceFilename  string
-	ExternalRepoKey    string
-	ExternalRepoDir    string
-	UpdateExternals    bool
-	CleanExternalRepos bool
-	BuildFilename      string
-
-	// Display options.
-	ShowLog           bool
-	ShowCommands      bool
-	ShowCommandEnv    bool
-	UseSimpleProgress bool
-
-	// Processing options.
-	Threads       int
-	Configuration string
-
-	// Testing options.
-	ForceRunTests bool
-	TestRuns      uint
-	TestOutput    string
-	TestThreads   int
-
-	// C++ options.
-	CCCompiler string
-
-	// Testing options.
-	NoCache bool
-
-	// Not actual arguments, but still useful.
-	CurrentDir string
-
-	// Windows options.
-	VCVersion string
-
-	// External repos that need to be loaded. Once loaded, this map should contain
-	// a mapping from the local workspace path --> BUILD file text. The local path
-	// must be absolute.
-	ExternalRepos map[string]*ExternalRepo
-
-	// The WORKSPACE file loaded.
-	WorkspaceOptions     map[string]interface{}
-	ConfigurationOptions map[string]interface{}
-}
-
-// All flags are defined using flag.xVar(&flag, ...). This generally makes them
-// easier to use, because pointers are smelly.
-var (
-	args Args
-)
-
-func init() {
-	// General flags.
-	flag.BoolVar(&args.DryRun, "dry_run", false,
-		"Enable DryRun mode. When DryRun is enabled, no changes will be made to "+
-			"filesystem, but all target dependency resolution will be performed. This "+
-			"is mostly useful for testing.")
-
-	// Input/Output options.
-	flag.StringVar(&args.OutputDir, "output_dir", "bin",
-		"The output directory in which all processed files will be placed. Can be "+
-			"absolute or relative to the workspace directory.")
-
-	flag.StringVar(&args.GenOutputDir, "gen_output_dir", "gen",
-		"The output directory in which all generated files will be placed. Can be "+
-			"absolute or relative to the output directory.")
-
-	flag.StringVar(&args.WorkspaceDir, "workspace_dir", "",
-		"The root directory of the workspace. If blank, the directory tree will be "+
-			"scanned for a WORKSPACE file. The filename searched for can be configured "+
-			"using the workspace_filename flag.")
-
-	// Workspace options.
-	flag.StringVar(&args.BaseWorkspaceFiles, "base_workspace_files", "",
-		"A directory from which to load base WORKSPACE files. This allows "+
-			"standard configuration files (like boost) to be shipped with jbuild. "+
-			"The values in the project's WORKSPACE file take precedence over there. "+
-			"Files should be named XXX.workspace, where XXX signifies what is in "+
-			"the file.")
-
-	flag.StringVar(&args.WorkspaceFilename, "workspace_filename", "WORKSPACE",
-		"The name of the WORKSPACE file when looking for the workspace root.")
-
-	flag.StringVar(&args.ExternalRepoKey, "external_repo_key", "external",
-		"The key in the WORKSPACE file that will be loaded as an external repo "+
-			"list.")
-
-	flag.StringVar(&args.ExternalRepoDir, "external_repo_dir", "",
-		"The absolute path to the location to store external repos. If blank, defaults "+
-			"to a location within the user's home directory.")
-
-	flag.BoolVar(&args.UpdateExternals, "update_externals", false,
-		"If set to true, external repositories will be updated.")
-
-	flag.BoolVar(&args.CleanExternalRepos, "clean_external_repos", false,
-		"If set to true, remove external repos when cleaning.")
-
-	flag.StringVar(&args.BuildFilename, "build_filename", "BUILD",
-		"The name of the BUILD file specifying the targets in each directory.")
-
-	// Display options.
-	flag.BoolVar(&args.ShowLog, "show_log", false,
-		"If enabled, raw log messages will be shown rather than progress bars.")
-
-	flag.BoolVar(&args.ShowCommands, "show_commands", false,
-		"If enabled, the commands run will be printed to the display. These will "+
-			"only be visible if show_log is also enabled.")
-
-	flag.BoolVar(&args.UseSimpleProgress, "use_simple_progress", true,
-		"If enabled, use the simple (and reliable) progress bar system.")
-
-	// Processing options.
-	flag.IntVar(&args.Threads, "threads", runtime.NumCPU(),
-		"Number of threads to use while processing targets.")
-
-	flag.StringVar(&args.Configuration, "c", "",
-		"The configuration to use when building. By default, no configuration is "+
-			"used (except for the common stuff).")
-
-	// Test options.
-	flag.BoolVar(&args.ForceRunTests, "force_run_tests", false,
-		"If set, tests will be run even if cached results are available.")
-
-	flag.UintVar(&args.TestRuns, "test_runs", 1,
-		"The number of times to run each test.")
-
-	flag.StringVar(&args.TestOutput, "test_output", "errors",
-		"The verbosity of test output to show. 'all' means all output is shown, "+
-			"'errors' (default) means only show error output and 'none' means only "+
-			"show pass/fail status (no raw output).")
-
-	flag.IntVar(&args.TestThreads, "test_threads", runtime.NumCPU(),
-		"The number of threads to use when running tests. For accurate timing "+
-			"results, a small number should be used. For pass/fail results, any number "+
-			"can be used.")
-
-	// C++ options.
-	flag.StringVar(&args.CCCompiler, "cc_compiler", "", "The C++ compiler to use.")
-
-	// Testing options.
-	flag.BoolVar(&args.NoCache, "no_cache", false,
-		"If set to true, no internal caching of any kind will be used. This is "+
-			"useful for testing.")
-}
-
-// LoadConfigFile loads the BUILD specification file located at `path` and
-// returns a generic key-value mapping as the result. The BUILD file is
-// actually JSON, but we use hjson to make the config easier to write.
-func LoadConfigFile(args *Args, path string) (map[string]interface{}, error) {
-	var jsonContent []byte
-	var err error
-
-	// First, try to load the config file as JSON. If there was some error, then
-	// try to load it as a Python file.
-	if _, err = os.Stat(path); err != nil {
-		return nil, errors.New(fmt.Sprintf("Config file not found '%s'", path))
-	}
-
-	jsonContent, err = ioutil.ReadFile(path)
-	if err != nil {
-		return nil, errors.New(
-			fmt.Sprintf("Could not read config file '%s': %s", path, err))
-	}
-
-	configJson := make(map[string]interface{})
-	err = hjson.Unmarshal(jsonContent, &configJson)
-
-	// Failed to load JSON, try execute it as a Python file.
-	if err != nil {
-		cmd := exec.Command("python", path)
-		jsonContent, err = cmd.CombinedOutput()
-		if err != nil {
-			return nil, errors.New(string(jsonContent))
-		}
-
-		err = hjson.Unmarshal(jsonContent, &configJson)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return configJson, nil
-}
-
-// Get a reference to the default arguments.
-func DefaultArgs() Args {
-	return args
-}
-
-// Union two dictionaries together recursively. This will modify dst by merging
-// in all values within src. If values in dst are multi-valued (i.e. maps or
-// slices), everything in dst will be preserved. Single-value overrides will
-// still take place.
-func Merge(dst, src map[string]interface{}) map[string]interface{} {
-	for k, v := range src {
-		switch v.(type) {
-		case map[string]interface{}:
-			_, ok := dst[k]
-			if !ok {
-				dst[k] = make(map[string]interface{}, 0)
-			}
-
-			dst[k] = Merge(dst[k].(map[string]interface{}), v.(map[string]interface{}))
-
-		case []interface{}:
-			_, ok := dst[k]
-			if !ok {
-				dst[k] = make([]interface{}, 0)
-			}
-
-			dst[k] = append(dst[k].([]interface{}), v.([]interface{})...)
-
-		default:
-			dst[k] = v
-		}
-	}
-
-	return dst
-}
-
-// Load performs additional setup required to ensure the arguments are in a
-// consistent format. This involves making the paths absolute, finding the
-// workspace directory if necessary etc.
-func Load(cwd string, customArgs *Args) (Args, error) {
-	// Make a copy of the default args.
-	var newArgs Args
-	if customArgs != nil {
-		newArgs = *customArgs
-	} else {
-		newArgs = args
-	}
-
-	// Get the user's home directory.
-	usr, err := user.Current()
-	if err != nil {
-		return Args{}, err
-	}
-
-	// Load the CurrentDir flag.
-	newArgs.CurrentDir = cwd
-
-	// Load any base workspace files.
-	newArgs.WorkspaceOptions = make(map[string]interface{})
-	if newArgs.BaseWorkspaceFiles == "" {
-		newArgs.BaseWorkspaceFiles = filepath.Join(usr.HomeDir, ".jbuild")
-	} else {
-		if !filepath.IsAbs(newArgs.BaseWorkspaceFiles) {
-			newArgs.BaseWorkspaceFiles = filepath.Join(cwd, newArgs.BaseWorkspaceFiles)
-		}
-	}
-
-	// If the base workspace files dir doesn't exist, make it.
-	if exists, _ := os.Stat(newArgs.BaseWorkspaceFiles); exists == nil {
-		os.MkdirAll(newArgs.BaseWorkspaceFiles, 0755)
-	}
-
-	baseWorkspaceFiles, err := ioutil.ReadDir(newArgs.BaseWorkspaceFiles)
-	if err != nil {
-		return Args{}, err
-	}
-
-	for _, file := range baseWorkspaceFiles {
-		filePath := filepath.Join(newArgs.BaseWorkspaceFiles, file.Name())
-		if strings.HasSuffix(strings.ToLower(file.Name()), ".workspace") {
-			cfg, err := LoadConfigFile(&newArgs, filePath)
-			if err != nil {
-				return Args{}, err
-			}
-
-			newArgs.WorkspaceOptions = Merge(newArgs.WorkspaceOptions, cfg)
-		}
-	}
-
-	// Load the WorkspaceDir flag.
-	if newArgs.WorkspaceDir == "" {
-		tmpCwd := newArgs.CurrentDir
-		for tmpCwd != "" {
-			// Check if the workspace file exists in this directory.
-			workspaceFile := filepath.Join(tmpCwd, newArgs.WorkspaceFilename)
-			if _, err := os.Stat(workspaceFile); err == nil {
-				newArgs.WorkspaceDir = tmpCwd
-				break
-			}
-
-			// Remove the last part of the path off.
-			var file string
-			tmpCwd, file = filepath.Split(tmpCwd)
-			tmpCwd = strings.TrimRight(tmpCwd, string(os.PathSeparator))
-
-			if file == "" {
-				break
-			}
-		}
-
-		// If WorkspaceDir still isn't set, then we have failed.
-		if newArgs.WorkspaceDir == "" {
-			return Args{}, errors.New(fmt.Sprintf(
-				"Could not find WORKSPACE file '%s' anywhere above the current directory.",
-				newArgs.WorkspaceFilename))
-		}
-	}
-
-	// Load the ExternalRepoDir flag.
-	if newArgs.ExternalRepoDir == "" {
-		newArgs.ExternalRepoDir = filepath.Join(usr.HomeDir, ".jbuild", "external")
-	}
-
-	// Load the workspace file.
-	workspaceFilePath := filepath.Join(newArgs.WorkspaceDir, newArgs.WorkspaceFilename)
-	workspaceFileStat, _ := os.Stat(workspaceFilePath)
-	if workspaceFileStat.Size() > 0 {
-		loadedOptions, err := LoadConfigFile(&newArgs, workspaceFilePath)
-		if err != nil {
-			return Args{}, err
-		}
-
-		newArgs.WorkspaceOptions = Merge(newArgs.WorkspaceOptions, loadedOptions)
-	}
-
-	// Load any additional dependencies (e.g. from github).
-	newArgs.ExternalRepos = make(map[string]*ExternalRepo)
-	externalRepos, ok := newArgs.WorkspaceOptions[newArgs.ExternalRepoKey]
-	if ok {
-		for repoPath, repoJsonInt := range externalRepos.(map[string]interface{}) {
-			repoJson := repoJsonInt.(map[string]interface{})
-
-			// Get platform specific information.
-			platformRepoJson, ok := repoJson[runtime.GOOS]
-			if ok {
-				repoJson = Merge(repoJson, platformRepoJson.(map[string]interface{}))
-			}
-
-			// Load some basic information about the external repo.
-			externalRepo, err := MakeExternalRepo(repoPath, repoJson)
-			if err != nil {
-				return Args{}, err
-			}
-
-			// Load platform specific options, and then merge.
-			newArgs.ExternalRepos[repoPath] = externalRepo
-		}
-	}
-
-	// Load OS specific options.
-	workspaceOptions, ok := newArgs.WorkspaceOptions[runtime.GOOS]
-	if ok {
-		newArgs.WorkspaceOptions = Merge(
-			newArgs.WorkspaceOptions, workspaceOptions.(map[string]interface{}))
-		configurationOptions, ok := newArgs.WorkspaceOptions[newArgs.Configuration]
-		if ok {
-			newArgs.ConfigurationOptions = configurationOptions.(map[string]interface{})
-		}
-	}
-
-	// Load OutputDir based on WorkspaceDir.
-	if !filepath.IsAbs(newArgs.OutputDir) {
-		newArgs.OutputDir = filepath.Join(newArgs.WorkspaceDir, newArgs.OutputDir)
-		if newArgs.Configuration != "" {
-			newArgs.OutputDir = filepath.Join(newArgs.OutputDir, newArgs.Configuration)
-		}
-	}
-
-	// Load GenOutputDir based on OutputDir.
-	if !filepath.IsAbs(newArgs.GenOutputDir) {
-		newArgs.GenOutputDir = filepath.Join(newArgs.OutputDir, newArgs.GenOutputDir)
-	}
-
-	// Load the C++ compiler.
-	if newArgs.CCCompiler == "" {
-		if runtime.GOOS == "windows" {
-			newArgs.CCCompiler = "cl.exe"
-		} else if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
-			newArgs.CCCompiler = "clang++"
-		} else {
-			return Args{}, errors.New(
-				fmt.Sprintf("Could not set C++ compiler: unknown OS %s", runtime.GOOS))
-		}
-	}
-
-	return newArgs, nil
-}
+// Args is where all flags used anywhere are defined. This is useful for many
+// flags which have no clear point at which they can be defined, and provides a
+// simple way of adding new flags.
+package args
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/hjson/hjson-go"
+)
+
+type Args struct {
+	// General flags.
+	DryRun bool
+
+	// Input/Output options.
+	OutputDir    string
+	GenOutputDir string
+	WorkspaceDir string
+
+	// Workspace options.
+	BaseWorkspaceFiles string
+	WorkspaceFilename  string
+	ExternalRepoKey    string
+	ExternalRepoDir    string
+	UpdateExternals    bool
+	CleanExternalRepos bool
+	BuildFilename      string
+	Offline            bool
+	VendorDir          string
+
+	// Display options.
+	ShowLog           bool
+	ShowCommands      bool
+	ShowCommandEnv    bool
+	UseSimpleProgress bool
+
+	// Formatting options.
+	FormatCheck bool
+	FixDeps     bool
+
+	// Processing options.
+	Threads       int
+	Configuration string
+	Watch         bool
+	Server        bool
+	Profile       string
+
+	// Resource options.
+	LocalCpuResources float64
+	LocalRamResources int
+
+	// Build event options.
+	BuildEventJsonFile string
+
+	// Remote execution options.
+	RemoteExecutor string
+	WorkerAddress  string
+
+	// Action options.
+	ActionEnv      []string
+	ActionCacheDir string
+
+	// Testing options.
+	ForceRunTests bool
+	TestRuns      uint
+	TestOutput    string
+	TestThreads   int
+
+	// C++ options.
+	CCCompiler    string
+	LayeringCheck bool
+	Reproducible  bool
+	Stamp         bool
+
+	// Testing options.
+	NoCache bool
+
+	// Not actual arguments, but still useful.
+	CurrentDir string
+
+	// If set, a depfile listing the headers used is written for each C++ object.
+	WriteDepfiles bool
+
+	// If set, watch mode stops after building this many times.
+	WatchBuilds int
+
+	// Windows options.
+	VCVersion string
+
+	// External repos that need to be loaded. Once loaded, this map should contain
+	// a mapping from the local workspace path --> BUILD file text. The local path
+	// must be absolute.
+	ExternalRepos map[string]*ExternalRepo
+
+	// The vendored copies of external repos, from the vendor manifest. These are
+	// used in place of the repos with the same path whenever they are requested.
+	VendoredRepos map[string]*ExternalRepo
+
+	// The WORKSPACE file loaded.
+	WorkspaceOptions     map[string]interface{}
+	ConfigurationOptions map[string]interface{}
+}
+
+// All flags are defined using flag.xVar(&flag, ...). This generally makes them
+// easier to use, because pointers are smelly.
+var (
+	args Args
+
+	// The names of the flags defined above.
+	flagNames []string
+)
+
+func init() {
+	// General flags.
+	flag.BoolVar(&args.DryRun, "dry_run", false,
+		"Enable DryRun mode. When DryRun is enabled, no changes will be made to "+
+			"filesystem, but all target dependency resolution will be performed. This "+
+			"is mostly useful for testing.")
+
+	// Input/Output options.
+	flag.StringVar(&args.OutputDir, "output_dir", "bin",
+		"The output directory in which all processed files will be placed. Can be "+
+			"absolute or relative to the workspace directory.")
+
+	flag.StringVar(&args.GenOutputDir, "gen_output_dir", "gen",
+		"The output directory in which all generated files will be placed. Can be "+
+			"absolute or relative to the output directory.")
+
+	flag.StringVar(&args.WorkspaceDir, "workspace_dir", "",
+		"The root directory of the workspace. If blank, the directory tree will be "+
+			"scanned for a WORKSPACE file. The filename searched for can be configured "+
+			"using the workspace_filename flag.")
+
+	// Workspace options.
+	flag.StringVar(&args.BaseWorkspaceFiles, "base_workspace_files", "",
+		"A directory from which to load base WORKSPACE files. This allows "+
+			"standard configuration files (like boost) to be shipped with jbuild. "+
+			"The values in the project's WORKSPACE file take precedence over there. "+
+			"Files should be named XXX.workspace, where XXX signifies what is in "+
+			"the file.")
+
+	flag.StringVar(&args.WorkspaceFilename, "workspace_filename", "WORKSPACE",
+		"The name of the WORKSPACE file when looking for the workspace root.")
+
+	flag.StringVar(&args.ExternalRepoKey, "external_repo_key", "external",
+		"The key in the WORKSPACE file that will be loaded as an external repo "+
+			"list.")
+
+	flag.StringVar(&args.ExternalRepoDir, "external_repo_dir", "",
+		"The absolute path to the location to store external repos. If blank, defaults "+
+			"to a location within the user's home directory.")
+
+	flag.BoolVar(&args.UpdateExternals, "update_externals", false,
+		"If set to true, external repositories will be updated.")
+
+	flag.BoolVar(&args.CleanExternalRepos, "clean_external_repos", false,
+		"If set to true, remove external repos when cleaning.")
+
+	flag.StringVar(&args.BuildFilename, "build_filename", "BUILD",
+		"The name of the BUILD file specifying the targets in each directory.")
+
+	flag.BoolVar(&args.Offline, "offline", false,
+		"If set to true, never access the network. Any external repo which isn't "+
+			"already available locally (or vendored) will cause an error.")
+
+	flag.StringVar(&args.VendorDir, "vendor_dir", "third_party",
+		"The directory (relative to the workspace root) into which 'jbuild vendor' "+
+			"copies external repos. If it contains a vendor manifest, external repos "+
+			"are loaded from there rather than fetched.")
+
+	// Display options.
+	flag.BoolVar(&args.ShowLog, "show_log", false,
+		"If enabled, raw log messages will be shown rather than progress bars.")
+
+	flag.BoolVar(&args.ShowCommands, "show_commands", false,
+		"If enabled, the commands run will be printed to the display. These will "+
+			"only be visible if show_log is also enabled.")
+
+	flag.BoolVar(&args.ShowCommandEnv, "show_command_env", false,
+		"If enabled, the environment each command is run with will be printed "+
+			"along with it. These will only be visible if show_log is also "+
+			"enabled.")
+
+	flag.BoolVar(&args.UseSimpleProgress, "use_simple_progress", true,
+		"If enabled, use the simple (and reliable) progress bar system.")
+
+	// Formatting options.
+	flag.BoolVar(&args.FormatCheck, "check", false,
+		"If set, 'jbuild fmt' lists the BUILD files which aren't formatted (and "+
+			"fails if there are any) rather than rewriting them.")
+
+	flag.BoolVar(&args.FixDeps, "fix", false,
+		"If set, 'jbuild deps-check' rewrites the BUILD files to remove the unused "+
+			"deps it finds and add the missing ones.")
+
+	// Processing options.
+	flag.IntVar(&args.Threads, "threads", runtime.NumCPU(),
+		"Number of threads to use while processing targets.")
+
+	flag.StringVar(&args.Configuration, "c", "",
+		"The configuration to use when building. By default, no configuration is "+
+			"used (except for the common stuff).")
+
+	flag.BoolVar(&args.Watch, "watch", false,
+		"If set, 'jbuild build' and 'jbuild test' keep running, and rebuild (and "+
+			"retest) the targets affected each time a file they use changes.")
+
+	flag.BoolVar(&args.Server, "server", false,
+		"If set, commands are run by a background server for the workspace, "+
+			"which keeps the BUILD files loaded between commands. The server is "+
+			"started when first needed, and stopped with 'jbuild shutdown'.")
+
+	flag.StringVar(&args.Profile, "profile", "",
+		"If set, every command run while building and testing is recorded in "+
+			"this file in the Chrome trace event format (see chrome://tracing). "+
+			"'jbuild analyze-profile' summarizes it.")
+
+	// Resource options.
+	flag.Float64Var(&args.LocalCpuResources, "local_cpu_resources", 0,
+		"The number of CPUs the commands run while building can use at once, "+
+			"going by their estimates. Defaults to the number of threads.")
+
+	flag.IntVar(&args.LocalRamResources, "local_ram_resources", 0,
+		"The amount of RAM (in MB) the commands run while building can use at "+
+			"once, going by their estimates. Defaults to two thirds of the "+
+			"physical memory where it is known, and no limit otherwise.")
+
+	// Build event options.
+	flag.StringVar(&args.BuildEventJsonFile, "build_event_json_file", "",
+		"If set, a stream of build events (targets configured and completed, "+
+			"commands started and finished, test results etc.) is written to this "+
+			"file as one JSON object per line, for CI systems to follow builds.")
+
+	// Test options.
+	flag.BoolVar(&args.ForceRunTests, "force_run_tests", false,
+		"If set, tests will be run even if cached results are available.")
+
+	flag.UintVar(&args.TestRuns, "test_runs", 1,
+		"The number of times to run each test.")
+
+	flag.StringVar(&args.TestOutput, "test_output", "errors",
+		"The verbosity of test output to show. 'all' means all output is shown, "+
+			"'errors' (default) means only show error output and 'none' means only "+
+			"show pass/fail status (no raw output).")
+
+	flag.IntVar(&args.TestThreads, "test_threads", runtime.NumCPU(),
+		"The number of threads to use when running tests. For accurate timing "+
+			"results, a small number should be used. For pass/fail results, any number "+
+			"can be used.")
+
+	// Remote execution options.
+	flag.StringVar(&args.RemoteExecutor, "remote_executor", "",
+		"The host:port of a worker (started with 'jbuild worker') to compile "+
+			"on. Anything which can't be compiled on the worker is compiled "+
+			"locally instead.")
+
+	flag.StringVar(&args.WorkerAddress, "worker_address", "localhost:8980",
+		"The address 'jbuild worker' listens on. Workers run whatever commands "+
+			"they are sent, so should only be reachable from trusted machines.")
+
+	// Action options.
+	flag.Var((*envFlag)(&args.ActionEnv), "action_env",
+		"An environment variable to pass to compile and link commands, as "+
+			"NAME=value or just NAME to use the current value. Can be given more "+
+			"than once. Other than these, commands only get the minimal "+
+			"environment defined by the toolchain.")
+
+	flag.StringVar(&args.ActionCacheDir, "action_cache_dir", "",
+		"The absolute path to the location to record how each output was built, "+
+			"so that it is rebuilt when its command changes. If blank, defaults "+
+			"to a location within the user's home directory.")
+
+	// C++ options.
+	flag.StringVar(&args.CCCompiler, "cc_compiler", "", "The C++ compiler to use.")
+
+	flag.BoolVar(&args.LayeringCheck, "layering_check", false,
+		"If set, compiling a C++ file fails if it includes a header from the "+
+			"workspace which isn't in the hdrs of its own target or a direct dep. "+
+			"Not supported with cl.exe.")
+
+	flag.BoolVar(&args.Reproducible, "reproducible", false,
+		"If set, C++ outputs don't depend on where the workspace, external repos "+
+			"or output directory are, or on when they were built, so that building "+
+			"the same sources always gives the same bytes.")
+
+	flag.BoolVar(&args.Stamp, "stamp", false,
+		"If set, targets which are stamped get the real git commit, build time "+
+			"and user (and the output of the workspace_status_command WORKSPACE "+
+			"option). Otherwise they get fixed values, so they aren't rebuilt "+
+			"every time.")
+
+	// Testing options.
+	flag.BoolVar(&args.NoCache, "no_cache", false,
+		"If set to true, no internal caching of any kind will be used. This is "+
+			"useful for testing.")
+
+	// Remember which flags are ours, so that ParseFlags doesn't touch any flags
+	// defined elsewhere.
+	flag.VisitAll(func(f *flag.Flag) {
+		flagNames = append(flagNames, f.Name)
+	})
+}
+
+// ParseFlags resets the flags to their default values and then parses them
+// from `arguments` (which shouldn't include the program name), returning the
+// arguments left over. The default args will reflect the parsed flags.
+func ParseFlags(arguments []string) ([]string, error) {
+	flags := flag.NewFlagSet("jbuild", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	for _, name := range flagNames {
+		f := flag.Lookup(name)
+		if err := f.Value.Set(f.DefValue); err != nil {
+			return nil, err
+		}
+
+		flags.Var(f.Value, f.Name, f.Usage)
+	}
+
+	if err := flags.Parse(arguments); err != nil {
+		return nil, err
+	}
+
+	return flags.Args(), nil
+}
+
+// FindWorkspaceDir returns the closest directory at or above `dir` which
+// contains a WORKSPACE file called `workspaceFilename`.
+func FindWorkspaceDir(dir, workspaceFilename string) (string, error) {
+	for dir != "" {
+		// Check if the workspace file exists in this directory.
+		if _, err := os.Stat(filepath.Join(dir, workspaceFilename)); err == nil {
+			return dir, nil
+		}
+
+		// Remove the last part of the path off.
+		var file string
+		dir, file = filepath.Split(dir)
+		dir = strings.TrimRight(dir, string(os.PathSeparator))
+
+		if file == "" {
+			break
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf(
+		"Could not find WORKSPACE file '%s' anywhere above the current directory.",
+		workspaceFilename))
+}
+
+// IsStarlarkConfig returns true iff the config file at `path` (containing
+// `content`) is written in Starlark rather than HJSON. Starlark files can be
+// identified by their extension, or by the fact that they parse as Starlark
+// (HJSON files never do, unless they are a plain JSON object).
+func IsStarlarkConfig(path string, content []byte) bool {
+	ext := filepath.Ext(path)
+	return ext == ".bzl" || ext == ".star" || looksLikeStarlark(path, content)
+}
+
+// LoadConfigFile loads the BUILD specification file located at `path` and
+// returns a generic key-value mapping as the result. The BUILD file is either
+// JSON (we use hjson to make the config easier to write) or Starlark, where
+// each target is defined by calling a rule function.
+func LoadConfigFile(args *Args, path string) (map[string]interface{}, error) {
+	var jsonContent []byte
+	var err error
+
+	if _, err = os.Stat(path); err != nil {
+		return nil, errors.New(fmt.Sprintf("Config file not found '%s'", path))
+	}
+
+	jsonContent, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Could not read config file '%s': %s", path, err))
+	}
+
+	if IsStarlarkConfig(path, jsonContent) {
+		return loadStarlarkConfig(args, path, jsonContent)
+	}
+
+	configJson := make(map[string]interface{})
+	err = hjson.Unmarshal(jsonContent, &configJson)
+	if err != nil {
+		// We can't tell which one it was meant to be, so report both errors.
+		_, starlarkErr := starlarkFileOptions.Parse(path, jsonContent, 0)
+		return nil, errors.New(fmt.Sprintf(
+			"Could not parse config file '%s' as HJSON (%s) or Starlark (%s)",
+			path, err, starlarkErr))
+	}
+
+	configPositions[path] = hjsonPositions(jsonContent)
+	return configJson, nil
+}
+
+// Get a reference to the default arguments.
+func DefaultArgs() Args {
+	return args
+}
+
+// Union two dictionaries together recursively. This will modify dst by merging
+// in all values within src. If values in dst are multi-valued (i.e. maps or
+// slices), everything in dst will be preserved. Single-value overrides will
+// still take place.
+func Merge(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		switch v.(type) {
+		case map[string]interface{}:
+			_, ok := dst[k]
+			if !ok {
+				dst[k] = make(map[string]interface{}, 0)
+			}
+
+			dst[k] = Merge(dst[k].(map[string]interface{}), v.(map[string]interface{}))
+
+		case []interface{}:
+			_, ok := dst[k]
+			if !ok {
+				dst[k] = make([]interface{}, 0)
+			}
+
+			dst[k] = append(dst[k].([]interface{}), v.([]interface{})...)
+
+		default:
+			dst[k] = v
+		}
+	}
+
+	return dst
+}
+
+// Load performs additional setup required to ensure the arguments are in a
+// consistent format. This involves making the paths absolute, finding the
+// workspace directory if necessary etc.
+func Load(cwd string, customArgs *Args) (Args, error) {
+	// Each build loads its own copy of any shared Starlark definitions.
+	resetStarlarkModules()
+
+	// Make a copy of the default args.
+	var newArgs Args
+	if customArgs != nil {
+		newArgs = *customArgs
+	} else {
+		newArgs = args
+	}
+
+	// Get the user's home directory.
+	usr, err := user.Current()
+	if err != nil {
+		return Args{}, err
+	}
+
+	// Load the CurrentDir flag.
+	newArgs.CurrentDir = cwd
+
+	// Load any base workspace files.
+	newArgs.WorkspaceOptions = make(map[string]interface{})
+	if newArgs.BaseWorkspaceFiles == "" {
+		newArgs.BaseWorkspaceFiles = filepath.Join(usr.HomeDir, ".jbuild")
+	} else {
+		if !filepath.IsAbs(newArgs.BaseWorkspaceFiles) {
+			newArgs.BaseWorkspaceFiles = filepath.Join(cwd, newArgs.BaseWorkspaceFiles)
+		}
+	}
+
+	// If the base workspace files dir doesn't exist, make it.
+	if exists, _ := os.Stat(newArgs.BaseWorkspaceFiles); exists == nil {
+		os.MkdirAll(newArgs.BaseWorkspaceFiles, 0755)
+	}
+
+	baseWorkspaceFiles, err := ioutil.ReadDir(newArgs.BaseWorkspaceFiles)
+	if err != nil {
+		return Args{}, err
+	}
+
+	for _, file := range baseWorkspaceFiles {
+		filePath := filepath.Join(newArgs.BaseWorkspaceFiles, file.Name())
+		if strings.HasSuffix(strings.ToLower(file.Name()), ".workspace") {
+			cfg, err := LoadConfigFile(&newArgs, filePath)
+			if err != nil {
+				return Args{}, err
+			}
+
+			newArgs.WorkspaceOptions = Merge(newArgs.WorkspaceOptions, cfg)
+		}
+	}
+
+	// Load the WorkspaceDir flag.
+	if newArgs.WorkspaceDir == "" {
+		newArgs.WorkspaceDir, err = FindWorkspaceDir(newArgs.CurrentDir, newArgs.WorkspaceFilename)
+		if err != nil {
+			return Args{}, err
+		}
+	}
+
+	// Load the ExternalRepoDir flag.
+	if newArgs.ExternalRepoDir == "" {
+		newArgs.ExternalRepoDir = filepath.Join(usr.HomeDir, ".jbuild", "external")
+	}
+
+	// Load the ActionCacheDir flag.
+	if newArgs.ActionCacheDir == "" {
+		newArgs.ActionCacheDir = filepath.Join(usr.HomeDir, ".jbuild", "actions")
+	}
+
+	// Load the workspace file.
+	workspaceFilePath := filepath.Join(newArgs.WorkspaceDir, newArgs.WorkspaceFilename)
+	workspaceFileStat, _ := os.Stat(workspaceFilePath)
+	if workspaceFileStat.Size() > 0 {
+		loadedOptions, err := LoadConfigFile(&newArgs, workspaceFilePath)
+		if err != nil {
+			return Args{}, err
+		}
+
+		newArgs.WorkspaceOptions = Merge(newArgs.WorkspaceOptions, loadedOptions)
+	}
+
+	// If the external repos have been vendored, use the vendored copies.
+	if !filepath.IsAbs(newArgs.VendorDir) {
+		newArgs.VendorDir = filepath.Join(newArgs.WorkspaceDir, newArgs.VendorDir)
+	}
+
+	newArgs.VendoredRepos = make(map[string]*ExternalRepo)
+	if err := loadVendorManifest(&newArgs); err != nil {
+		return Args{}, err
+	}
+
+	// Load any additional dependencies (e.g. from github). The dependencies of
+	// these repos are added as each repo is loaded.
+	newArgs.ExternalRepos = make(map[string]*ExternalRepo)
+	err = loadExternalRepos(
+		&newArgs, newArgs.WorkspaceDir, newArgs.WorkspaceOptions, WorkspaceRequester)
+	if err != nil {
+		return Args{}, err
+	}
+
+	if newArgs.Offline && newArgs.UpdateExternals {
+		return Args{}, errors.New("Cannot update external repos while offline.")
+	}
+
+	// Load OS specific options.
+	workspaceOptions, ok := newArgs.WorkspaceOptions[runtime.GOOS]
+	if ok {
+		newArgs.WorkspaceOptions = Merge(
+			newArgs.WorkspaceOptions, workspaceOptions.(map[string]interface{}))
+		configurationOptions, ok := newArgs.WorkspaceOptions[newArgs.Configuration]
+		if ok {
+			newArgs.ConfigurationOptions = configurationOptions.(map[string]interface{})
+		}
+	}
+
+	// Load OutputDir based on WorkspaceDir.
+	if !filepath.IsAbs(newArgs.OutputDir) {
+		newArgs.OutputDir = filepath.Join(newArgs.WorkspaceDir, newArgs.OutputDir)
+		if newArgs.Configuration != "" {
+			newArgs.OutputDir = filepath.Join(newArgs.OutputDir, newArgs.Configuration)
+		}
+	}
+
+	// Load GenOutputDir based on OutputDir.
+	if !filepath.IsAbs(newArgs.GenOutputDir) {
+		newArgs.GenOutputDir = filepath.Join(newArgs.OutputDir, newArgs.GenOutputDir)
+	}
+
+	// Load the C++ compiler.
+	if newArgs.CCCompiler == "" {
+		if runtime.GOOS == "windows" {
+			newArgs.CCCompiler = "cl.exe"
+		} else if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+			newArgs.CCCompiler = "clang++"
+		} else {
+			return Args{}, errors.New(
+				fmt.Sprintf("Could not set C++ compiler: unknown OS %s", runtime.GOOS))
+		}
+	}
+
+	return newArgs, nil
+}
+
+// ConfigFiles returns the files which these args were loaded from: the base
+// workspace files, the WORKSPACE file, the vendor manifest and the WORKSPACE
+// file of each external repo loaded so far. Some of them might not exist.
+func (this *Args) ConfigFiles() []string {
+	paths := []string{this.BaseWorkspaceFiles}
+	if files, err := ioutil.ReadDir(this.BaseWorkspaceFiles); err == nil {
+		for _, file := range files {
+			if strings.HasSuffix(strings.ToLower(file.Name()), ".workspace") {
+				paths = append(paths, filepath.Join(this.BaseWorkspaceFiles, file.Name()))
+			}
+		}
+	}
+
+	paths = append(paths,
+		filepath.Join(this.WorkspaceDir, this.WorkspaceFilename), VendorManifestPath(this))
+	for _, repo := range this.ExternalRepos {
+		if !repo.loaded {
+			continue
+		}
+
+		repoDir := repo.FsDir
+		if repo.Type == LocalRepo {
+			repoDir = repo.LocalDir(this)
+		}
+
+		paths = append(paths, filepath.Join(repoDir, this.WorkspaceFilename))
+	}
+
+	return paths
+}
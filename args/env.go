@@ -0,0 +1,32 @@
+package args
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// An envFlag is a flag which can be given more than once, each time naming an
+// environment variable as NAME or NAME=value.
+type envFlag []string
+
+func (this *envFlag) String() string {
+	return strings.Join(*this, ",")
+}
+
+// Set adds the variable in `value`. An empty value clears the list, so that
+// the flag can be reset to its default.
+func (this *envFlag) Set(value string) error {
+	if value == "" {
+		*this = nil
+		return nil
+	}
+
+	name := strings.SplitN(value, "=", 2)[0]
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return errors.New(fmt.Sprintf("'%s' is not NAME or NAME=value", value))
+	}
+
+	*this = append(*this, value)
+	return nil
+}
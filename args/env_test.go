@@ -0,0 +1,27 @@
+package args
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvFlagCollectsVariables(t *testing.T) {
+	var env envFlag
+	assert.NoError(t, env.Set("CPATH"))
+	assert.NoError(t, env.Set("LANG=en_AU.UTF-8"))
+	assert.NoError(t, env.Set("EMPTY="))
+	assert.Equal(t, envFlag{"CPATH", "LANG=en_AU.UTF-8", "EMPTY="}, env)
+	assert.Equal(t, "CPATH,LANG=en_AU.UTF-8,EMPTY=", env.String())
+
+	// The default value resets it.
+	assert.NoError(t, env.Set(""))
+	assert.Empty(t, env)
+}
+
+func TestEnvFlagRejectsBadNames(t *testing.T) {
+	var env envFlag
+	assert.Error(t, env.Set("=value"))
+	assert.Error(t, env.Set("MY VAR=value"))
+	assert.Empty(t, env)
+}
@@ -1,180 +1,508 @@
-package args
-
-import (
-	"errors"
-	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
-)
-
-// An ExternalRepo structure, which contains all information required to build
-// and checkout an external repo.
-type ExternalRepo struct {
-	// The path this external repo should be presented as. Must be unique.
-	Path string
-
-	// The location of the external repo on the filesystem. Only populated after
-	// LoadExternalRepo is called.
-	FsDir string
-
-	// The URL to the git repository that contains the code. Should be https://
-	// for maximum compatability.
-	Url string
-
-	// The branch to checkout. This is anything that can be passed to the -b flag
-	// when checking out the code (e.g. a tag, branch). If blank, uses master.
-	Branch string
-
-	// A patch to apply to the repository after checking it out.
-	Patch string
-
-	// The build instructions needed to build this external repo. Can either be
-	// the raw BUILD contents or a filepath (relative to the workspace root).
-	Build     map[string]interface{}
-	BuildFile string
-}
-
-// MakeExternalRepo from a JSON map.
-func MakeExternalRepo(path string, repoJson map[string]interface{}) (*ExternalRepo, error) {
-	var url, branch, buildFile, patch string
-	var build map[string]interface{}
-	var err error
-
-	// Get the objects from the JSON.
-	urlInt, urlOk := repoJson["url"]
-	branchInt, branchOk := repoJson["branch"]
-	buildInt, buildOk := repoJson["build"]
-	patchInt, patchOk := repoJson["patch"]
-
-	if urlOk {
-		url = urlInt.(string)
-	} else {
-		return nil, errors.New("A URL must be specified for all external repos.")
-	}
-
-	if branchOk {
-		branch = branchInt.(string)
-	} else {
-		branch = "master"
-	}
-
-	if buildOk {
-		switch buildInt.(type) {
-		case string:
-			buildPath := buildInt.(string)
-			if !filepath.IsAbs(buildPath) {
-				buildPath = filepath.Join(args.WorkspaceDir, buildPath)
-			}
-
-			build, err = LoadConfigFile(&args, buildPath)
-			buildFile = buildInt.(string)
-			if err != nil {
-				return nil, err
-			}
-
-		case map[string]interface{}:
-			build = buildInt.(map[string]interface{})
-			buildFile = ""
-		}
-	} else {
-		// Assume it must be present in the external repo.
-		build = nil
-		buildFile = ""
-	}
-
-	if patchOk {
-		patch = patchInt.(string)
-	}
-
-	// Make and return the external repo.
-	externalRepo := new(ExternalRepo)
-	externalRepo.Path = path
-	externalRepo.Url = url
-	externalRepo.Branch = branch
-	externalRepo.Patch = patch
-	externalRepo.Build = build
-	externalRepo.BuildFile = buildFile
-	return externalRepo, nil
-}
-
-func fetchGit(args *Args, repo *ExternalRepo) error {
-	// If the directory doesn't exist, then clone.
-	gitDir := filepath.Join(args.ExternalRepoDir, strings.Trim(repo.Path, "/"))
-	repo.FsDir = gitDir
-	if _, err := os.Stat(gitDir); err != nil {
-		// Build the git command.
-		cmd := exec.Command("git", "clone", "--recurse-submodules", "-b", repo.Branch, repo.Url, gitDir)
-
-		// Save the command output.
-		cmd.Stdout = os.Stdout
-		// cmd.Stderr = os.Stderr
-
-		// Clone the repository.
-		fmt.Printf("Cloning into %s...\n", repo.Url)
-		err := cmd.Run()
-		if err != nil {
-			return err
-		}
-
-		// Patch the repo if necessary.
-		if repo.Patch != "" {
-			fmt.Printf("Patching %s...\n", repo.Path)
-
-			// Write a temporary patch file.
-			patchFilePath := filepath.Join(gitDir, "jbuild.patch")
-			patchFile, err := os.Create(patchFilePath)
-			if err != nil {
-				return err
-			}
-
-			defer os.Remove(patchFilePath)
-
-			if _, err := patchFile.Write([]byte(repo.Patch)); err != nil {
-				return err
-			}
-
-			if err := patchFile.Close(); err != nil {
-				return err
-			}
-
-			cmd := exec.Command("git", "apply", "jbuild.patch")
-			cmd.Dir = gitDir
-
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				return errors.New(string(output))
-			}
-		}
-	} else if args.UpdateExternals {
-		// Otherwise, update the git repo and checkout the branch.
-		gitPull := exec.Command("git", "pull", "origin", repo.Branch)
-		gitPull.Dir = gitDir
-
-		// Save the command output.
-		gitPull.Stdout = os.Stdout
-		gitPull.Stderr = os.Stderr
-
-		// Clone the repository.
-		fmt.Printf("Updating %s...\n", repo.Url)
-		err := gitPull.Run()
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// LoadExternalRepo will load the external repository specified by `repo`,
-// download it and load the corresponding BUILD file.
-func LoadExternalRepo(args *Args, repo *ExternalRepo) error {
-	// Fetch the repo.
-	err := fetchGit(args, repo)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
+package args
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The different kinds of external repo which can be fetched.
+type ExternalRepoType int
+
+const (
+	GitRepo ExternalRepoType = iota
+	ArchiveRepo
+	LocalRepo
+)
+
+// An ExternalRepo structure, which contains all information required to build
+// and checkout an external repo.
+type ExternalRepo struct {
+	// The kind of repo this is, which determines how it is fetched.
+	Type ExternalRepoType
+
+	// The path this external repo should be presented as. Must be unique.
+	Path string
+
+	// The location of the external repo on the filesystem. Only populated after
+	// LoadExternalRepo is called.
+	FsDir string
+
+	// The URL to the git repository that contains the code. Should be https://
+	// for maximum compatability.
+	Url string
+
+	// For local repos, the directory containing the code. Can be absolute or
+	// relative to the workspace root.
+	LocalPath string
+
+	// The branch to checkout. This is anything that can be passed to the -b flag
+	// when checking out the code (e.g. a tag, branch). If blank, uses master.
+	Branch string
+
+	// A patch to apply to the repository after checking it out.
+	Patch string
+
+	// A list of patch files (relative to the workspace root) to apply after
+	// checking out the repository, in order. PatchStrip is the number of leading
+	// path components to strip from the paths in each patch (i.e. -p).
+	Patches    []string
+	PatchStrip int
+
+	// Archive options. Urls is a list of mirrors which are tried in order; the
+	// downloaded archive must match Sha256 (if given). StripPrefix is a directory
+	// within the archive which becomes the root of the repo.
+	Urls        []string
+	Sha256      string
+	StripPrefix string
+
+	// The build instructions needed to build this external repo. Can either be
+	// the raw BUILD contents or a filepath (relative to the workspace root).
+	Build     map[string]interface{}
+	BuildFile string
+
+	// If this repo has been vendored, the repo as it was defined in the
+	// WORKSPACE file.
+	VendoredFrom *ExternalRepo
+
+	// The directory relative paths in this repo's definition are relative to. For
+	// repos defined in the WORKSPACE file this is "" (i.e. the workspace root);
+	// for repos requested by another external repo it is that repo's directory.
+	BaseDir string
+
+	// The repos which asked for this repo to be loaded. The workspace itself is
+	// WorkspaceRequester.
+	RequestedBy []string
+
+	// Whether this repo has been fetched (along with any repos it depends on).
+	loaded bool
+}
+
+// stringOption returns the value of the option `key` of the external repo
+// `path`, which must be a string.
+func stringOption(path, key string, value interface{}) (string, error) {
+	str, ok := value.(string)
+	if !ok {
+		return "", errors.New(fmt.Sprintf(
+			"Option '%s' of external repo '%s' must be a string, got %v", key, path, value))
+	}
+
+	return str, nil
+}
+
+// stringListOption returns the value of the option `key` of the external repo
+// `path`, which must be a list of strings.
+func stringListOption(path, key string, value interface{}) ([]string, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New(fmt.Sprintf(
+			"Option '%s' of external repo '%s' must be a list of strings, got %v", key, path, value))
+	}
+
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		str, err := stringOption(path, key, value)
+		if err != nil {
+			return nil, err
+		}
+
+		strs = append(strs, str)
+	}
+
+	return strs, nil
+}
+
+// isSha256 returns true iff `digest` is a hex encoded SHA-256 digest.
+func isSha256(digest string) bool {
+	return len(digest) == 64 && strings.Trim(digest, "0123456789abcdef") == ""
+}
+
+// isRelativePath returns true iff `path` is relative and stays below the
+// directory it is relative to.
+func isRelativePath(path string) bool {
+	clean := filepath.Clean(filepath.FromSlash(path))
+	return !filepath.IsAbs(clean) && !strings.HasPrefix(path, "/") && clean != ".." &&
+		!strings.HasPrefix(clean, ".."+string(os.PathSeparator))
+}
+
+// MakeExternalRepo from a JSON map.
+func MakeExternalRepo(path string, repoJson map[string]interface{}) (*ExternalRepo, error) {
+	return makeExternalRepo(args.WorkspaceDir, path, repoJson)
+}
+
+// makeExternalRepo from a JSON map, where relative paths are relative to
+// `baseDir`.
+func makeExternalRepo(baseDir, path string, repoJson map[string]interface{}) (*ExternalRepo, error) {
+	var url, branch, buildFile, patch, sha256, stripPrefix, localPath string
+	var urls, patches []string
+	var build map[string]interface{}
+	var err error
+	patchStrip := 1
+
+	// Get the objects from the JSON.
+	urlInt, urlOk := repoJson["url"]
+	urlsInt, urlsOk := repoJson["urls"]
+	branchInt, branchOk := repoJson["branch"]
+	buildInt, buildOk := repoJson["build"]
+	patchInt, patchOk := repoJson["patch"]
+	patchesInt, patchesOk := repoJson["patches"]
+	patchStripInt, patchStripOk := repoJson["patch_strip"]
+	sha256Int, sha256Ok := repoJson["sha256"]
+	stripPrefixInt, stripPrefixOk := repoJson["strip_prefix"]
+	localPathInt, localPathOk := repoJson["path"]
+
+	if urlOk {
+		url = urlInt.(string)
+		urls = append(urls, url)
+	}
+
+	if urlsOk {
+		mirrors, err := stringListOption(path, "urls", urlsInt)
+		if err != nil {
+			return nil, err
+		}
+
+		urls = append(urls, mirrors...)
+
+		if url == "" && len(urls) > 0 {
+			url = urls[0]
+		}
+	}
+
+	if localPathOk {
+		localPath, err = stringOption(path, "path", localPathInt)
+		if err != nil {
+			return nil, err
+		} else if url != "" {
+			return nil, errors.New(fmt.Sprintf(
+				"External repo '%s' cannot have both a path and a URL.", path))
+		}
+	} else if url == "" {
+		return nil, errors.New(
+			fmt.Sprintf("A URL must be specified for external repo '%s'.", path))
+	}
+
+	// The digest names the downloaded archive in the cache, so must be exactly
+	// a digest.
+	if sha256Ok {
+		sha256, err = stringOption(path, "sha256", sha256Int)
+		if err != nil {
+			return nil, err
+		}
+
+		sha256 = strings.ToLower(sha256)
+		if !isSha256(sha256) {
+			return nil, errors.New(fmt.Sprintf(
+				"Option 'sha256' of external repo '%s' must be 64 hex characters, got '%s'", path, sha256))
+		}
+	}
+
+	if stripPrefixOk {
+		stripPrefix, err = stringOption(path, "strip_prefix", stripPrefixInt)
+		if err != nil {
+			return nil, err
+		} else if !isRelativePath(stripPrefix) {
+			return nil, errors.New(fmt.Sprintf(
+				"Option 'strip_prefix' of external repo '%s' must be a path within the archive, got '%s'",
+				path, stripPrefix))
+		}
+	}
+
+	if branchOk {
+		branch = branchInt.(string)
+	} else {
+		branch = "master"
+	}
+
+	if buildOk {
+		switch buildInt.(type) {
+		case string:
+			buildPath := buildInt.(string)
+			if !filepath.IsAbs(buildPath) {
+				buildPath = filepath.Join(baseDir, buildPath)
+			}
+
+			build, err = LoadConfigFile(&args, buildPath)
+			buildFile = buildInt.(string)
+			if err != nil {
+				return nil, err
+			}
+
+		case map[string]interface{}:
+			build = buildInt.(map[string]interface{})
+			buildFile = ""
+		}
+	} else {
+		// Assume it must be present in the external repo.
+		build = nil
+		buildFile = ""
+	}
+
+	if patchOk {
+		patch, err = stringOption(path, "patch", patchInt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if patchesOk {
+		patches, err = stringListOption(path, "patches", patchesInt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if patchStripOk {
+		strip, ok := patchStripInt.(float64)
+		if !ok || strip < 0 || strip != float64(int(strip)) {
+			return nil, errors.New(fmt.Sprintf(
+				"Option 'patch_strip' of external repo '%s' must be a non-negative "+
+					"whole number, got %v", path, patchStripInt))
+		}
+
+		patchStrip = int(strip)
+	}
+
+	if localPathOk && (patchOk || patchesOk) {
+		return nil, errors.New(fmt.Sprintf(
+			"External repo '%s' is a local path, so cannot be patched.", path))
+	}
+
+	// Make and return the external repo.
+	externalRepo := new(ExternalRepo)
+	externalRepo.Path = path
+	externalRepo.Url = url
+	externalRepo.LocalPath = localPath
+	externalRepo.Urls = urls
+	externalRepo.Sha256 = sha256
+	externalRepo.StripPrefix = stripPrefix
+	externalRepo.Branch = branch
+	externalRepo.Patch = patch
+	externalRepo.Patches = patches
+	externalRepo.PatchStrip = patchStrip
+	externalRepo.Build = build
+	externalRepo.BuildFile = buildFile
+
+	// Anything which looks like an archive (or has a checksum) is downloaded
+	// rather than cloned.
+	if localPathOk {
+		externalRepo.Type = LocalRepo
+	} else if urlsOk || sha256Ok || stripPrefixOk || isArchiveUrl(url) {
+		externalRepo.Type = ArchiveRepo
+	} else {
+		externalRepo.Type = GitRepo
+	}
+
+	return externalRepo, nil
+}
+
+// baseDir returns the directory relative paths in this repo's definition are
+// relative to.
+func (this *ExternalRepo) baseDir(args *Args) string {
+	if this.BaseDir != "" {
+		return this.BaseDir
+	}
+
+	return args.WorkspaceDir
+}
+
+// LocalDir returns the absolute path to the directory a local repo points at.
+func (this *ExternalRepo) LocalDir(args *Args) string {
+	if filepath.IsAbs(this.LocalPath) {
+		return filepath.Clean(this.LocalPath)
+	}
+
+	return filepath.Join(this.baseDir(args), this.LocalPath)
+}
+
+// Version returns a description of which version of the code this repo refers
+// to. Two definitions of a repo with different versions conflict.
+func (this *ExternalRepo) Version() string {
+	switch this.Type {
+	case ArchiveRepo:
+		if this.Sha256 != "" {
+			return "sha256:" + this.Sha256
+		}
+
+		return strings.Join(this.Urls, ", ")
+
+	case LocalRepo:
+		if filepath.IsAbs(this.LocalPath) || this.BaseDir == "" {
+			return "path:" + filepath.Clean(this.LocalPath)
+		}
+
+		return "path:" + filepath.Join(this.BaseDir, this.LocalPath)
+	}
+
+	return this.Url + "@" + this.Branch
+}
+
+// IsFrozen returns true iff the contents of this repo are not expected to change
+// between builds. Only local repos are edited in place; everything else is only
+// changed by jbuild itself.
+func (this *ExternalRepo) IsFrozen() bool {
+	return this.Type != LocalRepo
+}
+
+// BuildFilePath returns the path to the file which defines the targets in this
+// repo, or "" if the targets can't change without the repo being re-fetched.
+func (this *ExternalRepo) BuildFilePath(args *Args) string {
+	if this.BuildFile != "" {
+		if filepath.IsAbs(this.BuildFile) {
+			return this.BuildFile
+		}
+
+		return filepath.Join(this.baseDir(args), this.BuildFile)
+	}
+
+	if this.Build == nil && !this.IsFrozen() {
+		return filepath.Join(this.LocalDir(args), args.BuildFilename)
+	}
+
+	return ""
+}
+
+// fetchLocal "fetches" a local repo by linking it into the external repo
+// directory. This means the repo looks exactly like any other external repo to
+// the rest of the build.
+func fetchLocal(args *Args, repo *ExternalRepo) error {
+	localDir := repo.LocalDir(args)
+	if stat, err := os.Stat(localDir); err != nil || !stat.IsDir() {
+		return errors.New(fmt.Sprintf(
+			"External repo '%s' points at '%s', which is not a directory",
+			repo.Path, localDir))
+	}
+
+	linkPath := filepath.Join(args.ExternalRepoDir, strings.Trim(repo.Path, "/"))
+	repo.FsDir = linkPath
+
+	// If the link is already there, then we are done.
+	if target, err := os.Readlink(linkPath); err == nil && target == localDir {
+		return nil
+	}
+
+	// Otherwise, replace whatever was there before (e.g. an old clone).
+	if err := os.RemoveAll(linkPath); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return err
+	}
+
+	return os.Symlink(localDir, linkPath)
+}
+
+// resetGit throws away any local changes to the git repo in `dir`, including
+// any patches which have been applied.
+func resetGit(dir string) error {
+	for _, gitArgs := range [][]string{{"reset", "--hard", "-q"}, {"clean", "-fdxq"}} {
+		cmd := exec.Command("git", gitArgs...)
+		cmd.Dir = dir
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return errors.New(string(output))
+		}
+	}
+
+	return nil
+}
+
+func fetchGit(args *Args, repo *ExternalRepo) error {
+	// Work out which patches should be applied.
+	patches, err := loadPatches(args, repo)
+	if err != nil {
+		return err
+	}
+
+	// If the directory doesn't exist, then clone.
+	gitDir := filepath.Join(args.ExternalRepoDir, strings.Trim(repo.Path, "/"))
+	repo.FsDir = gitDir
+	if _, err := os.Stat(gitDir); err != nil {
+		if args.Offline {
+			return errors.New(fmt.Sprintf(
+				"External repo '%s' has not been cloned and cannot be cloned while "+
+					"offline. Run 'jbuild vendor' while online to make it available.",
+				repo.Path))
+		}
+
+		// Build the git command.
+		cmd := exec.Command("git", "clone", "--recurse-submodules", "-b", repo.Branch, repo.Url, gitDir)
+
+		// Save the command output.
+		cmd.Stdout = os.Stdout
+		// cmd.Stderr = os.Stderr
+
+		// Clone the repository.
+		fmt.Printf("Cloning into %s...\n", repo.Url)
+		err := cmd.Run()
+		if err != nil {
+			return err
+		}
+	} else if args.UpdateExternals || appliedPatchDigest(gitDir) != patchDigest(repo, patches) {
+		// Throw away the old patches. They will be re-applied below.
+		if appliedPatchDigest(gitDir) != "" || len(patches) > 0 {
+			fmt.Printf("Resetting %s...\n", repo.Path)
+			if err := resetGit(gitDir); err != nil {
+				return err
+			}
+		}
+
+		if args.UpdateExternals {
+			// Otherwise, update the git repo and checkout the branch.
+			gitPull := exec.Command("git", "pull", "origin", repo.Branch)
+			gitPull.Dir = gitDir
+
+			// Save the command output.
+			gitPull.Stdout = os.Stdout
+			gitPull.Stderr = os.Stderr
+
+			// Clone the repository.
+			fmt.Printf("Updating %s...\n", repo.Url)
+			err := gitPull.Run()
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		return nil
+	}
+
+	// Patch the repo if necessary.
+	return applyPatches(repo, gitDir, patches)
+}
+
+// LoadExternalRepo will load the external repository specified by `repo`,
+// download it and register any external repos it depends on.
+func LoadExternalRepo(args *Args, repo *ExternalRepo) error {
+	if repo.loaded {
+		return nil
+	}
+
+	// Fetch the repo.
+	var err error
+	switch repo.Type {
+	case ArchiveRepo:
+		err = fetchArchive(args, repo)
+	case LocalRepo:
+		err = fetchLocal(args, repo)
+	default:
+		err = fetchGit(args, repo)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Find out which repos this repo needs.
+	if err := loadTransitiveExternalRepos(args, repo); err != nil {
+		return err
+	}
+
+	repo.loaded = true
+	return nil
+}
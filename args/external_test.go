@@ -0,0 +1,53 @@
+package args
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeExternalRepoLocalPath(t *testing.T) {
+	repo, err := makeExternalRepo("", "//third_party/lib", map[string]interface{}{"path": "../lib"})
+	require.NoError(t, err)
+	assert.Equal(t, LocalRepo, repo.Type)
+	assert.Equal(t, "../lib", repo.LocalPath)
+
+	_, err = makeExternalRepo("", "//third_party/lib", map[string]interface{}{"path": []interface{}{"../lib"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Option 'path' of external repo '//third_party/lib' must be a string")
+}
+
+func TestMakeExternalRepoRejectsBadPatchOptions(t *testing.T) {
+	for _, option := range []struct {
+		key, message string
+		value        interface{}
+	}{
+		{"patch", "Option 'patch' of external repo '//third_party/lib' must be a string", 1.0},
+		{"patches", "Option 'patches' of external repo '//third_party/lib' must be a list of strings", "fix.patch"},
+		{"patches", "Option 'patches' of external repo '//third_party/lib' must be a string", []interface{}{1.0}},
+		{"patch_strip", "Option 'patch_strip' of external repo '//third_party/lib'", "1"},
+		{"patch_strip", "Option 'patch_strip' of external repo '//third_party/lib'", -1.0},
+		{"patch_strip", "Option 'patch_strip' of external repo '//third_party/lib'", 1.5},
+	} {
+		_, err := makeExternalRepo("", "//third_party/lib", map[string]interface{}{
+			"url":      "https://example.com/lib.git",
+			"branch":   "main",
+			option.key: option.value,
+		})
+
+		require.Error(t, err, option.key)
+		assert.Contains(t, err.Error(), option.message)
+	}
+
+	repo, err := makeExternalRepo("", "//third_party/lib", map[string]interface{}{
+		"url":         "https://example.com/lib.git",
+		"branch":      "main",
+		"patches":     []interface{}{"fix.patch"},
+		"patch_strip": 0.0,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fix.patch"}, repo.Patches)
+	assert.Equal(t, 0, repo.PatchStrip)
+}
@@ -0,0 +1,862 @@
+package args
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hjson/hjson-go"
+)
+
+const (
+	// Lists which would make a line longer than this are split over several
+	// lines when formatting.
+	formatLineLength = 80
+)
+
+var (
+	// Fields whose values are sets, so are kept sorted and free of duplicates.
+	formatSortedFields = map[string]bool{"srcs": true, "deps": true}
+
+	// Keys which hold platform specific options rather than a field.
+	formatPlatformKeys = map[string]bool{"linux": true, "windows": true, "darwin": true}
+
+	// Matches quoteless values which HJSON reads as something other than a string.
+	hjsonLiteralRegexp = regexp.MustCompile(
+		`^(true|false|null|-?[0-9]+(\.[0-9]+)?([eE][+-]?[0-9]+)?)\s*($|[,\]}]|#|//|/\*)`)
+
+	// Matches keys which don't need to be quoted.
+	hjsonBareKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-./+@]+$`)
+)
+
+// The kinds of value in an HJSON file.
+const (
+	hjsonObject = iota
+	hjsonArray
+	hjsonString
+	hjsonLiteral
+)
+
+// An hjsonNode is a value within an HJSON file, along with the comments within
+// it.
+type hjsonNode struct {
+	kind int
+
+	// The members of an object or the items of an array.
+	entries []*hjsonEntry
+
+	// The value of a string, or a literal (e.g. a number) as it was written.
+	value string
+
+	// Comments after the last entry of an object or array.
+	closing []string
+}
+
+// An hjsonEntry is a member of an object or an item in an array.
+type hjsonEntry struct {
+	// Comments on the lines before the entry. The first `header` of these are
+	// separated from the entry by a blank line.
+	comments []string
+	header   int
+
+	// The key of an object member ("" for array items).
+	key   string
+	value *hjsonNode
+
+	// A comment on the same line as the end of the entry.
+	trailing string
+}
+
+// hjsonParser reads an HJSON file into hjsonNodes. The file must already be
+// known to be valid.
+type hjsonParser struct {
+	hjsonScanner
+}
+
+// readComment reads a single comment.
+func (this *hjsonParser) readComment() string {
+	start := this.offset
+	if this.hasPrefix("/*") {
+		this.skipUntil("*/")
+	} else {
+		for c := this.peek(); c != 0 && c != '\n'; c = this.peek() {
+			this.next()
+		}
+	}
+
+	return strings.TrimRight(string(this.content[start:this.offset]), " \t\r")
+}
+
+// readComments skips whitespace and separators, and returns the comments found
+// along the way. `header` is the number of those comments which are separated
+// from whatever follows them by a blank line.
+func (this *hjsonParser) readComments() (comments []string, header int) {
+	newlines := 0
+	for {
+		switch c := this.peek(); {
+		case c == '\n':
+			newlines++
+			this.next()
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			this.next()
+		case c == '#' || this.hasPrefix("//") || this.hasPrefix("/*"):
+			if newlines > 1 {
+				header = len(comments)
+			}
+
+			comments = append(comments, this.readComment())
+			newlines = 0
+		default:
+			if newlines > 1 {
+				header = len(comments)
+			}
+
+			return comments, header
+		}
+	}
+}
+
+// readTrailingComment returns the comment after a value on the same line, or ""
+// if there isn't one.
+func (this *hjsonParser) readTrailingComment() string {
+	for c := this.peek(); c == ' ' || c == '\t' || c == ','; c = this.peek() {
+		this.next()
+	}
+
+	if this.peek() == '#' || this.hasPrefix("//") || this.hasPrefix("/*") {
+		return this.readComment()
+	}
+
+	return ""
+}
+
+// readEntries reads the entries of an object or array up to (and including)
+// `end`, or the end of the file if `end` is 0.
+func (this *hjsonParser) readEntries(node *hjsonNode, end byte) *hjsonNode {
+	for {
+		comments, header := this.readComments()
+		if c := this.peek(); c == 0 || c == end {
+			this.next()
+			node.closing = comments
+			return node
+		}
+
+		entry := &hjsonEntry{comments: comments, header: header}
+		if node.kind == hjsonObject {
+			if c := this.peek(); c == '"' || c == '\'' {
+				entry.key = unescapeHjsonString(this.readString())
+			} else {
+				start := this.offset
+				for c := this.peek(); c != 0 && c != ':' && c != '\n'; c = this.peek() {
+					this.next()
+				}
+
+				entry.key = strings.TrimSpace(string(this.content[start:this.offset]))
+			}
+
+			for c := this.peek(); c == ' ' || c == '\t' || c == ':'; c = this.peek() {
+				this.next()
+			}
+
+			this.readComments()
+		}
+
+		entry.value = this.readValue()
+		entry.trailing = this.readTrailingComment()
+		node.entries = append(node.entries, entry)
+	}
+}
+
+// readValue reads a single value.
+func (this *hjsonParser) readValue() *hjsonNode {
+	switch c := this.peek(); c {
+	case '{':
+		this.next()
+		return this.readEntries(&hjsonNode{kind: hjsonObject}, '}')
+
+	case '[':
+		this.next()
+		return this.readEntries(&hjsonNode{kind: hjsonArray}, ']')
+
+	case '"', '\'':
+		col := this.col
+		if this.hasPrefix("'''") {
+			return &hjsonNode{kind: hjsonString, value: multilineHjsonString(this.readString(), col)}
+		}
+
+		return &hjsonNode{kind: hjsonString, value: unescapeHjsonString(this.readString())}
+	}
+
+	// Quoteless values run to the end of the line, unless they are a literal.
+	line := string(this.content[this.offset:])
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+
+	node := &hjsonNode{kind: hjsonString, value: strings.TrimSpace(line)}
+	if match := hjsonLiteralRegexp.FindStringSubmatch(line); match != nil {
+		node = &hjsonNode{kind: hjsonLiteral, value: match[1]}
+		line = match[1]
+	}
+
+	for i := 0; i < len(line); i++ {
+		this.next()
+	}
+
+	return node
+}
+
+// unescapeHjsonString returns the value of a quoted string, given the text
+// between the quotes.
+func unescapeHjsonString(raw string) string {
+	var value bytes.Buffer
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' || i+1 == len(raw) {
+			value.WriteByte(raw[i])
+			continue
+		}
+
+		i++
+		switch raw[i] {
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		case 't':
+			value.WriteByte('\t')
+		case 'u':
+			if i+5 > len(raw) {
+				break
+			}
+
+			if code, err := strconv.ParseUint(raw[i+1:i+5], 16, 32); err == nil {
+				value.WriteRune(rune(code))
+				i += 4
+			}
+		default:
+			value.WriteByte(raw[i])
+		}
+	}
+
+	return value.String()
+}
+
+// multilineHjsonString returns the value of a multiline string, given the text
+// between the quotes and the column the string started on. The indentation up
+// to that column is not part of the string.
+func multilineHjsonString(raw string, col int) string {
+	lines := strings.Split(strings.Replace(raw, "\r", "", -1), "\n")
+	if len(lines) > 1 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+
+	if len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for i, line := range lines {
+		for j := 1; j < col && line != "" && (line[0] == ' ' || line[0] == '\t'); j++ {
+			line = line[1:]
+		}
+
+		lines[i] = line
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                                Formatting                                  //
+////////////////////////////////////////////////////////////////////////////////
+
+// quoteHjsonString returns `value` as a double quoted string.
+func quoteHjsonString(value string) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+// isMultilineValue returns true iff `node` is written as a multiline string.
+func isMultilineValue(node *hjsonNode) bool {
+	return node.kind == hjsonString && strings.Contains(node.value, "\n") &&
+		!strings.Contains(node.value, "'''")
+}
+
+// canBeQuoteless returns true iff `value` reads the same when written without
+// quotes.
+func canBeQuoteless(value string) bool {
+	return value != "" && value == strings.TrimSpace(value) &&
+		!strings.ContainsAny(value, "\n#") && !strings.Contains(value, "//") &&
+		!strings.Contains(value, "/*") && !strings.ContainsAny(value[:1], "{}[],:\"'") &&
+		!hjsonLiteralRegexp.MatchString(value)
+}
+
+// hjsonPrinter writes hjsonNodes in the canonical format.
+type hjsonPrinter struct {
+	bytes.Buffer
+}
+
+func (this *hjsonPrinter) writeComments(indent string, comments []string) {
+	for _, comment := range comments {
+		this.WriteString(indent + comment + "\n")
+	}
+}
+
+// writeMultiline writes a multiline string, with every line indented by `indent`.
+func (this *hjsonPrinter) writeMultiline(value, indent string) {
+	this.WriteString(indent + "'''\n")
+	for _, line := range strings.Split(value, "\n") {
+		if line != "" {
+			this.WriteString(indent + line)
+		}
+
+		this.WriteString("\n")
+	}
+
+	this.WriteString(indent + "'''")
+}
+
+// inlineArray returns `node` written on a single line, if it can be.
+func inlineArray(node *hjsonNode) (string, bool) {
+	if len(node.closing) > 0 {
+		return "", false
+	}
+
+	items := make([]string, 0, len(node.entries))
+	for _, entry := range node.entries {
+		if len(entry.comments) > 0 || entry.trailing != "" || isMultilineValue(entry.value) {
+			return "", false
+		}
+
+		switch entry.value.kind {
+		case hjsonString:
+			items = append(items, quoteHjsonString(entry.value.value))
+		case hjsonLiteral:
+			items = append(items, entry.value.value)
+		default:
+			return "", false
+		}
+	}
+
+	return "[" + strings.Join(items, ", ") + "]", true
+}
+
+// writeValue writes `node`, which starts at `column` of a line indented by
+// `indent`. Strings in arrays are always quoted; other strings are only quoted
+// if they need to be.
+func (this *hjsonPrinter) writeValue(node *hjsonNode, indent string, column int, inArray bool) {
+	switch node.kind {
+	case hjsonObject:
+		if len(node.entries) == 0 && len(node.closing) == 0 {
+			this.WriteString("{}")
+			return
+		}
+
+		this.WriteString("{\n")
+		this.writeEntries(node, indent+"  ")
+		this.WriteString(indent + "}")
+
+	case hjsonArray:
+		if inline, ok := inlineArray(node); ok && column+len(inline) < formatLineLength {
+			this.WriteString(inline)
+			return
+		}
+
+		this.WriteString("[\n")
+		this.writeEntries(node, indent+"  ")
+		this.WriteString(indent + "]")
+
+	case hjsonString:
+		if !inArray && canBeQuoteless(node.value) {
+			this.WriteString(node.value)
+		} else {
+			this.WriteString(quoteHjsonString(node.value))
+		}
+
+	default:
+		this.WriteString(node.value)
+	}
+}
+
+// writeEntries writes each entry of `node` on its own line(s).
+func (this *hjsonPrinter) writeEntries(node *hjsonNode, indent string) {
+	for _, entry := range node.entries {
+		this.writeComments(indent, entry.comments)
+
+		prefix := indent
+		if node.kind == hjsonObject {
+			prefix += formatHjsonKey(entry.key) + ":"
+		}
+
+		if isMultilineValue(entry.value) {
+			if node.kind == hjsonObject {
+				this.WriteString(prefix + "\n")
+				this.writeMultiline(entry.value.value, indent+"  ")
+			} else {
+				this.writeMultiline(entry.value.value, indent)
+			}
+		} else {
+			if node.kind == hjsonObject {
+				prefix += " "
+			}
+
+			this.WriteString(prefix)
+			this.writeValue(entry.value, indent, len(prefix), node.kind == hjsonArray)
+		}
+
+		if node.kind == hjsonArray {
+			this.WriteString(",")
+		}
+
+		if entry.trailing != "" {
+			this.WriteString(" " + entry.trailing)
+		}
+
+		this.WriteString("\n")
+	}
+
+	this.writeComments(indent, node.closing)
+}
+
+// formatHjsonKey returns `key` as it should be written in an object.
+func formatHjsonKey(key string) string {
+	if hjsonBareKeyRegexp.MatchString(key) {
+		return key
+	}
+
+	return quoteHjsonString(key)
+}
+
+// sortArray sorts the strings in `node` and removes any duplicates. The
+// comments of duplicates are kept with the first copy.
+func sortArray(node *hjsonNode) {
+	for _, entry := range node.entries {
+		if entry.value.kind != hjsonString {
+			return
+		}
+	}
+
+	seen := make(map[string]*hjsonEntry)
+	entries := make([]*hjsonEntry, 0, len(node.entries))
+	for _, entry := range node.entries {
+		if first, ok := seen[entry.value.value]; ok {
+			first.comments = append(first.comments, entry.comments...)
+			if first.trailing == "" {
+				first.trailing = entry.trailing
+			}
+
+			continue
+		}
+
+		seen[entry.value.value] = entry
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].value.value < entries[j].value.value
+	})
+
+	node.entries = entries
+}
+
+// canonicalizeTarget moves the type of `target` to the front and sorts the
+// fields which are sets.
+func canonicalizeTarget(target *hjsonNode) {
+	for i, entry := range target.entries {
+		if entry.key == "type" {
+			entries := append([]*hjsonEntry{entry}, target.entries[:i]...)
+			target.entries = append(entries, target.entries[i+1:]...)
+			break
+		}
+	}
+
+	for _, entry := range target.entries {
+		if formatSortedFields[entry.key] && entry.value.kind == hjsonArray {
+			sortArray(entry.value)
+		} else if formatPlatformKeys[entry.key] && entry.value.kind == hjsonObject {
+			canonicalizeTarget(entry.value)
+		}
+	}
+}
+
+// canonicalizeTargetJson makes the same changes as canonicalizeTarget to a
+// target which has already been parsed, so the two can be compared.
+func canonicalizeTargetJson(target map[string]interface{}) {
+	for key, value := range target {
+		switch value.(type) {
+		case []interface{}:
+			if !formatSortedFields[key] {
+				continue
+			}
+
+			seen := make(map[string]bool)
+			items := make([]string, 0)
+			for _, item := range value.([]interface{}) {
+				if _, ok := item.(string); !ok {
+					items = nil
+					break
+				} else if !seen[item.(string)] {
+					seen[item.(string)] = true
+					items = append(items, item.(string))
+				}
+			}
+
+			if items != nil {
+				sort.Strings(items)
+				sortedItems := make([]interface{}, 0, len(items))
+				for _, item := range items {
+					sortedItems = append(sortedItems, item)
+				}
+
+				target[key] = sortedItems
+			}
+
+		case map[string]interface{}:
+			if formatPlatformKeys[key] {
+				canonicalizeTargetJson(value.(map[string]interface{}))
+			}
+		}
+	}
+}
+
+// FormatConfigFile returns the canonical formatting of the HJSON BUILD file at
+// `path`, which contains `content`. Targets are sorted by name, the type of
+// each target comes first, and sets of files or targets (i.e. srcs and deps)
+// are sorted and free of duplicates. Comments are kept with the value they
+// were written above (or next to).
+func FormatConfigFile(path string, content []byte) ([]byte, error) {
+	return formatConfigFile(path, content, nil)
+}
+
+// A DepsEdit is a change to the deps of a target in a BUILD file.
+type DepsEdit struct {
+	Remove []string
+	Add    []string
+}
+
+// editDeps returns `deps` with this edit applied, or nil if there aren't any
+// deps left.
+func (this DepsEdit) editDeps(deps []string) []string {
+	remove := make(map[string]bool)
+	for _, dep := range this.Remove {
+		remove[dep] = true
+	}
+
+	newDeps := make([]string, 0, len(deps)+len(this.Add))
+	for _, dep := range deps {
+		if !remove[dep] {
+			newDeps = append(newDeps, dep)
+		}
+	}
+
+	newDeps = append(newDeps, this.Add...)
+	if len(newDeps) == 0 {
+		return nil
+	}
+
+	return newDeps
+}
+
+// EditConfigDeps returns the HJSON BUILD file at `path` (which contains
+// `content`) with the deps of the targets in `edits` changed, formatted the
+// same way as FormatConfigFile. Only the deps which apply to every platform
+// are changed.
+func EditConfigDeps(path string, content []byte, edits map[string]DepsEdit) ([]byte, error) {
+	return formatConfigFile(path, content, func(root *hjsonNode, original map[string]interface{}) {
+		for _, entry := range root.entries {
+			edit, ok := edits[entry.key]
+			if !ok || entry.value.kind != hjsonObject {
+				continue
+			}
+
+			// Change the parsed target, which is what the result is checked against.
+			targetJson := original[entry.key].(map[string]interface{})
+			deps := make([]string, 0)
+			depsJson, _ := targetJson["deps"].([]interface{})
+			for _, dep := range depsJson {
+				if dep, ok := dep.(string); ok {
+					deps = append(deps, dep)
+				}
+			}
+
+			newDeps := edit.editDeps(deps)
+			if newDeps == nil {
+				delete(targetJson, "deps")
+			} else {
+				newDepsJson := make([]interface{}, 0, len(newDeps))
+				for _, dep := range newDeps {
+					newDepsJson = append(newDepsJson, dep)
+				}
+
+				targetJson["deps"] = newDepsJson
+			}
+
+			// Change the target in the file, keeping the comments of the deps which
+			// are still there.
+			var depsNode *hjsonNode
+			for i, field := range entry.value.entries {
+				if field.key == "deps" {
+					depsNode = field.value
+					if newDeps == nil {
+						entry.value.entries = append(entry.value.entries[:i], entry.value.entries[i+1:]...)
+					}
+
+					break
+				}
+			}
+
+			if newDeps == nil {
+				continue
+			} else if depsNode == nil {
+				depsNode = &hjsonNode{kind: hjsonArray}
+				entry.value.entries = append(entry.value.entries, &hjsonEntry{key: "deps", value: depsNode})
+			}
+
+			kept := make(map[string]bool)
+			for _, dep := range newDeps {
+				kept[dep] = true
+			}
+
+			items := make([]*hjsonEntry, 0, len(newDeps))
+			for _, item := range depsNode.entries {
+				if kept[item.value.value] {
+					items = append(items, item)
+				}
+			}
+
+			for _, dep := range edit.Add {
+				items = append(items, &hjsonEntry{value: &hjsonNode{kind: hjsonString, value: dep}})
+			}
+
+			depsNode.entries = items
+		}
+	})
+}
+
+// formatFieldOrder returns where the field `key` should go when it is added to
+// a target. The most important fields come first, then the rest by name.
+func formatFieldOrder(key string) string {
+	for i, field := range []string{"type", "srcs", "hdrs", "deps"} {
+		if key == field {
+			return fmt.Sprintf("%d", i)
+		}
+	}
+
+	return "~" + key
+}
+
+// hjsonNodeFor returns a node holding `value`, which is a string, a list of
+// strings or an object made of those.
+func hjsonNodeFor(value interface{}) *hjsonNode {
+	switch value := value.(type) {
+	case string:
+		return &hjsonNode{kind: hjsonString, value: value}
+
+	case []string:
+		node := &hjsonNode{kind: hjsonArray}
+		for _, item := range value {
+			node.entries = append(node.entries, &hjsonEntry{value: hjsonNodeFor(item)})
+		}
+
+		return node
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+
+		sort.Slice(keys, func(i, j int) bool {
+			return formatFieldOrder(keys[i]) < formatFieldOrder(keys[j])
+		})
+
+		node := &hjsonNode{kind: hjsonObject}
+		for _, key := range keys {
+			node.entries = append(node.entries, &hjsonEntry{key: key, value: hjsonNodeFor(value[key])})
+		}
+
+		return node
+	}
+
+	return &hjsonNode{kind: hjsonLiteral, value: fmt.Sprint(value)}
+}
+
+// jsonValueFor returns `value` (as passed to hjsonNodeFor) the way it would be
+// parsed from a config file.
+func jsonValueFor(value interface{}) interface{} {
+	switch value := value.(type) {
+	case []string:
+		items := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			items = append(items, item)
+		}
+
+		return items
+
+	case map[string]interface{}:
+		object := make(map[string]interface{})
+		for key, item := range value {
+			object[key] = jsonValueFor(item)
+		}
+
+		return object
+	}
+
+	return value
+}
+
+// UpdateConfigTargets returns the HJSON BUILD file at `path` (which contains
+// `content`, and may be empty) with the fields of each target in `targets`
+// set, formatted the same way as FormatConfigFile. Targets which don't exist
+// are added, and fields which aren't given are left alone. Field values must
+// be strings or lists of strings.
+func UpdateConfigTargets(path string, content []byte, targets map[string]map[string]interface{}) ([]byte, error) {
+	return formatConfigFile(path, content, func(root *hjsonNode, original map[string]interface{}) {
+		names := make([]string, 0, len(targets))
+		for name := range targets {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		for _, name := range names {
+			fields := targets[name]
+			var target *hjsonNode
+			for _, entry := range root.entries {
+				if entry.key == name && entry.value.kind == hjsonObject {
+					target = entry.value
+				}
+			}
+
+			targetJson, ok := original[name].(map[string]interface{})
+			if target == nil || !ok {
+				root.entries = append(root.entries, &hjsonEntry{key: name, value: hjsonNodeFor(fields)})
+				original[name] = jsonValueFor(fields)
+				continue
+			}
+
+			keys := make([]string, 0, len(fields))
+			for key := range fields {
+				keys = append(keys, key)
+			}
+
+			sort.Slice(keys, func(i, j int) bool {
+				return formatFieldOrder(keys[i]) < formatFieldOrder(keys[j])
+			})
+
+			for _, key := range keys {
+				targetJson[key] = jsonValueFor(fields[key])
+				found := false
+				for _, field := range target.entries {
+					if field.key == key {
+						field.value, found = hjsonNodeFor(fields[key]), true
+					}
+				}
+
+				if !found {
+					target.entries = append(target.entries, &hjsonEntry{key: key, value: hjsonNodeFor(fields[key])})
+				}
+			}
+		}
+	})
+}
+
+// formatConfigFile returns the canonical formatting of the HJSON BUILD file at
+// `path`, which contains `content`, after calling `edit` (if it isn't nil) to
+// change both the parsed file and the targets it contains.
+func formatConfigFile(path string, content []byte, edit func(root *hjsonNode, original map[string]interface{})) ([]byte, error) {
+	isEmpty := len(bytes.TrimSpace(content)) == 0
+	if isEmpty && edit == nil {
+		return []byte{}, nil
+	}
+
+	original := make(map[string]interface{})
+	if err := hjson.Unmarshal(content, &original); err != nil && !isEmpty {
+		return nil, errors.New(fmt.Sprintf("Could not parse config file '%s': %s", path, err))
+	}
+
+	parser := &hjsonParser{hjsonScanner{content: content, line: 1, col: 1}}
+	var root *hjsonNode
+	if comments, _ := parser.readComments(); parser.peek() == '{' {
+		parser.next()
+		root = parser.readEntries(&hjsonNode{kind: hjsonObject}, '}')
+		if len(root.entries) > 0 {
+			root.entries[0].comments = append(comments, root.entries[0].comments...)
+		}
+	} else {
+		parser.offset, parser.line, parser.col = 0, 1, 1
+		root = parser.readEntries(&hjsonNode{kind: hjsonObject}, 0)
+	}
+
+	if edit != nil {
+		edit(root, original)
+	}
+
+	// Comments at the top of the file, separated from the first target by a blank
+	// line, stay at the top.
+	var header []string
+	if len(root.entries) > 0 {
+		first := root.entries[0]
+		header, first.comments = first.comments[:first.header], first.comments[first.header:]
+	}
+
+	sort.SliceStable(root.entries, func(i, j int) bool {
+		return root.entries[i].key < root.entries[j].key
+	})
+
+	for _, entry := range root.entries {
+		if entry.value.kind == hjsonObject {
+			canonicalizeTarget(entry.value)
+		}
+	}
+
+	// Write each target, separated by blank lines.
+	printer := new(hjsonPrinter)
+	if len(header) > 0 {
+		printer.writeComments("", header)
+		printer.WriteString("\n")
+	}
+
+	for i, entry := range root.entries {
+		if i > 0 {
+			printer.WriteString("\n")
+		}
+
+		printer.writeEntries(&hjsonNode{kind: hjsonObject, entries: []*hjsonEntry{entry}}, "")
+	}
+
+	if len(root.closing) > 0 {
+		if len(root.entries) > 0 {
+			printer.WriteString("\n")
+		}
+
+		printer.writeComments("", root.closing)
+	}
+
+	// Make sure formatting didn't change anything it shouldn't have.
+	formatted := printer.Bytes()
+	for _, target := range original {
+		if targetJson, ok := target.(map[string]interface{}); ok {
+			canonicalizeTargetJson(targetJson)
+		}
+	}
+
+	check := make(map[string]interface{})
+	if err := hjson.Unmarshal(formatted, &check); err != nil || !reflect.DeepEqual(original, check) {
+		return nil, errors.New(fmt.Sprintf(
+			"Could not format config file '%s' without changing what it means", path))
+	}
+
+	return formatted, nil
+}
@@ -0,0 +1,220 @@
+package args
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatConfigFile(t *testing.T) {
+	formatted, err := FormatConfigFile("BUILD", []byte(`# Copyright notice.
+
+# The main binary.
+main: {
+  srcs: ['main.cc', "util.cc", 'main.cc']
+  "type": "c++/binary"
+  deps: [
+    ":lib" # Needed for printing.
+    "//other:lib"
+  ]
+  linux: {link_flags: ["-lm"], deps: [":b", ":a"]}
+}
+
+lib: {
+  type: c++/library
+  srcs: ["lib.cc"],
+  compile_flags: ["-DB", "-DA"]
+  // Generated below.
+  hdrs: []
+}
+
+gen: {
+  type: genrule
+  cmds: [
+    '''
+      echo "a"
+      echo "b"
+    '''
+  ]
+  out: ["a.h", "b.h", "c.h", "d.h", "e.h", "f.h", "g.h", "h.h", "i.h", "j.h", "k.h"]
+}
+
+# Trailing comment.
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, `# Copyright notice.
+
+gen: {
+  type: genrule
+  cmds: [
+    '''
+      echo "a"
+      echo "b"
+    ''',
+  ]
+  out: [
+    "a.h",
+    "b.h",
+    "c.h",
+    "d.h",
+    "e.h",
+    "f.h",
+    "g.h",
+    "h.h",
+    "i.h",
+    "j.h",
+    "k.h",
+  ]
+}
+
+lib: {
+  type: c++/library
+  srcs: ["lib.cc"]
+  compile_flags: ["-DB", "-DA"]
+  // Generated below.
+  hdrs: []
+}
+
+# The main binary.
+main: {
+  type: c++/binary
+  srcs: ["main.cc", "util.cc"]
+  deps: [
+    "//other:lib",
+    ":lib", # Needed for printing.
+  ]
+  linux: {
+    link_flags: ["-lm"]
+    deps: [":a", ":b"]
+  }
+}
+
+# Trailing comment.
+`, string(formatted))
+
+	// Formatting is stable.
+	again, err := FormatConfigFile("BUILD", formatted)
+	require.NoError(t, err)
+	assert.Equal(t, string(formatted), string(again))
+}
+
+func TestFormatConfigFileQuotesValuesWhichNeedIt(t *testing.T) {
+	formatted, err := FormatConfigFile("BUILD", []byte(`{
+  "my target": {
+    "type": "genrule",
+    "version": "1.0",
+    "cmd": "echo # not a comment",
+    "count": 3
+  }
+}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, `"my target": {
+  type: genrule
+  version: "1.0"
+  cmd: "echo # not a comment"
+  count: 3
+}
+`, string(formatted))
+}
+
+func TestFormatConfigFileInvalid(t *testing.T) {
+	_, err := FormatConfigFile("BUILD", []byte(`main: {`))
+	assert.Error(t, err)
+}
+
+func TestEditConfigDeps(t *testing.T) {
+	edited, err := EditConfigDeps("BUILD", []byte(`main: {
+  type: c++/binary
+  srcs: ["main.cc"]
+  deps: [
+    ":lib" # Needed for printing.
+    ":unused"
+  ]
+}
+
+lib: {
+  type: c++/library
+  deps: [":unused"]
+}
+
+unused: {
+  type: c++/library
+}
+`), map[string]DepsEdit{
+		"main":   {Remove: []string{":unused"}, Add: []string{"//base"}},
+		"lib":    {Remove: []string{":unused"}},
+		"unused": {Add: []string{":lib"}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, `lib: {
+  type: c++/library
+}
+
+main: {
+  type: c++/binary
+  srcs: ["main.cc"]
+  deps: [
+    "//base",
+    ":lib", # Needed for printing.
+  ]
+}
+
+unused: {
+  type: c++/library
+  deps: [":lib"]
+}
+`, string(edited))
+}
+
+func TestUpdateConfigTargets(t *testing.T) {
+	targets := map[string]map[string]interface{}{
+		"lib": {
+			"type": "c++/library",
+			"srcs": []string{"lib.cc"},
+			"hdrs": []string{"lib.h"},
+			"deps": []string{"//base"},
+		},
+		"main": {
+			"srcs": []string{"main.cc"},
+		},
+	}
+
+	updated, err := UpdateConfigTargets("BUILD", []byte(`# The main binary.
+main: {
+  type: c++/binary
+  srcs: ["old.cc"] # Replaced.
+  link_flags: ["-lm"]
+}
+`), targets)
+	require.NoError(t, err)
+
+	assert.Equal(t, `lib: {
+  type: c++/library
+  srcs: ["lib.cc"]
+  hdrs: ["lib.h"]
+  deps: ["//base"]
+}
+
+# The main binary.
+main: {
+  type: c++/binary
+  srcs: ["main.cc"] # Replaced.
+  link_flags: ["-lm"]
+}
+`, string(updated))
+
+	// Targets can be added to an empty file.
+	updated, err = UpdateConfigTargets("BUILD", []byte{}, map[string]map[string]interface{}{"lib": targets["lib"]})
+	require.NoError(t, err)
+	assert.Equal(t, `lib: {
+  type: c++/library
+  srcs: ["lib.cc"]
+  hdrs: ["lib.h"]
+  deps: ["//base"]
+}
+`, string(updated))
+}
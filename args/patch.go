@@ -0,0 +1,126 @@
+package args
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// The name of the file written to the root of a patched external repo. It
+	// contains the digest of the patches which have been applied.
+	PatchDigestFilename = ".jbuild_patches"
+)
+
+// A single patch to apply to an external repo.
+type patch struct {
+	// The name of the patch, for error messages.
+	name string
+
+	// The contents of the patch.
+	contents []byte
+}
+
+// loadPatches returns every patch which should be applied to `repo`, in the
+// order they should be applied. Inline patches are applied first.
+func loadPatches(args *Args, repo *ExternalRepo) ([]patch, error) {
+	patches := make([]patch, 0, len(repo.Patches)+1)
+	if repo.Patch != "" {
+		patches = append(patches, patch{"<inline patch>", []byte(repo.Patch)})
+	}
+
+	for _, patchFile := range repo.Patches {
+		patchPath := patchFile
+		if !filepath.IsAbs(patchPath) {
+			patchPath = filepath.Join(repo.baseDir(args), patchPath)
+		}
+
+		contents, err := ioutil.ReadFile(patchPath)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf(
+				"Could not read patch '%s' for external repo '%s': %s",
+				patchFile, repo.Path, err))
+		}
+
+		patches = append(patches, patch{patchFile, contents})
+	}
+
+	return patches, nil
+}
+
+// patchDigest returns a digest of the given patches, as applied to `repo`. If
+// there are no patches, the digest is blank.
+func patchDigest(repo *ExternalRepo, patches []patch) string {
+	if len(patches) == 0 {
+		return ""
+	}
+
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "-p%d\n", repo.PatchStrip)
+	for _, patch := range patches {
+		fmt.Fprintf(hasher, "%d\n", len(patch.contents))
+		hasher.Write(patch.contents)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// appliedPatchDigest returns the digest of the patches which have been applied
+// to the repo in `dir`.
+func appliedPatchDigest(dir string) string {
+	digest, err := ioutil.ReadFile(filepath.Join(dir, PatchDigestFilename))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(digest))
+}
+
+// applyPatches applies each patch to the repo in `dir` (in order) and then
+// records what was applied. `dir` must not have had any patches applied yet.
+func applyPatches(repo *ExternalRepo, dir string, patches []patch) error {
+	if len(patches) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Patching %s...\n", repo.Path)
+	for i, patch := range patches {
+		// Write the patch out to a temporary file. This is kept out of the repo so
+		// that it can't interfere with the patch itself.
+		patchFile, err := ioutil.TempFile("", "jbuild-patch-")
+		if err != nil {
+			return err
+		}
+
+		defer os.Remove(patchFile.Name())
+		_, err = patchFile.Write(patch.contents)
+		if closeErr := patchFile.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return err
+		}
+
+		cmd := exec.Command(
+			"git", "apply", "--verbose", fmt.Sprintf("-p%d", repo.PatchStrip), patchFile.Name())
+		cmd.Dir = dir
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"Could not apply patch %d/%d '%s' to external repo '%s':\n%s",
+				i+1, len(patches), patch.name, repo.Path,
+				strings.Replace(string(output), patchFile.Name(), patch.name, -1)))
+		}
+	}
+
+	return ioutil.WriteFile(
+		filepath.Join(dir, PatchDigestFilename), []byte(patchDigest(repo, patches)+"\n"), 0644)
+}
@@ -0,0 +1,226 @@
+package args
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testArchive       = "mathlib-1.0.tar.gz"
+	testArchiveSha256 = "a6d12827cee810287066f47a2630f25d325b3974899e1e06b3b33be584208074"
+)
+
+// makePatch makes a patch which replaces the string returned by passed().
+func makePatch(from, to string) string {
+	return strings.Join([]string{
+		"--- a/passed.cc",
+		"+++ b/passed.cc",
+		"@@ -3,3 +3,3 @@",
+		" const char* passed() {",
+		"-  return \"" + from + "\";",
+		"+  return \"" + to + "\";",
+		" }",
+		"",
+	}, "\n")
+}
+
+// setupPatchTest makes a workspace containing the test archive, and returns
+// the args to use along with a function to write patch files.
+func setupPatchTest(t *testing.T) (*Args, func(name, contents string)) {
+	workspaceDir, err := ioutil.TempDir("", "jbuild-workspace")
+	require.NoError(t, err)
+
+	archive, err := ioutil.ReadFile(
+		filepath.Join("..", "test", "20_external_archive", "archives", testArchive))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workspaceDir, testArchive), archive, 0644))
+
+	args := &Args{
+		WorkspaceDir:    workspaceDir,
+		ExternalRepoDir: filepath.Join(workspaceDir, "external"),
+		BuildFilename:   "BUILD",
+	}
+
+	writePatch := func(name, contents string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(workspaceDir, name), []byte(contents), 0644))
+	}
+
+	return args, writePatch
+}
+
+func makePatchedRepo(t *testing.T, patches ...string) *ExternalRepo {
+	patchesJson := make([]interface{}, 0, len(patches))
+	for _, patch := range patches {
+		patchesJson = append(patchesJson, patch)
+	}
+
+	repo, err := MakeExternalRepo("//third_party/mathlib", map[string]interface{}{
+		"url":          "file://" + testArchive,
+		"sha256":       testArchiveSha256,
+		"strip_prefix": "mathlib-1.0",
+		"patches":      patchesJson,
+	})
+
+	require.NoError(t, err)
+	return repo
+}
+
+func readPassed(t *testing.T, repo *ExternalRepo) string {
+	contents, err := ioutil.ReadFile(filepath.Join(repo.FsDir, "passed.cc"))
+	require.NoError(t, err)
+	return string(contents)
+}
+
+func TestPatchesAreAppliedInOrder(t *testing.T) {
+	args, writePatch := setupPatchTest(t)
+	defer os.RemoveAll(args.WorkspaceDir)
+
+	writePatch("1.patch", makePatch("PASSED", "PATCHED"))
+	writePatch("2.patch", makePatch("PATCHED", "PATCHED TWICE"))
+	repo := makePatchedRepo(t, "1.patch", "2.patch")
+	require.NoError(t, LoadExternalRepo(args, repo))
+	assert.Contains(t, readPassed(t, repo), "\"PATCHED TWICE\"")
+}
+
+func TestChangedPatchesAreReapplied(t *testing.T) {
+	args, writePatch := setupPatchTest(t)
+	defer os.RemoveAll(args.WorkspaceDir)
+
+	writePatch("fix.patch", makePatch("PASSED", "PATCHED"))
+	repo := makePatchedRepo(t, "fix.patch")
+	require.NoError(t, LoadExternalRepo(args, repo))
+	assert.Contains(t, readPassed(t, repo), "\"PATCHED\"")
+
+	// Changing the patch should give a fresh copy of the repo, with only the new
+	// patch applied. Repos are only loaded once per build, so this needs a new
+	// repo (as a new build would have).
+	writePatch("fix.patch", makePatch("PASSED", "REPATCHED"))
+	repo = makePatchedRepo(t, "fix.patch")
+	require.NoError(t, LoadExternalRepo(args, repo))
+	assert.Contains(t, readPassed(t, repo), "\"REPATCHED\"")
+
+	// Removing the patch should restore the original.
+	repo = makePatchedRepo(t)
+	require.NoError(t, LoadExternalRepo(args, repo))
+	assert.Contains(t, readPassed(t, repo), "\"PASSED\"")
+}
+
+func TestFailedPatchNamesThePatch(t *testing.T) {
+	args, writePatch := setupPatchTest(t)
+	defer os.RemoveAll(args.WorkspaceDir)
+
+	writePatch("good.patch", makePatch("PASSED", "PATCHED"))
+	writePatch("bad.patch", makePatch("NOT THERE", "PATCHED"))
+	repo := makePatchedRepo(t, "good.patch", "bad.patch")
+
+	err := LoadExternalRepo(args, repo)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "patch 2/2 'bad.patch'")
+	assert.Contains(t, err.Error(), "passed.cc")
+
+	// The failed extraction shouldn't have left anything behind.
+	_, err = os.Stat(repo.FsDir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// git runs git in `dir`, failing the test if it fails.
+func git(t *testing.T, dir string, gitArgs ...string) {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=jbuild", "-c", "user.email=jbuild@example.com"}, gitArgs...)...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+}
+
+// setupGitPatchTest makes a workspace next to a git repo containing passed.cc,
+// and returns the args to use, the URL of the git repo and a function to write
+// patch files.
+func setupGitPatchTest(t *testing.T) (*Args, string, func(name, contents string)) {
+	args, writePatch := setupPatchTest(t)
+	repoDir := filepath.Join(args.WorkspaceDir, "upstream")
+	require.NoError(t, os.MkdirAll(repoDir, 0755))
+	passed := "#include \"passed.h\"\n\nconst char* passed() {\n  return \"PASSED\";\n}\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(repoDir, "passed.cc"), []byte(passed), 0644))
+	git(t, repoDir, "init", "-q")
+	git(t, repoDir, "checkout", "-q", "-b", "main")
+	git(t, repoDir, "add", "passed.cc")
+	git(t, repoDir, "commit", "-q", "-m", "Add passed.cc")
+	return args, repoDir, writePatch
+}
+
+func makePatchedGitRepo(t *testing.T, url string, patches ...string) *ExternalRepo {
+	patchesJson := make([]interface{}, 0, len(patches))
+	for _, patch := range patches {
+		patchesJson = append(patchesJson, patch)
+	}
+
+	repo, err := MakeExternalRepo("//third_party/mathlib", map[string]interface{}{
+		"url":     url,
+		"branch":  "main",
+		"patches": patchesJson,
+	})
+
+	require.NoError(t, err)
+	return repo
+}
+
+func TestChangedPatchesAreReappliedToGitRepos(t *testing.T) {
+	args, url, writePatch := setupGitPatchTest(t)
+	defer os.RemoveAll(args.WorkspaceDir)
+
+	// The first patch also adds a file, which must be cleaned away when the
+	// patches change.
+	writePatch("fix.patch", makePatch("PASSED", "PATCHED")+strings.Join([]string{
+		"--- /dev/null",
+		"+++ b/extra.cc",
+		"@@ -0,0 +1 @@",
+		"+// Added by a patch.",
+		"",
+	}, "\n"))
+	repo := makePatchedGitRepo(t, url, "fix.patch")
+	require.NoError(t, LoadExternalRepo(args, repo))
+	assert.Contains(t, readPassed(t, repo), "\"PATCHED\"")
+	assert.FileExists(t, filepath.Join(repo.FsDir, "extra.cc"))
+
+	writePatch("fix.patch", makePatch("PASSED", "REPATCHED"))
+	repo = makePatchedGitRepo(t, url, "fix.patch")
+	require.NoError(t, LoadExternalRepo(args, repo))
+	assert.Contains(t, readPassed(t, repo), "\"REPATCHED\"")
+	_, err := os.Stat(filepath.Join(repo.FsDir, "extra.cc"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Removing the patch should restore the original.
+	repo = makePatchedGitRepo(t, url)
+	require.NoError(t, LoadExternalRepo(args, repo))
+	assert.Contains(t, readPassed(t, repo), "\"PASSED\"")
+	assert.Empty(t, appliedPatchDigest(repo.FsDir))
+}
+
+func TestFailedPatchToGitRepoIsReported(t *testing.T) {
+	args, url, writePatch := setupGitPatchTest(t)
+	defer os.RemoveAll(args.WorkspaceDir)
+
+	writePatch("good.patch", makePatch("PASSED", "PATCHED"))
+	writePatch("bad.patch", makePatch("NOT THERE", "PATCHED TWICE"))
+	repo := makePatchedGitRepo(t, url, "good.patch", "bad.patch")
+
+	err := LoadExternalRepo(args, repo)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "patch 2/2 'bad.patch'")
+	assert.Contains(t, err.Error(), "external repo '//third_party/mathlib'")
+	assert.Contains(t, err.Error(), "passed.cc")
+
+	// Once the bad patch is fixed, the patches should apply cleanly on top of
+	// the original, not on top of the half-patched repo.
+	writePatch("bad.patch", makePatch("PATCHED", "PATCHED TWICE"))
+	repo = makePatchedGitRepo(t, url, "good.patch", "bad.patch")
+	require.NoError(t, LoadExternalRepo(args, repo))
+	assert.Contains(t, readPassed(t, repo), "\"PATCHED TWICE\"")
+}
@@ -0,0 +1,231 @@
+package args
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// A Position is a location within a config file, used when reporting errors.
+// A Line of 0 means the position within the file is unknown.
+type Position struct {
+	Path string
+	Line int
+	Col  int
+}
+
+func (this Position) String() string {
+	if this.Line == 0 {
+		return this.Path
+	}
+
+	return fmt.Sprintf("%s:%d:%d", this.Path, this.Line, this.Col)
+}
+
+var (
+	// The position of each target and field within each config file loaded so
+	// far, keyed by path and then by the keys leading to the value joined with
+	// "." (e.g. "main.srcs").
+	configPositions = make(map[string]map[string]Position)
+)
+
+// ConfigPosition returns the position of the value found by following `keys`
+// in the config file at `path`. If that value's exact position isn't known, the
+// position of the closest enclosing value is used instead.
+func ConfigPosition(args *Args, path string, keys ...string) Position {
+	displayPath := path
+	if relPath, err := filepath.Rel(args.WorkspaceDir, path); err == nil && !strings.HasPrefix(relPath, "..") {
+		displayPath = relPath
+	}
+
+	positions := configPositions[path]
+	for i := len(keys); i > 0; i-- {
+		if position, ok := positions[strings.Join(keys[:i], ".")]; ok {
+			position.Path = displayPath
+			return position
+		}
+	}
+
+	return Position{Path: displayPath}
+}
+
+// hjsonScanner finds the position of each key in an HJSON file. It only needs
+// to understand enough of the format to track which object it is in; the file
+// has already been parsed properly, so it never reports errors.
+type hjsonScanner struct {
+	content   []byte
+	offset    int
+	line, col int
+	positions map[string]Position
+}
+
+func (this *hjsonScanner) peek() byte {
+	if this.offset >= len(this.content) {
+		return 0
+	}
+
+	return this.content[this.offset]
+}
+
+func (this *hjsonScanner) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(string(this.content[this.offset:]), prefix)
+}
+
+func (this *hjsonScanner) next() {
+	if this.peek() == '\n' {
+		this.line++
+		this.col = 1
+	} else {
+		this.col++
+	}
+
+	this.offset++
+}
+
+// skipUntil skips past the next occurrence of `end`.
+func (this *hjsonScanner) skipUntil(end string) {
+	for this.peek() != 0 && !this.hasPrefix(end) {
+		this.next()
+	}
+
+	for range end {
+		this.next()
+	}
+}
+
+// skipSpace skips whitespace, comments and separators.
+func (this *hjsonScanner) skipSpace() {
+	for {
+		switch c := this.peek(); {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ',':
+			this.next()
+		case c == '#' || this.hasPrefix("//"):
+			this.skipUntil("\n")
+		case this.hasPrefix("/*"):
+			this.skipUntil("*/")
+		default:
+			return
+		}
+	}
+}
+
+// readString reads a quoted string, including triple quoted multiline strings.
+func (this *hjsonScanner) readString() string {
+	quote := this.peek()
+	if quote == '\'' && this.hasPrefix("'''") {
+		start := this.offset + 3
+		this.skipUntil("'''")
+		this.skipUntil("'''")
+		return string(this.content[start : this.offset-3])
+	}
+
+	this.next()
+	start := this.offset
+	for c := this.peek(); c != 0 && c != quote && c != '\n'; c = this.peek() {
+		if c == '\\' {
+			this.next()
+		}
+
+		this.next()
+	}
+
+	value := string(this.content[start:this.offset])
+	this.next()
+	return value
+}
+
+// readMembers reads the members of an object. If `braced`, the object ends with
+// a closing brace; otherwise it is the braceless root object.
+func (this *hjsonScanner) readMembers(keys []string, braced bool) {
+	for {
+		this.skipSpace()
+		c := this.peek()
+		if c == 0 {
+			return
+		} else if c == '}' {
+			this.next()
+			if braced {
+				return
+			}
+
+			continue
+		}
+
+		position := Position{Line: this.line, Col: this.col}
+		var key string
+		if c == '"' || c == '\'' {
+			key = this.readString()
+		} else {
+			start := this.offset
+			for c := this.peek(); c != 0 && c != ':' && c != '\n'; c = this.peek() {
+				this.next()
+			}
+
+			key = strings.TrimSpace(string(this.content[start:this.offset]))
+		}
+
+		for c := this.peek(); c == ' ' || c == '\t'; c = this.peek() {
+			this.next()
+		}
+
+		if this.peek() != ':' {
+			// Not something we understand; skip the line and carry on.
+			this.skipUntil("\n")
+			continue
+		}
+
+		this.next()
+		memberKeys := append(append([]string{}, keys...), key)
+		this.positions[strings.Join(memberKeys, ".")] = position
+		this.readValue(memberKeys)
+	}
+}
+
+// readValue reads a single value, recording the position of any keys within it.
+func (this *hjsonScanner) readValue(keys []string) {
+	this.skipSpace()
+	switch c := this.peek(); c {
+	case '{':
+		this.next()
+		this.readMembers(keys, true)
+
+	case '[':
+		this.next()
+		for {
+			this.skipSpace()
+			if c := this.peek(); c == 0 || c == '}' {
+				return
+			} else if c == ']' {
+				this.next()
+				return
+			}
+
+			this.readValue(keys)
+		}
+
+	case '"', '\'':
+		this.readString()
+
+	default:
+		// A quoteless value, which runs to the end of the line (or the end of the
+		// enclosing object or array).
+		for c := this.peek(); c != 0 && c != '\n' && c != ',' && c != '}' && c != ']'; c = this.peek() {
+			this.next()
+		}
+	}
+}
+
+// hjsonPositions returns the position of every key within an HJSON file.
+func hjsonPositions(content []byte) map[string]Position {
+	scanner := &hjsonScanner{content: content, line: 1, col: 1}
+	scanner.positions = make(map[string]Position)
+	scanner.skipSpace()
+	if scanner.peek() == '{' {
+		scanner.next()
+		scanner.readMembers(nil, true)
+	} else {
+		scanner.readMembers(nil, false)
+	}
+
+	return scanner.positions
+}
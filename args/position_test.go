@@ -0,0 +1,53 @@
+package args
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHjsonPositions(t *testing.T) {
+	positions := hjsonPositions([]byte(`# A comment.
+main: {
+  type: c++/binary
+  srcs: ["main.cc", "other.cc"]
+  "deps": [
+    ":lib" // trailing comment
+  ]
+
+  linux: { link_flags: ["-lm"] }
+}
+
+/* Another comment: with a colon. */
+lib: {
+  type: c++/library
+  cmds: [
+    '''
+      echo "{"
+    '''
+  ]
+  hdrs: ['lib.h']
+}
+`))
+
+	assert.Equal(t, Position{Line: 2, Col: 1}, positions["main"])
+	assert.Equal(t, Position{Line: 3, Col: 3}, positions["main.type"])
+	assert.Equal(t, Position{Line: 4, Col: 3}, positions["main.srcs"])
+	assert.Equal(t, Position{Line: 5, Col: 3}, positions["main.deps"])
+	assert.Equal(t, Position{Line: 9, Col: 3}, positions["main.linux"])
+	assert.Equal(t, Position{Line: 9, Col: 12}, positions["main.linux.link_flags"])
+	assert.Equal(t, Position{Line: 13, Col: 1}, positions["lib"])
+	assert.Equal(t, Position{Line: 20, Col: 3}, positions["lib.hdrs"])
+}
+
+func TestConfigPositionFallsBackToEnclosingValue(t *testing.T) {
+	args := &Args{WorkspaceDir: "/workspace"}
+	configPositions["/workspace/dir/BUILD"] = map[string]Position{
+		"main":      {Line: 2, Col: 1},
+		"main.srcs": {Line: 4, Col: 3},
+	}
+
+	assert.Equal(t, "dir/BUILD:4:3", ConfigPosition(args, "/workspace/dir/BUILD", "main", "srcs").String())
+	assert.Equal(t, "dir/BUILD:2:1", ConfigPosition(args, "/workspace/dir/BUILD", "main", "deps").String())
+	assert.Equal(t, "dir/BUILD", ConfigPosition(args, "/workspace/dir/BUILD", "other").String())
+}
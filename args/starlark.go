@@ -0,0 +1,533 @@
+package args
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// The thread local which holds the config being built up. It is only set
+	// while a BUILD (or WORKSPACE) file is being executed.
+	starlarkConfigKey = "jbuild.config"
+)
+
+var (
+	// The functions available in Starlark BUILD files which define a target,
+	// mapped to the type of target they define.
+	starlarkRules = map[string]string{
+		"cc_binary":  "c++/binary",
+		"cc_library": "c++/library",
+		"cc_test":    "c++/test",
+		"filegroup":  "filegroup",
+		"genrule":    "genrule",
+		"doxygen":    "doxygen",
+
+		// Package groups aren't targets, but are defined the same way.
+		"package_group": "package_group",
+	}
+
+	// The options used when parsing Starlark files. BUILD files are configuration,
+	// so top-level if/for statements are allowed to keep them readable.
+	starlarkFileOptions = &syntax.FileOptions{
+		Set:             true,
+		TopLevelControl: true,
+	}
+
+	// The Starlark modules (e.g. .bzl files) loaded so far during this build,
+	// keyed by path. A nil module is still being loaded, and loadStack holds the
+	// modules currently being loaded (used to report cycles).
+	starlarkModules   = make(map[string]*starlarkModule)
+	starlarkLoadStack []string
+)
+
+// A starlarkModule is a loaded Starlark file which can be used from load().
+type starlarkModule struct {
+	globals starlark.StringDict
+	err     error
+}
+
+// starlarkConfig is the result of executing a Starlark config file. It is
+// built up as the rule functions are called.
+type starlarkConfig struct {
+	path   string
+	config map[string]interface{}
+
+	// Where each target and argument was defined, and every call in the file
+	// keyed by the position of its opening parenthesis.
+	positions map[string]Position
+	calls     map[[2]int32]*syntax.CallExpr
+}
+
+// toGo converts a Starlark value into the same kind of value that would have
+// been loaded from an HJSON file.
+func toGo(value starlark.Value) (interface{}, error) {
+	switch value := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+
+	case starlark.Bool:
+		return bool(value), nil
+
+	case starlark.Int:
+		i, ok := value.Int64()
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("integer %s is too large", value))
+		}
+
+		return float64(i), nil
+
+	case starlark.Float:
+		return float64(value), nil
+
+	case starlark.String:
+		return string(value), nil
+
+	case starlark.Indexable:
+		// Lists and tuples.
+		list := make([]interface{}, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			item, err := toGo(value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, item)
+		}
+
+		return list, nil
+
+	case *starlark.Dict:
+		dict := make(map[string]interface{}, value.Len())
+		for _, item := range value.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf(
+					"dict keys must be strings, got %s", item[0].Type()))
+			}
+
+			itemValue, err := toGo(item[1])
+			if err != nil {
+				return nil, err
+			}
+
+			dict[string(key)] = itemValue
+		}
+
+		return dict, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("cannot use a %s in a BUILD file", value.Type()))
+}
+
+// kwargsToGo converts the keyword arguments of a call into a JSON-like map,
+// skipping any arguments which are None.
+func kwargsToGo(kwargs []starlark.Tuple) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(kwargs))
+	for _, kwarg := range kwargs {
+		key := string(kwarg[0].(starlark.String))
+		value, err := toGo(kwarg[1])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("argument '%s': %s", key, err))
+		}
+
+		if value != nil {
+			result[key] = value
+		}
+	}
+
+	return result, nil
+}
+
+// currentConfig returns the config being built up by `thread`, or an error if
+// `fn` was called somewhere targets can't be defined (e.g. while loading a .bzl
+// file).
+func currentConfig(thread *starlark.Thread, fn *starlark.Builtin) (*starlarkConfig, error) {
+	config, ok := thread.Local(starlarkConfigKey).(*starlarkConfig)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf(
+			"%s: can only be called while loading a BUILD or WORKSPACE file", fn.Name()))
+	}
+
+	return config, nil
+}
+
+// starlarkRule returns the Starlark builtin which defines a target of type
+// `ruleType`.
+func starlarkRule(name, ruleType string) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		config, err := currentConfig(thread, fn)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(args) > 0 {
+			return nil, errors.New(fmt.Sprintf("%s: arguments must be passed by keyword", name))
+		}
+
+		target, err := kwargsToGo(kwargs)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %s", name, err))
+		}
+
+		targetName, ok := target["name"].(string)
+		if !ok || targetName == "" {
+			return nil, errors.New(fmt.Sprintf("%s: missing required argument 'name'", name))
+		}
+
+		if _, ok := config.config[targetName]; ok {
+			return nil, errors.New(fmt.Sprintf(
+				"%s: target '%s' is already defined in %s", name, targetName, config.path))
+		}
+
+		delete(target, "name")
+		target["type"] = ruleType
+		config.config[targetName] = target
+		config.recordPositions(thread, targetName)
+		return starlark.None, nil
+	})
+}
+
+// recordPositions records where the target `name` (and each of its arguments)
+// was defined. If the rule was called from a macro, the position of the macro
+// call is used instead.
+func (this *starlarkConfig) recordPositions(thread *starlark.Thread, name string) {
+	stack := thread.CallStack()
+	for i := len(stack) - 1; i >= 0; i-- {
+		pos := stack[i].Pos
+		if pos.Filename() != this.path {
+			continue
+		}
+
+		call, ok := this.calls[[2]int32{pos.Line, pos.Col}]
+		if !ok {
+			this.positions[name] = Position{Line: int(pos.Line), Col: int(pos.Col)}
+			return
+		}
+
+		start, _ := call.Fn.Span()
+		this.positions[name] = Position{Line: int(start.Line), Col: int(start.Col)}
+
+		// The arguments are only known if the rule was called directly (the last
+		// frame is the rule itself).
+		if i != len(stack)-2 {
+			return
+		}
+
+		for _, arg := range call.Args {
+			if binary, ok := arg.(*syntax.BinaryExpr); ok && binary.Op == syntax.EQ {
+				if ident, ok := binary.X.(*syntax.Ident); ok {
+					this.positions[name+"."+ident.Name] = Position{
+						Line: int(ident.NamePos.Line), Col: int(ident.NamePos.Col)}
+				}
+			}
+		}
+
+		return
+	}
+}
+
+// starlarkGlob implements glob(include, exclude, exclude_directories), which
+// expands to the files matching any of the include patterns but none of the
+// exclude patterns. The patterns are expanded when the target is loaded, the
+// same as "glob:" strings in HJSON BUILD files.
+func starlarkGlob(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var include *starlark.List
+	exclude := starlark.NewList(nil)
+	excludeDirectories := 1
+	err := starlark.UnpackArgs(fn.Name(), args, kwargs, "include", &include, "exclude?", &exclude,
+		"exclude_directories?", &excludeDirectories)
+	if err != nil {
+		return nil, err
+	}
+
+	// Options apply to each pattern, so they are added to each "glob:" string.
+	options := ""
+	for i := 0; i < exclude.Len(); i++ {
+		pattern, ok := starlark.AsString(exclude.Index(i))
+		if !ok {
+			return nil, errors.New(fmt.Sprintf(
+				"glob: exclude patterns must be strings, got %s", exclude.Index(i).Type()))
+		}
+
+		options += ";exclude=" + pattern
+	}
+
+	if excludeDirectories == 0 {
+		options += ";exclude_directories=0"
+	}
+
+	globs := make([]starlark.Value, 0, include.Len())
+	for i := 0; i < include.Len(); i++ {
+		pattern, ok := starlark.AsString(include.Index(i))
+		if !ok {
+			return nil, errors.New(fmt.Sprintf(
+				"glob: patterns must be strings, got %s", include.Index(i).Type()))
+		}
+
+		globs = append(globs, starlark.String("glob:"+pattern+options))
+	}
+
+	return starlark.NewList(globs), nil
+}
+
+// workspace implements workspace(), which sets top-level options, i.e. anything
+// other than a target (e.g. the external repos in a WORKSPACE file).
+func starlarkWorkspace(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	config, err := currentConfig(thread, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 0 {
+		return nil, errors.New("workspace: arguments must be passed by keyword")
+	}
+
+	options, err := kwargsToGo(kwargs)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("workspace: %s", err))
+	}
+
+	config.config = Merge(config.config, options)
+	return starlark.None, nil
+}
+
+// starlarkPackage implements package(default_visibility), which sets the
+// visibility of the targets in the BUILD file which don't set their own.
+func starlarkPackage(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	config, err := currentConfig(thread, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	var defaultVisibility *starlark.List
+	err = starlark.UnpackArgs(fn.Name(), args, kwargs, "default_visibility", &defaultVisibility)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := toGo(defaultVisibility)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("package: %s", err))
+	}
+
+	config.config["default_visibility"] = value
+	return starlark.None, nil
+}
+
+// starlarkPredeclared returns the functions available to Starlark files.
+func starlarkPredeclared() starlark.StringDict {
+	predeclared := starlark.StringDict{
+		"glob":      starlark.NewBuiltin("glob", starlarkGlob),
+		"package":   starlark.NewBuiltin("package", starlarkPackage),
+		"workspace": starlark.NewBuiltin("workspace", starlarkWorkspace),
+	}
+
+	ruleNames := make([]string, 0, len(starlarkRules))
+	for ruleName := range starlarkRules {
+		ruleNames = append(ruleNames, ruleName)
+	}
+
+	sort.Strings(ruleNames)
+	for _, ruleName := range ruleNames {
+		predeclared[ruleName] = starlarkRule(ruleName, starlarkRules[ruleName])
+	}
+
+	return predeclared
+}
+
+// looksLikeStarlark returns true iff `content` is a Starlark program. A plain
+// JSON object is also valid Starlark, but is treated as JSON.
+func looksLikeStarlark(path string, content []byte) bool {
+	file, err := starlarkFileOptions.Parse(path, content, 0)
+	if err != nil {
+		return false
+	}
+
+	for _, stmt := range file.Stmts {
+		if exprStmt, ok := stmt.(*syntax.ExprStmt); !ok {
+			return true
+		} else if _, ok := exprStmt.X.(*syntax.DictExpr); !ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// starlarkError returns `err` with a Starlark backtrace, if there is one.
+func starlarkError(err error) error {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return errors.New(evalErr.Backtrace())
+	}
+
+	return err
+}
+
+// loadRoot returns the root of the repo containing the file at `path` (the
+// directory of the external repo it is in, or the workspace) and a description
+// of it. The root is blank if the file isn't in any of them.
+func loadRoot(args *Args, path string) (string, string) {
+	root, description := "", ""
+	if args.WorkspaceDir != "" && insideDir(args.WorkspaceDir, path) {
+		root, description = args.WorkspaceDir, "the workspace"
+	}
+
+	for _, repo := range args.ExternalRepos {
+		dirs := []string{repo.FsDir}
+		if repo.Type == LocalRepo {
+			dirs = append(dirs, repo.LocalDir(args))
+		}
+
+		for _, dir := range dirs {
+			if dir != "" && insideDir(dir, path) && len(dir) > len(root) {
+				root, description = dir, fmt.Sprintf("external repo '%s'", repo.Path)
+			}
+		}
+	}
+
+	return root, description
+}
+
+// resolveLoadPath returns the path to the file `module` refers to when it is
+// loaded from the file at `fromPath`. Modules starting with // are relative to
+// the root of the repo containing the loading file (the workspace, or an
+// external repo), everything else is relative to the loading file. Modules
+// can't be loaded from outside that repo.
+func resolveLoadPath(args *Args, fromPath, module string) (string, error) {
+	root, description := loadRoot(args, filepath.Clean(fromPath))
+	var path string
+	if strings.HasPrefix(module, "//") {
+		base := root
+		if base == "" {
+			base = args.WorkspaceDir
+		}
+
+		relative := strings.Replace(strings.TrimPrefix(module, "//"), ":", "/", 1)
+		path = filepath.Join(base, filepath.FromSlash(relative))
+	} else {
+		relative := strings.TrimPrefix(module, ":")
+		path = filepath.Join(filepath.Dir(fromPath), filepath.FromSlash(relative))
+	}
+
+	if root != "" && !insideDir(root, path) {
+		return "", errors.New(fmt.Sprintf(
+			"Cannot load '%s': it is outside %s", module, description))
+	}
+
+	return path, nil
+}
+
+// newStarlarkThread makes a thread to execute the Starlark file at `path`.
+func newStarlarkThread(args *Args, path string) *starlark.Thread {
+	return &starlark.Thread{
+		Name: path,
+		Print: func(thread *starlark.Thread, msg string) {
+			fmt.Printf("%s: %s\n", thread.CallFrame(1).Pos, msg)
+		},
+		Load: func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+			modulePath, err := resolveLoadPath(args, path, module)
+			if err != nil {
+				return nil, err
+			}
+
+			return loadStarlarkModule(args, modulePath)
+		},
+	}
+}
+
+// resetStarlarkModules forgets every loaded module, so they are loaded again
+// the next time they are used.
+func resetStarlarkModules() {
+	starlarkModules = make(map[string]*starlarkModule)
+	starlarkLoadStack = nil
+}
+
+// StarlarkModulePaths returns the paths of the Starlark files loaded with
+// load() since the args were last loaded.
+func StarlarkModulePaths() []string {
+	paths := make([]string, 0, len(starlarkModules))
+	for path := range starlarkModules {
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+// loadStarlarkModule loads the Starlark file at `path` for use from load().
+// Each module is only executed once per build.
+func loadStarlarkModule(args *Args, path string) (starlark.StringDict, error) {
+	module, ok := starlarkModules[path]
+	if ok && module == nil {
+		cycle := []string{path}
+		for i := len(starlarkLoadStack) - 1; i >= 0 && starlarkLoadStack[i] != path; i-- {
+			cycle = append([]string{starlarkLoadStack[i]}, cycle...)
+		}
+
+		return nil, errors.New(fmt.Sprintf(
+			"load cycle: %s -> %s", path, strings.Join(cycle, " -> ")))
+	} else if ok {
+		return module.globals, module.err
+	}
+
+	starlarkModules[path] = nil
+	starlarkLoadStack = append(starlarkLoadStack, path)
+	defer func() {
+		starlarkLoadStack = starlarkLoadStack[:len(starlarkLoadStack)-1]
+	}()
+
+	module = new(starlarkModule)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		module.err = errors.New(fmt.Sprintf("Could not read '%s': %s", path, err))
+	} else {
+		module.globals, err = starlark.ExecFileOptions(
+			starlarkFileOptions, newStarlarkThread(args, path), path, content,
+			starlarkPredeclared())
+		module.err = starlarkError(err)
+	}
+
+	starlarkModules[path] = module
+	return module.globals, module.err
+}
+
+// loadStarlarkConfig executes the Starlark config file at `path` and returns
+// the targets and options it defines, in the same form as an HJSON config file.
+func loadStarlarkConfig(args *Args, path string, content []byte) (map[string]interface{}, error) {
+	predeclared := starlarkPredeclared()
+	file, program, err := starlark.SourceProgramOptions(
+		starlarkFileOptions, path, content, predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &starlarkConfig{
+		path:      path,
+		config:    make(map[string]interface{}),
+		positions: make(map[string]Position),
+		calls:     make(map[[2]int32]*syntax.CallExpr),
+	}
+
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if call, ok := node.(*syntax.CallExpr); ok {
+			config.calls[[2]int32{call.Lparen.Line, call.Lparen.Col}] = call
+		}
+
+		return true
+	})
+
+	thread := newStarlarkThread(args, path)
+	thread.SetLocal(starlarkConfigKey, config)
+	if _, err := program.Init(thread, predeclared); err != nil {
+		return nil, starlarkError(err)
+	}
+
+	configPositions[path] = config.positions
+	return config.config, nil
+}
@@ -0,0 +1,255 @@
+package args
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "jbuild-starlark")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "BUILD")
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestStarlarkRulesDefineTargets(t *testing.T) {
+	path := writeConfigFile(t, `
+COPTS = ["-Wall"]
+
+def my_test(name, **kwargs):
+    cc_test(name = name, compile_flags = COPTS, **kwargs)
+
+cc_library(name = "lib", srcs = glob(["*.cc"]), hdrs = ["lib.h"], linux = {"link_flags": ["-lm"]})
+my_test(name = "lib_test", srcs = ["lib_test.cc"], deps = [":lib"])
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := LoadConfigFile(&args, path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"lib": map[string]interface{}{
+			"type":  "c++/library",
+			"srcs":  []interface{}{"glob:*.cc"},
+			"hdrs":  []interface{}{"lib.h"},
+			"linux": map[string]interface{}{"link_flags": []interface{}{"-lm"}},
+		},
+		"lib_test": map[string]interface{}{
+			"type":          "c++/test",
+			"srcs":          []interface{}{"lib_test.cc"},
+			"deps":          []interface{}{":lib"},
+			"compile_flags": []interface{}{"-Wall"},
+		},
+	}, config)
+}
+
+func TestStarlarkWorkspaceOptions(t *testing.T) {
+	path := writeConfigFile(t, `
+workspace(external = {"//third_party/lib": {"path": "../lib", "patch_strip": 2}})
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := LoadConfigFile(&args, path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"external": map[string]interface{}{
+			"//third_party/lib": map[string]interface{}{"path": "../lib", "patch_strip": 2.0},
+		},
+	}, config)
+}
+
+func TestStarlarkGlobOptions(t *testing.T) {
+	path := writeConfigFile(t, `
+filegroup(
+    name = "files",
+    files = glob(["*.cc", "src/**"], exclude = ["*_test.cc"], exclude_directories = 0),
+)
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := LoadConfigFile(&args, path)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		"glob:*.cc;exclude=*_test.cc;exclude_directories=0",
+		"glob:src/**;exclude=*_test.cc;exclude_directories=0",
+	}, config["files"].(map[string]interface{})["files"])
+}
+
+func TestStarlarkPackageAndPackageGroups(t *testing.T) {
+	path := writeConfigFile(t, `
+package(default_visibility = [":friends"])
+
+package_group(name = "friends", packages = ["//friend/..."])
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := LoadConfigFile(&args, path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"default_visibility": []interface{}{":friends"},
+		"friends": map[string]interface{}{
+			"type":     "package_group",
+			"packages": []interface{}{"//friend/..."},
+		},
+	}, config)
+}
+
+func TestStarlarkErrorsHavePositions(t *testing.T) {
+	path := writeConfigFile(t, `
+cc_binary(name = "main", srcs = ["main.cc"])
+cc_binary(name = "main", srcs = ["other.cc"])
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := LoadConfigFile(&args, path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), path+":3:10")
+	assert.Contains(t, err.Error(), "target 'main' is already defined")
+}
+
+func TestInvalidConfigFileReportsBothFormats(t *testing.T) {
+	path := writeConfigFile(t, "hello_world: {\n  type: c++/binary\n  srcs: [\"main.cc\"\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := LoadConfigFile(&args, path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "HJSON")
+	assert.Contains(t, err.Error(), "Starlark")
+}
+
+func writeModule(t *testing.T, dir, name, contents string) {
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+}
+
+func TestStarlarkLoadMacros(t *testing.T) {
+	path := writeConfigFile(t, `
+load(":defs.bzl", "COPTS", "my_test")
+
+my_test(name = "a_test", srcs = ["a_test.cc"])
+cc_library(name = "lib", compile_flags = COPTS)
+`)
+	dir := filepath.Dir(path)
+	defer os.RemoveAll(dir)
+
+	writeModule(t, dir, "defs.bzl", `
+load("//flags.bzl", "FLAGS")
+
+COPTS = FLAGS
+
+def my_test(name, **kwargs):
+    cc_test(name = name, compile_flags = COPTS, **kwargs)
+`)
+	writeModule(t, dir, "flags.bzl", `FLAGS = ["-Wall"]`)
+
+	resetStarlarkModules()
+	config, err := LoadConfigFile(&Args{WorkspaceDir: dir}, path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a_test": map[string]interface{}{
+			"type":          "c++/test",
+			"srcs":          []interface{}{"a_test.cc"},
+			"compile_flags": []interface{}{"-Wall"},
+		},
+		"lib": map[string]interface{}{
+			"type":          "c++/library",
+			"compile_flags": []interface{}{"-Wall"},
+		},
+	}, config)
+
+	// Modules are only loaded once per build, so changing one has no effect...
+	writeModule(t, dir, "flags.bzl", `FLAGS = ["-Werror"]`)
+	config, err = LoadConfigFile(&Args{WorkspaceDir: dir}, path)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"-Wall"}, config["lib"].(map[string]interface{})["compile_flags"])
+
+	// ...until the next build.
+	resetStarlarkModules()
+	config, err = LoadConfigFile(&Args{WorkspaceDir: dir}, path)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"-Werror"}, config["lib"].(map[string]interface{})["compile_flags"])
+}
+
+func TestStarlarkLoadFromExternalRepo(t *testing.T) {
+	path := writeConfigFile(t, `load("//defs.bzl", "COPTS")
+cc_library(name = "lib", compile_flags = COPTS)`)
+	workspaceDir := filepath.Dir(path)
+	defer os.RemoveAll(workspaceDir)
+
+	// The repo has a BUILD file and defs.bzl of its own.
+	repoDir := filepath.Join(workspaceDir, "external", "lib")
+	require.NoError(t, os.MkdirAll(repoDir, 0755))
+	writeModule(t, workspaceDir, "defs.bzl", `COPTS = ["-DWORKSPACE"]`)
+	writeModule(t, repoDir, "defs.bzl", `COPTS = ["-DREPO"]`)
+	repoPath := filepath.Join(repoDir, "BUILD")
+	writeModule(t, repoDir, "BUILD", `load("//defs.bzl", "COPTS")
+cc_library(name = "lib", compile_flags = COPTS)`)
+
+	args := &Args{
+		WorkspaceDir: workspaceDir,
+		ExternalRepos: map[string]*ExternalRepo{
+			"//third_party/lib": {Path: "//third_party/lib", FsDir: repoDir},
+		},
+	}
+
+	// "//" refers to the root of the repo doing the loading.
+	resetStarlarkModules()
+	config, err := LoadConfigFile(args, path)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"-DWORKSPACE"}, config["lib"].(map[string]interface{})["compile_flags"])
+	config, err = LoadConfigFile(args, repoPath)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"-DREPO"}, config["lib"].(map[string]interface{})["compile_flags"])
+
+	// Nothing outside the repo can be loaded, however it is named.
+	for _, module := range []string{"//../../defs.bzl", "../../defs.bzl", ":../../defs.bzl"} {
+		writeModule(t, repoDir, "BUILD", `load("`+module+`", "COPTS")`)
+		resetStarlarkModules()
+		_, err = LoadConfigFile(args, repoPath)
+		require.Error(t, err, module)
+		assert.Contains(t, err.Error(), "Cannot load '"+module+"': it is outside external repo '//third_party/lib'")
+	}
+
+	// The same goes for the workspace.
+	require.NoError(t, ioutil.WriteFile(path, []byte(`load("//../defs.bzl", "COPTS")`), 0644))
+	resetStarlarkModules()
+	_, err = LoadConfigFile(args, path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "it is outside the workspace")
+}
+
+func TestStarlarkLoadCycle(t *testing.T) {
+	path := writeConfigFile(t, `load(":a.bzl", "A")`)
+	dir := filepath.Dir(path)
+	defer os.RemoveAll(dir)
+
+	writeModule(t, dir, "a.bzl", `load(":b.bzl", "B")
+A = B`)
+	writeModule(t, dir, "b.bzl", `load(":a.bzl", "A")
+B = A`)
+
+	resetStarlarkModules()
+	_, err := LoadConfigFile(&Args{WorkspaceDir: dir}, path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "load cycle")
+	assert.Contains(t, err.Error(), filepath.Join(dir, "a.bzl")+" -> "+filepath.Join(dir, "b.bzl"))
+}
+
+func TestStarlarkRulesCannotBeCalledWhileLoadingModules(t *testing.T) {
+	path := writeConfigFile(t, `load(":defs.bzl", "X")`)
+	dir := filepath.Dir(path)
+	defer os.RemoveAll(dir)
+
+	writeModule(t, dir, "defs.bzl", `cc_library(name = "lib")
+X = 1`)
+
+	resetStarlarkModules()
+	_, err := LoadConfigFile(&Args{WorkspaceDir: dir}, path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can only be called while loading a BUILD or WORKSPACE file")
+}
@@ -0,0 +1,159 @@
+package args
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+const (
+	// The requester recorded for repos defined in the workspace's own WORKSPACE
+	// file.
+	WorkspaceRequester = "//"
+)
+
+// loadExternalRepos makes an external repo for each entry in the external
+// section of `workspace` and registers it as having been requested by
+// `requestedBy`. Relative paths within the definitions are relative to
+// `baseDir`.
+func loadExternalRepos(args *Args, baseDir string, workspace map[string]interface{}, requestedBy string) error {
+	externalRepos, ok := workspace[args.ExternalRepoKey].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	// Register repos in a fixed order, so conflicts are always reported the same
+	// way.
+	repoPaths := make([]string, 0, len(externalRepos))
+	for repoPath := range externalRepos {
+		repoPaths = append(repoPaths, repoPath)
+	}
+
+	sort.Strings(repoPaths)
+	for _, repoPath := range repoPaths {
+		repoJson := externalRepos[repoPath].(map[string]interface{})
+
+		// Get platform specific information.
+		platformRepoJson, ok := repoJson[runtime.GOOS]
+		if ok {
+			repoJson = Merge(repoJson, platformRepoJson.(map[string]interface{}))
+		}
+
+		// Load some basic information about the external repo.
+		var externalRepo *ExternalRepo
+		var err error
+		if requestedBy == WorkspaceRequester {
+			externalRepo, err = MakeExternalRepo(repoPath, repoJson)
+		} else {
+			externalRepo, err = makeExternalRepo(baseDir, repoPath, repoJson)
+			if externalRepo != nil {
+				externalRepo.BaseDir = baseDir
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := registerExternalRepo(args, externalRepo, requestedBy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registerExternalRepo adds `repo` to the set of external repos, or records
+// that `requestedBy` also needs it if it is already known. Definitions in the
+// WORKSPACE file always win; otherwise two different versions of the same repo
+// are an error.
+func registerExternalRepo(args *Args, repo *ExternalRepo, requestedBy string) error {
+	existing, ok := args.ExternalRepos[repo.Path]
+	if !ok {
+		repo.RequestedBy = []string{requestedBy}
+
+		// If the repo has been vendored, use the vendored copy.
+		if vendored, ok := args.VendoredRepos[repo.Path]; ok {
+			vendoredRepo := *vendored
+			vendoredRepo.VendoredFrom = repo
+			vendoredRepo.RequestedBy = repo.RequestedBy
+			repo = &vendoredRepo
+		}
+
+		args.ExternalRepos[repo.Path] = repo
+		return nil
+	}
+
+	for _, requester := range existing.RequestedBy {
+		if requester == requestedBy {
+			return nil
+		}
+	}
+
+	// Compare against the repo as it was originally defined, not the vendored
+	// copy.
+	original := existing
+	if existing.VendoredFrom != nil {
+		original = existing.VendoredFrom
+	}
+
+	if original.Version() != repo.Version() && existing.RequestedBy[0] != WorkspaceRequester {
+		return errors.New(fmt.Sprintf(
+			"External repo '%s' is requested as %s by %s, but as %s by %s. Define it "+
+				"in the %s file to choose which version to use.",
+			repo.Path, original.Version(), existing.RequestedBy[0], repo.Version(),
+			requestedBy, args.WorkspaceFilename))
+	}
+
+	existing.RequestedBy = append(existing.RequestedBy, requestedBy)
+	return nil
+}
+
+// loadTransitiveExternalRepos registers the external repos requested by the
+// WORKSPACE file within `repo`, if it has one.
+func loadTransitiveExternalRepos(args *Args, repo *ExternalRepo) error {
+	repoDir := repo.FsDir
+	if repo.Type == LocalRepo {
+		repoDir = repo.LocalDir(args)
+	}
+
+	workspacePath := filepath.Join(repoDir, args.WorkspaceFilename)
+	if stat, err := os.Stat(workspacePath); err != nil || stat.IsDir() {
+		return nil
+	}
+
+	workspace, err := LoadConfigFile(args, workspacePath)
+	if err != nil {
+		return err
+	}
+
+	return loadExternalRepos(args, repoDir, workspace, repo.Path)
+}
+
+// ResolveExternalRepos loads every external repo, including the repos they
+// depend on, until there is nothing left to load.
+func ResolveExternalRepos(args *Args) error {
+	for {
+		repoPaths := make([]string, 0, len(args.ExternalRepos))
+		for repoPath, repo := range args.ExternalRepos {
+			if !repo.loaded {
+				repoPaths = append(repoPaths, repoPath)
+			}
+		}
+
+		if len(repoPaths) == 0 {
+			return nil
+		}
+
+		sort.Strings(repoPaths)
+		for _, repoPath := range repoPaths {
+			if err := LoadExternalRepo(args, args.ExternalRepos[repoPath]); err != nil {
+				return errors.New(fmt.Sprintf(
+					"Could not load external repo '%s': %s", repoPath, err))
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package args
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// The name of the manifest written by 'jbuild vendor'. It has the same format
+	// as the external section of a WORKSPACE file.
+	VendorManifestFilename = "vendor.workspace"
+)
+
+// VendorManifestPath returns the path to the vendor manifest.
+func VendorManifestPath(args *Args) string {
+	return filepath.Join(args.VendorDir, VendorManifestFilename)
+}
+
+// VendoredRepoDir returns the directory `repo` should be vendored into. Repos
+// which already live under the vendor directory (e.g. //third_party/foo) keep
+// their path, everything else is nested within it.
+func VendoredRepoDir(args *Args, repo *ExternalRepo) string {
+	repoPath := filepath.FromSlash(strings.Trim(repo.Path, "/"))
+	vendorDirRel, err := filepath.Rel(args.WorkspaceDir, args.VendorDir)
+	if err == nil && strings.HasPrefix(repoPath, vendorDirRel+string(os.PathSeparator)) {
+		return filepath.Join(args.WorkspaceDir, repoPath)
+	}
+
+	return filepath.Join(args.VendorDir, repoPath)
+}
+
+// WriteVendorManifest writes a vendor manifest which redirects every repo in
+// `repos` to its vendored copy.
+func WriteVendorManifest(args *Args, repos []*ExternalRepo) error {
+	externals := make(map[string]interface{}, len(repos))
+	for _, repo := range repos {
+		vendoredDir, err := filepath.Rel(args.WorkspaceDir, VendoredRepoDir(args, repo))
+		if err != nil {
+			return err
+		}
+
+		externals[repo.Path] = map[string]interface{}{
+			"path": filepath.ToSlash(vendoredDir),
+		}
+	}
+
+	manifest, err := json.MarshalIndent(
+		map[string]interface{}{args.ExternalRepoKey: externals}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(args.VendorDir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(VendorManifestPath(args), append(manifest, '\n'), 0644)
+}
+
+// loadVendorManifest loads a local repo for each vendored external repo, which
+// points at the vendored copy.
+func loadVendorManifest(args *Args) error {
+	manifestPath := VendorManifestPath(args)
+	if _, err := os.Stat(manifestPath); err != nil {
+		return nil
+	}
+
+	manifest, err := LoadConfigFile(args, manifestPath)
+	if err != nil {
+		return err
+	}
+
+	externals, ok := manifest[args.ExternalRepoKey].(map[string]interface{})
+	if !ok {
+		return errors.New(fmt.Sprintf(
+			"Vendor manifest '%s' has no '%s' section", manifestPath, args.ExternalRepoKey))
+	}
+
+	for repoPath, repoJson := range externals {
+		vendoredRepo, err := MakeExternalRepo(repoPath, repoJson.(map[string]interface{}))
+		if err != nil {
+			return err
+		}
+
+		args.VendoredRepos[repoPath] = vendoredRepo
+	}
+
+	return nil
+}
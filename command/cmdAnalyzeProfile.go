@@ -0,0 +1,201 @@
+package command
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+)
+
+const (
+	// The number of actions listed as the slowest.
+	slowestActions = 10
+
+	// The number of periods the build is split into when showing how many
+	// actions were running at once.
+	utilizationPeriods = 10
+)
+
+// A profileSpan is the time between when the first action for a target started
+// and when the last one finished.
+type profileSpan struct {
+	start, end time.Duration
+}
+
+// formatSeconds formats `d` as a number of seconds.
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
+
+// targetSpans returns the span of the actions run for each target.
+func targetSpans(profile *common.Profile) map[string]profileSpan {
+	spans := make(map[string]profileSpan)
+	for _, event := range profile.Events {
+		if event.Cached || event.Target == "" {
+			continue
+		}
+
+		span, ok := spans[event.Target]
+		if !ok || event.Start < span.start {
+			span.start = event.Start
+		}
+
+		if !ok || event.End() > span.end {
+			span.end = event.End()
+		}
+
+		spans[event.Target] = span
+	}
+
+	return spans
+}
+
+// criticalPath returns the chain of targets which held up the end of the build
+// the most, in the order they were built. It starts from the target which
+// finished last and repeatedly steps to the dependency which finished last.
+func criticalPath(profile *common.Profile, spans map[string]profileSpan) []string {
+	current := ""
+	for target, span := range spans {
+		if current == "" || span.end > spans[current].end ||
+			(span.end == spans[current].end && target < current) {
+			current = target
+		}
+	}
+
+	path := make([]string, 0)
+	seen := make(map[string]bool)
+	for current != "" && !seen[current] {
+		path = append([]string{current}, path...)
+		seen[current] = true
+
+		next := ""
+		for _, dep := range profile.Deps[current] {
+			if _, ok := spans[dep]; ok && (next == "" || spans[dep].end > spans[next].end) {
+				next = dep
+			}
+		}
+
+		current = next
+	}
+
+	return path
+}
+
+// printUtilization prints how many actions were running at once on average
+// during each period of the build.
+func printUtilization(profile *common.Profile, total time.Duration) {
+	slots := profile.Slots
+	if slots < 1 {
+		slots = 1
+	}
+
+	period := total / utilizationPeriods
+	if period <= 0 {
+		return
+	}
+
+	busy := make([]time.Duration, utilizationPeriods)
+	var totalBusy time.Duration
+	for _, event := range profile.Events {
+		if event.Cached {
+			continue
+		}
+
+		totalBusy += event.Duration
+		for i := range busy {
+			start, end := time.Duration(i)*period, time.Duration(i+1)*period
+			if event.Start > start {
+				start = event.Start
+			}
+
+			if event.End() < end {
+				end = event.End()
+			}
+
+			if end > start {
+				busy[i] += end - start
+			}
+		}
+	}
+
+	fmt.Println("\nParallelism:")
+	for i, busyTime := range busy {
+		running := float64(busyTime) / float64(period)
+		bar := strings.Repeat("#", int(running/float64(slots)*20+0.5))
+		fmt.Printf("  %8s - %-8s %-20s %.1f/%d slots (%.0f%%)\n",
+			formatSeconds(time.Duration(i)*period), formatSeconds(time.Duration(i+1)*period),
+			bar, running, slots, running/float64(slots)*100)
+	}
+
+	fmt.Printf("Average utilization: %.0f%%\n",
+		float64(totalBusy)/float64(total)/float64(slots)*100)
+}
+
+// AnalyzeProfile prints a summary of the profile at `path` written with the
+// profile flag: the critical path through the targets, the slowest actions and
+// how many actions were running at once over time.
+func AnalyzeProfile(args *argsModule.Args, path string) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(args.CurrentDir, path)
+	}
+
+	profile, err := common.ReadProfile(path)
+	if err != nil {
+		return err
+	}
+
+	var total time.Duration
+	cached := 0
+	run := make([]common.ProfileEvent, 0, len(profile.Events))
+	for _, event := range profile.Events {
+		if event.End() > total {
+			total = event.End()
+		}
+
+		if event.Cached {
+			cached++
+		} else {
+			run = append(run, event)
+		}
+	}
+
+	fmt.Printf("Total time: %s, %d actions run, %d cached, %d slots\n",
+		formatSeconds(total), len(run), cached, profile.Slots)
+	if len(run) == 0 {
+		return nil
+	}
+
+	// The critical path.
+	spans := targetSpans(profile)
+	criticalTargets := criticalPath(profile, spans)
+	var pathTime time.Duration
+	for _, target := range criticalTargets {
+		pathTime += spans[target].end - spans[target].start
+	}
+
+	fmt.Printf("\nCritical path (%s):\n", formatSeconds(pathTime))
+	for _, target := range criticalTargets {
+		fmt.Printf("  %8s  %s\n", formatSeconds(spans[target].end-spans[target].start), target)
+	}
+
+	// The slowest actions.
+	sort.SliceStable(run, func(i, j int) bool {
+		return run[i].Duration > run[j].Duration
+	})
+
+	if len(run) > slowestActions {
+		run = run[:slowestActions]
+	}
+
+	fmt.Println("\nSlowest actions:")
+	for _, event := range run {
+		fmt.Printf("  %8s  %-8s %s\n", formatSeconds(event.Duration), event.Kind, event.Name())
+	}
+
+	printUtilization(profile, total)
+	return nil
+}
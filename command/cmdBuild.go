@@ -3,9 +3,11 @@ package command
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jeshuam/jbuild/args"
 	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config"
 	"github.com/jeshuam/jbuild/config/interfaces"
 	"github.com/jeshuam/jbuild/progress"
 	"github.com/op/go-logging"
@@ -43,6 +45,11 @@ func buildTargets(args *args.Args, targetsToBuild map[string]interfaces.TargetSp
 		targetsBuilt   = make(map[string]bool, len(targetsToBuild))
 	)
 
+	for _, spec := range targetsToBuild {
+		common.EmitBuildEvent(common.BuildEvent{
+			Type: common.TargetConfiguredEvent, Target: spec.String(), TargetType: spec.Type()})
+	}
+
 	for len(targetsBuilt) < len(targetsToBuild) {
 		startedThisRound := 0
 		skippedThisRound := 0
@@ -61,6 +68,10 @@ func buildTargets(args *args.Args, targetsToBuild map[string]interfaces.TargetSp
 					}
 
 					log.Infof("Skipping %s...", spec)
+					common.RecordAction(args, common.Action{Target: spec.String(), Kind: common.UpToDateAction}, time.Now(), 0, true)
+					common.EmitBuildEvent(common.BuildEvent{
+						Type: common.TargetCompletedEvent, Target: spec.String(), TargetType: spec.Type(),
+						Success: common.EventBool(true), Cached: true})
 
 					continue
 				}
@@ -92,6 +103,14 @@ func buildTargets(args *args.Args, targetsToBuild map[string]interfaces.TargetSp
 			// Get results from running targets.
 			log.Infof("waiting for %d to finish processing...", startedThisRound)
 			result := <-results
+			completed := common.BuildEvent{
+				Type: common.TargetCompletedEvent, Target: result.Spec.String(),
+				TargetType: result.Spec.Type(), Success: common.EventBool(result.Err == nil)}
+			if result.Err != nil {
+				completed.Error = result.Err.Error()
+			}
+
+			common.EmitBuildEvent(completed)
 			if result.Err != nil {
 				return result.Err
 			} else {
@@ -104,29 +123,34 @@ func buildTargets(args *args.Args, targetsToBuild map[string]interfaces.TargetSp
 	return nil
 }
 
-func BuildTargets(args *args.Args, targetsToBuild map[string]interfaces.TargetSpec) error {
-	// Make a task queue, which runs commands that are passed to it.
-	taskQueue := make(chan common.CmdSpec)
-	for i := 0; i < args.Threads; i++ {
-		go func() {
-			for {
-				task := <-taskQueue
-
-				// Try to acquire the lock for the command.
-				if task.Lock != nil {
-					task.Lock.Lock()
-				}
+// writeStampFiles writes the workspace status for the targets in `targets`, if
+// any of them use it.
+func writeStampFiles(args *args.Args, targets map[string]interfaces.TargetSpec) error {
+	for _, spec := range targets {
+		if config.UsesStamp(spec) {
+			return common.WriteStampFiles(args)
+		}
+	}
 
-				common.RunCommand(args, task.Cmd, task.Result, task.Complete)
+	return nil
+}
 
-				if task.Lock != nil {
-					task.Lock.Unlock()
-				}
+func BuildTargets(args *args.Args, targetsToBuild map[string]interfaces.TargetSpec) error {
+	// Stamped targets check whether the workspace status has changed before
+	// being built, so it has to be written first.
+	if err := writeStampFiles(args, targetsToBuild); err != nil {
+		return err
+	}
 
-			}
-		}()
+	if err := common.WriteRunfilesHeader(args); err != nil {
+		return err
 	}
 
+	// Make a task queue, which runs commands that are passed to it when there
+	// are enough resources for them.
+	taskQueue := make(chan common.CmdSpec)
+	startScheduler(args, targetsToBuild, taskQueue)
+
 	// Setup the progress bar display.
 	setupProgressBars(args, targetsToBuild)
 
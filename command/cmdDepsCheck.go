@@ -0,0 +1,163 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config"
+	"github.com/jeshuam/jbuild/config/cc"
+	"github.com/jeshuam/jbuild/config/interfaces"
+	"github.com/op/go-logging"
+)
+
+// depLabel returns the label `spec` should use in its deps to refer to `dep`.
+func depLabel(spec, dep interfaces.TargetSpec) string {
+	if spec.Dir() == dep.Dir() {
+		return ":" + dep.Name()
+	}
+
+	return dep.String()
+}
+
+// depsEdit works out how the deps of `spec` should change to fix the problems
+// in `report`. The deps to remove are given as they are written in the BUILD
+// file `buildFile`.
+func depsEdit(args *argsModule.Args, spec interfaces.TargetSpec, buildFile map[string]interface{}, report *cc.DepsReport) argsModule.DepsEdit {
+	unused := make(map[string]bool)
+	for _, dep := range report.Unused {
+		unused[dep.String()] = true
+	}
+
+	edit := argsModule.DepsEdit{}
+	targetJson, _ := buildFile[spec.Name()].(map[string]interface{})
+	rawDeps, _ := targetJson["deps"].([]interface{})
+	for _, rawDep := range rawDeps {
+		rawDep, ok := rawDep.(string)
+		if !ok {
+			continue
+		}
+
+		deps, err := config.MakeTargetSpec(args, rawDep, spec.Dir(), args.WorkspaceDir)
+		if err == nil && len(deps) == 1 && unused[deps[0].String()] {
+			edit.Remove = append(edit.Remove, rawDep)
+		}
+	}
+
+	for _, dep := range report.Missing {
+		edit.Add = append(edit.Add, depLabel(spec, dep))
+	}
+
+	return edit
+}
+
+// CheckDeps reports the deps of the C++ targets in `specs` which they don't
+// use, and the libraries they use but only depend on through another dep. The
+// targets must already have been built with depfiles. If args.FixDeps is set,
+// the BUILD files are rewritten to fix the problems instead (unless they are
+// written in Starlark), and an error is only returned for the problems which
+// couldn't be fixed.
+func CheckDeps(args *argsModule.Args, specs map[string]interfaces.TargetSpec) error {
+	log := logging.MustGetLogger("jbuild")
+	if args.CCCompiler == "cl.exe" {
+		return errors.New("deps-check isn't supported with cl.exe")
+	}
+
+	specNames := make([]string, 0, len(specs))
+	for specName := range specs {
+		specNames = append(specNames, specName)
+	}
+
+	sort.Strings(specNames)
+	nProblems := 0
+	edits := make(map[string]map[string]argsModule.DepsEdit)
+	for _, specName := range specNames {
+		spec := specs[specName]
+		target, ok := spec.Target().(*cc.Target)
+		if _, isExternal := args.ExternalRepos["//"+spec.Dir()]; !ok || isExternal {
+			continue
+		}
+
+		report, err := target.CheckDeps(args)
+		if err != nil {
+			return err
+		}
+
+		for _, dep := range report.Unused {
+			fmt.Printf("%s: dep %s isn't used\n", spec, dep)
+		}
+
+		for _, dep := range report.Missing {
+			fmt.Printf("%s: %s from %s, but only depends on it through another dep\n",
+				spec, report.Reasons[dep.String()], dep)
+		}
+
+		if len(report.Unused) == 0 && len(report.Missing) == 0 {
+			continue
+		}
+
+		nProblems += len(report.Unused) + len(report.Missing)
+		buildFilePath := filepath.Join(spec.Path(), args.BuildFilename)
+		if args.FixDeps {
+			buildFile, err := argsModule.LoadConfigFile(args, buildFilePath)
+			if err != nil {
+				return err
+			}
+
+			if edits[buildFilePath] == nil {
+				edits[buildFilePath] = make(map[string]argsModule.DepsEdit)
+			}
+
+			edits[buildFilePath][spec.Name()] = depsEdit(args, spec, buildFile, report)
+		}
+	}
+
+	buildFilePaths := make([]string, 0, len(edits))
+	for buildFilePath := range edits {
+		buildFilePaths = append(buildFilePaths, buildFilePath)
+	}
+
+	sort.Strings(buildFilePaths)
+	for _, buildFilePath := range buildFilePaths {
+		content, err := ioutil.ReadFile(buildFilePath)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Could not read '%s': %s", buildFilePath, err))
+		}
+
+		if argsModule.IsStarlarkConfig(buildFilePath, content) {
+			log.Warningf("Can't fix Starlark file '%s'; change it by hand", buildFilePath)
+			continue
+		}
+
+		edited, err := argsModule.EditConfigDeps(buildFilePath, content, edits[buildFilePath])
+		if err != nil {
+			return err
+		}
+
+		stat, _ := os.Stat(buildFilePath)
+		if err := ioutil.WriteFile(buildFilePath, edited, stat.Mode()); err != nil {
+			return errors.New(fmt.Sprintf("Could not write '%s': %s", buildFilePath, err))
+		}
+
+		for _, edit := range edits[buildFilePath] {
+			nProblems -= len(edit.Remove) + len(edit.Add)
+		}
+
+		displayPath, err := filepath.Rel(args.CurrentDir, buildFilePath)
+		if err != nil {
+			displayPath = buildFilePath
+		}
+
+		fmt.Printf("Fixed %s\n", displayPath)
+	}
+
+	if nProblems > 0 {
+		return errors.New(fmt.Sprintf("Found %d dependency problem(s)", nProblems))
+	}
+
+	return nil
+}
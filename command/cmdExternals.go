@@ -0,0 +1,84 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+)
+
+// describeExternal returns a one line description of where `repo` comes from.
+func describeExternal(repo *argsModule.ExternalRepo) string {
+	description := repo.Version()
+	if repo.VendoredFrom != nil {
+		description = fmt.Sprintf("%s (vendored as %s)", repo.VendoredFrom.Version(), repo.Version())
+	}
+
+	return description
+}
+
+// printExternalTree prints the repos requested by `requester`, followed by the
+// repos they request, indented by `depth`.
+func printExternalTree(args *argsModule.Args, requester string, depth int, seen map[string]bool) {
+	repoPaths := make([]string, 0)
+	for repoPath, repo := range args.ExternalRepos {
+		for _, requestedBy := range repo.RequestedBy {
+			if requestedBy == requester {
+				repoPaths = append(repoPaths, repoPath)
+				break
+			}
+		}
+	}
+
+	sort.Strings(repoPaths)
+	indent := strings.Repeat("  ", depth)
+	for _, repoPath := range repoPaths {
+		repo := args.ExternalRepos[repoPath]
+		fmt.Printf("%s%s  %s\n", indent, repoPath, describeExternal(repo))
+
+		// Repos requested by several others are only expanded the first time.
+		if seen[repoPath] {
+			continue
+		}
+
+		seen[repoPath] = true
+		printExternalTree(args, repoPath, depth+1, seen)
+	}
+}
+
+// ListExternals loads every external repo (including the repos they depend on)
+// and prints the resolved tree, along with who requested each repo.
+func ListExternals(args *argsModule.Args) error {
+	if err := argsModule.ResolveExternalRepos(args); err != nil {
+		return err
+	}
+
+	printExternalTree(args, argsModule.WorkspaceRequester, 0, make(map[string]bool))
+
+	// Also list each repo once, so it is clear which version was chosen for repos
+	// requested in more than one place.
+	repoPaths := make([]string, 0, len(args.ExternalRepos))
+	for repoPath := range args.ExternalRepos {
+		repoPaths = append(repoPaths, repoPath)
+	}
+
+	sort.Strings(repoPaths)
+	fmt.Println()
+	for _, repoPath := range repoPaths {
+		repo := args.ExternalRepos[repoPath]
+		requesters := make([]string, 0, len(repo.RequestedBy))
+		for _, requester := range repo.RequestedBy {
+			if requester == argsModule.WorkspaceRequester {
+				requester = args.WorkspaceFilename
+			}
+
+			requesters = append(requesters, requester)
+		}
+
+		fmt.Printf("%s  %s  requested by %s\n",
+			repoPath, describeExternal(repo), strings.Join(requesters, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/op/go-logging"
+)
+
+// findBuildFiles returns the BUILD files at or below each of `paths`, which are
+// relative to the current directory. The output directory, the vendor
+// directory and hidden directories are skipped.
+func findBuildFiles(args *argsModule.Args, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	buildFiles := make([]string, 0)
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(args.CurrentDir, path)
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not find '%s'", path))
+		} else if !stat.IsDir() {
+			buildFiles = append(buildFiles, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if filePath != path && (strings.HasPrefix(info.Name(), ".") ||
+					filePath == args.OutputDir || filePath == args.VendorDir) {
+					return filepath.SkipDir
+				}
+			} else if info.Name() == args.BuildFilename {
+				buildFiles = append(buildFiles, filePath)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buildFiles, nil
+}
+
+// FormatBuildFiles rewrites the HJSON BUILD files at or below `paths` (or the
+// current directory, if there are none) in the canonical format. In check mode
+// nothing is rewritten; the files which need formatting are listed instead, and
+// an error is returned if there are any.
+func FormatBuildFiles(args *argsModule.Args, paths []string) error {
+	log := logging.MustGetLogger("jbuild")
+
+	buildFiles, err := findBuildFiles(args, paths)
+	if err != nil {
+		return err
+	}
+
+	unformatted := 0
+	for _, buildFile := range buildFiles {
+		content, err := ioutil.ReadFile(buildFile)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Could not read '%s': %s", buildFile, err))
+		}
+
+		if argsModule.IsStarlarkConfig(buildFile, content) {
+			log.Infof("Skipping Starlark file '%s'", buildFile)
+			continue
+		}
+
+		formatted, err := argsModule.FormatConfigFile(buildFile, content)
+		if err != nil {
+			return err
+		} else if bytes.Equal(content, formatted) {
+			continue
+		}
+
+		unformatted++
+		displayPath, err := filepath.Rel(args.CurrentDir, buildFile)
+		if err != nil {
+			displayPath = buildFile
+		}
+
+		if args.FormatCheck {
+			fmt.Println(displayPath)
+			continue
+		}
+
+		stat, _ := os.Stat(buildFile)
+		if err := ioutil.WriteFile(buildFile, formatted, stat.Mode()); err != nil {
+			return errors.New(fmt.Sprintf("Could not write '%s': %s", buildFile, err))
+		}
+
+		fmt.Printf("Formatted %s\n", displayPath)
+	}
+
+	if args.FormatCheck && unformatted > 0 {
+		return errors.New(fmt.Sprintf(
+			"%d BUILD file(s) need formatting; run 'jbuild fmt' to fix them", unformatted))
+	}
+
+	return nil
+}
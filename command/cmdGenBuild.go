@@ -0,0 +1,444 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config"
+	"github.com/jeshuam/jbuild/config/cc"
+	"github.com/op/go-logging"
+)
+
+var (
+	// Matches the definition of a main() function.
+	mainRegex = regexp.MustCompile(`(?m)^\s*(?:int|auto)\s+main\s*\(`)
+
+	// The extensions of the files gen-build puts in each field.
+	genSrcExtensions = []string{".cc", ".cpp", ".c", ".cxx"}
+	genHdrExtensions = []string{".h", ".hpp"}
+)
+
+// A genTarget is a target which gen-build (or import-compdb) will write to a
+// BUILD file. Files are relative to the target's directory.
+type genTarget struct {
+	dir          string
+	name         string
+	kind         string
+	srcs         []string
+	hdrs         []string
+	files        []string
+	includes     []string
+	compileFlags []string
+	deps         map[string]bool
+}
+
+// label returns the absolute label of the target.
+func (this *genTarget) label() string {
+	return "//" + this.dir + ":" + this.name
+}
+
+// hasExtension returns true iff `file` ends with one of `extensions`.
+func hasExtension(file string, extensions []string) bool {
+	for _, extension := range extensions {
+		if strings.HasSuffix(file, extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// absoluteLabel returns `label`, which is relative to the package `dir`, in
+// the form //pkg:name.
+func absoluteLabel(label, dir string) string {
+	labelParts := strings.SplitN(label, ":", 2)
+	labelPath := labelParts[0]
+	name := filepath.Base(labelPath)
+	if len(labelParts) == 2 {
+		name = labelParts[1]
+	}
+
+	pkg := strings.Trim(labelPath, "/")
+	if !strings.HasPrefix(label, "//") {
+		pkg = strings.Trim(filepath.ToSlash(filepath.Join(dir, labelPath)), "/")
+	}
+
+	if pkg == "." {
+		pkg = ""
+	}
+
+	return "//" + pkg + ":" + name
+}
+
+// relativeLabel returns the shortest label a target in the package `dir` can
+// use to refer to `label` (an absolute label).
+func relativeLabel(label, dir string) string {
+	labelParts := strings.SplitN(label, ":", 2)
+	if labelParts[0] == "//"+dir {
+		return ":" + labelParts[1]
+	} else if filepath.Base(labelParts[0]) == labelParts[1] {
+		return labelParts[0]
+	}
+
+	return label
+}
+
+// findSourceDirs returns the directories at or below each of `paths` which
+// contain C++ files, mapped to the names of those files. The output directory,
+// the vendor directory and hidden directories are skipped.
+func findSourceDirs(args *argsModule.Args, paths []string) (map[string][]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	dirs := make(map[string][]string)
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(args.CurrentDir, path)
+		}
+
+		if stat, err := os.Stat(path); err != nil || !stat.IsDir() {
+			return nil, errors.New(fmt.Sprintf("Could not find directory '%s'", path))
+		}
+
+		err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if filePath != path && (strings.HasPrefix(info.Name(), ".") ||
+					filePath == args.OutputDir || filePath == args.VendorDir) {
+					return filepath.SkipDir
+				}
+			} else if hasExtension(info.Name(), genSrcExtensions) || hasExtension(info.Name(), genHdrExtensions) {
+				dir := filepath.Dir(filePath)
+				dirs[dir] = append(dirs[dir], info.Name())
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dirs, nil
+}
+
+// loadExistingTargets loads every C++ target in the workspace which is already
+// in a HJSON BUILD file, and returns which target (as an absolute label) owns
+// each of their files and which headers each of them has. BUILD files which
+// can't be loaded are skipped with a warning.
+func loadExistingTargets(args *argsModule.Args) (map[string]string, map[string]string, error) {
+	log := logging.MustGetLogger("jbuild")
+	buildFiles, err := findBuildFiles(args, []string{args.WorkspaceDir})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owners := make(map[string]string)
+	headers := make(map[string]string)
+	for _, buildFile := range buildFiles {
+		dir, _ := filepath.Rel(args.WorkspaceDir, filepath.Dir(buildFile))
+		pkg := strings.Trim(filepath.ToSlash(dir), ".")
+		specs, err := config.MakeTargetSpec(args, "//"+pkg+":all", "", args.WorkspaceDir)
+		if err != nil {
+			log.Warningf("Could not load the targets in '%s': %s", buildFile, err)
+			continue
+		}
+
+		for _, spec := range specs {
+			target, ok := spec.Target().(*cc.Target)
+			if !ok {
+				continue
+			}
+
+			for _, src := range target.SrcFiles() {
+				owners[filepath.Clean(src.FsPath())] = spec.String()
+			}
+
+			for _, hdr := range target.HdrFiles() {
+				owners[filepath.Clean(hdr.FsPath())] = spec.String()
+				headers[filepath.Clean(hdr.FsPath())] = spec.String()
+			}
+		}
+	}
+
+	return owners, headers, nil
+}
+
+// planTargets works out the targets gen-build should write for the C++ files
+// `files` in `dir` (an absolute path): a c++/test for each *_test.cc file, a
+// c++/binary for each other source defining main() and a c++/library named
+// after the directory for everything else. Files which already belong to a
+// target with a different name are left alone.
+func planTargets(args *argsModule.Args, dir string, files []string, owners map[string]string) ([]*genTarget, error) {
+	relDir, _ := filepath.Rel(args.WorkspaceDir, dir)
+	pkg := strings.Trim(filepath.ToSlash(relDir), ".")
+	sort.Strings(files)
+
+	targets := make(map[string]*genTarget)
+	fileTargets := make(map[string]*genTarget)
+	for _, file := range files {
+		stem := strings.TrimSuffix(file, filepath.Ext(file))
+		if !hasExtension(file, genSrcExtensions) {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not read '%s': %s", file, err))
+		}
+
+		if strings.HasSuffix(stem, "_test") {
+			targets[stem] = &genTarget{dir: pkg, name: stem, kind: "c++/test"}
+		} else if mainRegex.Match(content) {
+			targets[stem] = &genTarget{dir: pkg, name: stem, kind: "c++/binary"}
+		} else {
+			continue
+		}
+
+		fileTargets[file] = targets[stem]
+	}
+
+	libName := filepath.Base(dir)
+	if _, ok := targets[libName]; ok {
+		libName += "_lib"
+	}
+
+	lib := &genTarget{dir: pkg, name: libName, kind: "c++/library"}
+	for _, file := range files {
+		target, ok := fileTargets[file]
+		if !ok {
+			target = lib
+		}
+
+		if owner, ok := owners[filepath.Join(dir, file)]; ok && owner != target.label() {
+			continue
+		}
+
+		if hasExtension(file, genHdrExtensions) {
+			target.hdrs = append(target.hdrs, file)
+		} else {
+			target.srcs = append(target.srcs, file)
+		}
+
+		target.files = append(target.files, file)
+	}
+
+	planned := make([]*genTarget, 0)
+	for _, target := range append([]*genTarget{lib}, sortedGenTargets(targets)...) {
+		if len(target.files) > 0 {
+			target.deps = make(map[string]bool)
+			planned = append(planned, target)
+		}
+	}
+
+	return planned, nil
+}
+
+// sortedGenTargets returns the targets in `targets` sorted by name.
+func sortedGenTargets(targets map[string]*genTarget) []*genTarget {
+	sorted := make([]*genTarget, 0, len(targets))
+	for _, target := range targets {
+		sorted = append(sorted, target)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	return sorted
+}
+
+// inferDeps adds a dep to `target` (whose files are in `dir`) for each header
+// it includes which belongs to another target. Includes are relative to the
+// including file, one of the target's includes or the root of the workspace.
+func inferDeps(args *argsModule.Args, target *genTarget, dir string, headers map[string]string) error {
+	includeDirs := make([]string, 0, len(target.includes)+1)
+	for _, include := range target.includes {
+		if strings.HasPrefix(include, "//") {
+			includeDirs = append(includeDirs, filepath.Join(args.WorkspaceDir, filepath.FromSlash(include[2:])))
+		} else {
+			includeDirs = append(includeDirs, filepath.Join(dir, filepath.FromSlash(include)))
+		}
+	}
+
+	includeDirs = append(includeDirs, args.WorkspaceDir)
+	for _, file := range target.files {
+		filePath := filepath.Join(dir, filepath.FromSlash(file))
+		includes, err := cc.ReadIncludes(filePath)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Could not read '%s': %s", file, err))
+		}
+
+		for _, include := range includes {
+			include = filepath.FromSlash(include)
+			for _, includeDir := range append([]string{filepath.Dir(filePath)}, includeDirs...) {
+				candidate := filepath.Join(includeDir, include)
+				if owner, ok := headers[candidate]; ok {
+					if owner != target.label() {
+						target.deps[owner] = true
+					}
+
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeGenTargets writes `targets` to the BUILD file in `dir`, creating it if
+// it doesn't exist. Targets with the same names have their fields replaced,
+// except for deps which are added to, and everything else is left alone. The
+// path of the BUILD file is printed if it changed.
+func writeGenTargets(args *argsModule.Args, dir string, targets []*genTarget) error {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	buildFilePath := filepath.Join(dir, args.BuildFilename)
+	content, err := ioutil.ReadFile(buildFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.New(fmt.Sprintf("Could not read '%s': %s", buildFilePath, err))
+	}
+
+	var buildFile map[string]interface{}
+	if len(content) > 0 {
+		if buildFile, err = argsModule.LoadConfigFile(args, buildFilePath); err != nil {
+			return err
+		}
+	}
+
+	updates := make(map[string]map[string]interface{})
+	for _, target := range targets {
+		// Keep the deps which are already there.
+		deps := make([]string, 0)
+		targetJson, _ := buildFile[target.name].(map[string]interface{})
+		rawDeps, _ := targetJson["deps"].([]interface{})
+		for _, rawDep := range rawDeps {
+			if rawDep, ok := rawDep.(string); ok {
+				deps = append(deps, rawDep)
+				delete(target.deps, absoluteLabel(rawDep, target.dir))
+			}
+		}
+
+		for dep := range target.deps {
+			deps = append(deps, relativeLabel(dep, target.dir))
+		}
+
+		fields := map[string]interface{}{"type": target.kind}
+		if len(target.srcs) > 0 {
+			fields["srcs"] = target.srcs
+		}
+
+		if len(target.hdrs) > 0 {
+			fields["hdrs"] = target.hdrs
+		}
+
+		if len(deps) > 0 {
+			sort.Strings(deps)
+			fields["deps"] = deps
+		}
+
+		if len(target.includes) > 0 {
+			fields["includes"] = target.includes
+		}
+
+		if len(target.compileFlags) > 0 {
+			fields["compile_flags"] = target.compileFlags
+		}
+
+		updates[target.name] = fields
+	}
+
+	updated, err := argsModule.UpdateConfigTargets(buildFilePath, content, updates)
+	if err != nil {
+		return err
+	} else if bytes.Equal(content, updated) {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(buildFilePath, updated, 0644); err != nil {
+		return errors.New(fmt.Sprintf("Could not write '%s': %s", buildFilePath, err))
+	}
+
+	displayPath, err := filepath.Rel(args.CurrentDir, buildFilePath)
+	if err != nil {
+		displayPath = buildFilePath
+	}
+
+	fmt.Printf("Wrote %s\n", displayPath)
+	return nil
+}
+
+// GenerateBuildFiles writes a BUILD file in each directory at or below `paths`
+// (or the current directory, if there are none) containing C++ files, with
+// targets for those files. The deps of each target are worked out from the
+// headers its files include. Existing BUILD files are updated: targets with
+// the same names have their files replaced and any missing deps added, and
+// everything else is left alone. Starlark BUILD files are skipped.
+func GenerateBuildFiles(args *argsModule.Args, paths []string) error {
+	log := logging.MustGetLogger("jbuild")
+	dirs, err := findSourceDirs(args, paths)
+	if err != nil {
+		return err
+	}
+
+	owners, headers, err := loadExistingTargets(args)
+	if err != nil {
+		return err
+	}
+
+	dirNames := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		dirNames = append(dirNames, dir)
+	}
+
+	// Plan every target first, so targets can depend on each other.
+	sort.Strings(dirNames)
+	planned := make(map[string][]*genTarget)
+	for _, dir := range dirNames {
+		buildFilePath := filepath.Join(dir, args.BuildFilename)
+		if content, err := ioutil.ReadFile(buildFilePath); err == nil && argsModule.IsStarlarkConfig(buildFilePath, content) {
+			log.Warningf("Skipping Starlark file '%s'; change it by hand", buildFilePath)
+			continue
+		}
+
+		targets, err := planTargets(args, dir, dirs[dir], owners)
+		if err != nil {
+			return err
+		}
+
+		for _, target := range targets {
+			for _, hdr := range target.hdrs {
+				headers[filepath.Join(dir, hdr)] = target.label()
+			}
+		}
+
+		planned[dir] = targets
+	}
+
+	for _, dir := range dirNames {
+		for _, target := range planned[dir] {
+			if err := inferDeps(args, target, dir, headers); err != nil {
+				return err
+			}
+		}
+
+		if err := writeGenTargets(args, dir, planned[dir]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
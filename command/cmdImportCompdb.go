@@ -0,0 +1,526 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+	"github.com/op/go-logging"
+)
+
+var (
+	// Matches the directory CMake puts the objects of a target in, capturing the
+	// name of the target.
+	cmakeObjectDirRegex = regexp.MustCompile(`(?:^|/)CMakeFiles/([^/]+)\.dir/`)
+
+	// The extensions of libraries which can be linked.
+	libraryExtensions = []string{".a", ".so", ".dylib", ".lib"}
+
+	// Flags which aren't imported, but are followed by a value.
+	compdbFlagsWithValues = map[string]bool{
+		"-MF": true, "-MT": true, "-MQ": true, "-include": true, "-x": true, "-arch": true, "-target": true,
+	}
+)
+
+// A compdbEntry is a single command in a compilation database.
+type compdbEntry struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Command   string   `json:"command"`
+	Arguments []string `json:"arguments"`
+	Output    string   `json:"output"`
+}
+
+// A compdbCompile is a source file which is compiled by a compilation
+// database. Paths are absolute.
+type compdbCompile struct {
+	src      string
+	object   string
+	includes []string
+	flags    []string
+}
+
+// A compdbLink is a library or binary which is linked by a compilation
+// database. Paths are absolute; libraries linked with -l are kept as they are.
+type compdbLink struct {
+	output    string
+	isLibrary bool
+	objects   []string
+	libs      []string
+}
+
+// name returns the name of the target the link makes.
+func (this *compdbLink) name() string {
+	name := filepath.Base(this.output)
+	for _, extension := range append(libraryExtensions, ".exe") {
+		name = strings.TrimSuffix(name, extension)
+	}
+
+	if this.isLibrary {
+		name = strings.TrimPrefix(name, "lib")
+	}
+
+	return name
+}
+
+// splitCommand splits the shell command `command` into its arguments.
+func splitCommand(command string) []string {
+	args := make([]string, 0)
+	current, inArg, quote := "", false, byte(0)
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == '\\' && i+1 < len(command) && quote != '\'':
+			current, inArg = current+string(command[i+1]), true
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			current += string(c)
+		case c == '"' || c == '\'':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, current)
+			}
+
+			current, inArg = "", false
+		default:
+			current, inArg = current+string(c), true
+		}
+	}
+
+	if inArg {
+		args = append(args, current)
+	}
+
+	return args
+}
+
+// parseCompdbEntry works out what the command in `entry` compiles and links.
+// The same command can do both.
+func parseCompdbEntry(entry compdbEntry) ([]*compdbCompile, *compdbLink) {
+	argv := entry.Arguments
+	if len(argv) == 0 {
+		argv = splitCommand(entry.Command)
+	}
+
+	if len(argv) == 0 {
+		return nil, nil
+	}
+
+	absPath := func(path string) string {
+		if filepath.IsAbs(path) {
+			return filepath.Clean(path)
+		}
+
+		return filepath.Join(entry.Directory, path)
+	}
+
+	// Static libraries are made by an archiver rather than the compiler.
+	if strings.HasSuffix(filepath.Base(argv[0]), "ar") {
+		link := &compdbLink{isLibrary: true}
+		for _, arg := range argv[1:] {
+			if link.output == "" && strings.HasSuffix(arg, ".a") {
+				link.output = absPath(arg)
+			} else if hasExtension(arg, []string{".o", ".obj"}) {
+				link.objects = append(link.objects, absPath(arg))
+			}
+		}
+
+		if link.output == "" {
+			return nil, nil
+		}
+
+		return nil, link
+	}
+
+	output, includes, flags, srcs := "", make([]string, 0), make([]string, 0), make([]string, 0)
+	isCompileOnly, isShared := false, false
+	link := &compdbLink{}
+	for i := 1; i < len(argv); i++ {
+		arg := argv[i]
+		value := func(prefix string) string {
+			if arg == prefix && i+1 < len(argv) {
+				i++
+				return argv[i]
+			}
+
+			return arg[len(prefix):]
+		}
+
+		switch {
+		case arg == "-c":
+			isCompileOnly = true
+		case arg == "-shared":
+			isShared = true
+		case strings.HasPrefix(arg, "-o"):
+			output = absPath(value("-o"))
+		case strings.HasPrefix(arg, "-I"):
+			includes = append(includes, absPath(value("-I")))
+		case arg == "-iquote" || arg == "-isystem":
+			includes = append(includes, absPath(value(arg)))
+		case compdbFlagsWithValues[arg]:
+			i++
+		case strings.HasPrefix(arg, "-D"):
+			flags = append(flags, "-D"+value("-D"))
+		case strings.HasPrefix(arg, "-U"):
+			flags = append(flags, "-U"+value("-U"))
+		case strings.HasPrefix(arg, "-std="):
+			flags = append(flags, arg)
+		case strings.HasPrefix(arg, "-l"):
+			link.libs = append(link.libs, "-l"+value("-l"))
+		case strings.HasPrefix(arg, "-"):
+			continue
+		case hasExtension(arg, genSrcExtensions):
+			srcs = append(srcs, absPath(arg))
+		case hasExtension(arg, []string{".o", ".obj"}):
+			link.objects = append(link.objects, absPath(arg))
+		case hasExtension(arg, libraryExtensions):
+			link.libs = append(link.libs, absPath(arg))
+		}
+	}
+
+	if hasExtension(entry.File, genSrcExtensions) && len(srcs) == 0 {
+		srcs = append(srcs, absPath(entry.File))
+	}
+
+	if entry.Output != "" {
+		output = absPath(entry.Output)
+	}
+
+	compiles := make([]*compdbCompile, 0, len(srcs))
+	for _, src := range srcs {
+		compile := &compdbCompile{src: src, includes: includes, flags: flags}
+		if isCompileOnly {
+			compile.object = output
+		} else {
+			// The object is never written, so just make up a name for it.
+			compile.object = src + ".o"
+			link.objects = append(link.objects, compile.object)
+		}
+
+		compiles = append(compiles, compile)
+	}
+
+	if isCompileOnly || output == "" {
+		return compiles, nil
+	}
+
+	link.output = output
+	link.isLibrary = isShared || hasExtension(output, libraryExtensions)
+	return compiles, link
+}
+
+// appendUnique appends each of `values` to `list` which isn't already in it.
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, item := range list {
+			found = found || item == value
+		}
+
+		if !found {
+			list = append(list, value)
+		}
+	}
+
+	return list
+}
+
+// commonDir returns the deepest directory containing all of `files`.
+func commonDir(files []string) string {
+	dir := filepath.Dir(files[0])
+	for _, file := range files[1:] {
+		for {
+			rel, err := filepath.Rel(dir, file)
+			if (err == nil && !strings.HasPrefix(rel, "..")) || filepath.Dir(dir) == dir {
+				break
+			}
+
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	return dir
+}
+
+// makeCompdbTarget returns a target called `name` in the directory containing
+// the sources of `compiles`, along with that directory. Sources have their
+// headers (i.e. a .h or .hpp file next to them with the same name) added.
+func makeCompdbTarget(args *argsModule.Args, name, kind string, compiles []*compdbCompile) (*genTarget, string) {
+	srcs := make([]string, 0, len(compiles))
+	for _, compile := range compiles {
+		srcs = append(srcs, compile.src)
+	}
+
+	dir := commonDir(srcs)
+	relDir, _ := filepath.Rel(args.WorkspaceDir, dir)
+	pkg := strings.Trim(filepath.ToSlash(relDir), ".")
+	target := &genTarget{dir: pkg, name: name, kind: kind, deps: make(map[string]bool)}
+	for _, compile := range compiles {
+		src, _ := filepath.Rel(dir, compile.src)
+		target.srcs = append(target.srcs, filepath.ToSlash(src))
+		for _, extension := range genHdrExtensions {
+			hdr := strings.TrimSuffix(src, filepath.Ext(src)) + extension
+			if common.FileExists(filepath.Join(dir, hdr)) {
+				target.hdrs = append(target.hdrs, filepath.ToSlash(hdr))
+			}
+		}
+
+		for _, include := range compile.includes {
+			rel, err := filepath.Rel(args.WorkspaceDir, include)
+			if include == args.WorkspaceDir {
+				continue
+			} else if err != nil || strings.HasPrefix(rel, "..") || !common.IsDir(include) {
+				// Directories outside the workspace can only be passed as flags.
+				target.compileFlags = appendUnique(target.compileFlags, "-I"+include)
+			} else if relInclude, err := filepath.Rel(dir, include); err == nil && !strings.HasPrefix(relInclude, "..") {
+				target.includes = appendUnique(target.includes, filepath.ToSlash(relInclude))
+			} else {
+				target.includes = appendUnique(target.includes, "//"+filepath.ToSlash(rel))
+			}
+		}
+
+		target.compileFlags = appendUnique(target.compileFlags, compile.flags...)
+	}
+
+	sort.Strings(target.srcs)
+	sort.Strings(target.hdrs)
+	target.files = append(append([]string{}, target.srcs...), target.hdrs...)
+	return target, dir
+}
+
+// hasMain returns true iff one of the sources of `compiles` defines main().
+func hasMain(compiles []*compdbCompile) bool {
+	for _, compile := range compiles {
+		content, err := ioutil.ReadFile(compile.src)
+		if err == nil && mainRegex.Match(content) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// executableKind returns the type of an executable target called `name`.
+func executableKind(name string) string {
+	if strings.HasSuffix(name, "_test") {
+		return "c++/test"
+	}
+
+	return "c++/binary"
+}
+
+// ImportCompilationDatabase writes BUILD files with targets for the files
+// compiled by the compilation database (i.e. compile_commands.json) at
+// `compdbPath`. Sources are grouped into the libraries and binaries they are
+// linked into if the database has link commands, then by the CMake target
+// their objects belong to, and then by directory (like gen-build). -I flags
+// become includes, and -D, -U and -std flags become compile_flags. Deps come
+// from the libraries linked and the headers included.
+func ImportCompilationDatabase(args *argsModule.Args, compdbPath string) error {
+	log := logging.MustGetLogger("jbuild")
+	if !filepath.IsAbs(compdbPath) {
+		compdbPath = filepath.Join(args.CurrentDir, compdbPath)
+	}
+
+	content, err := ioutil.ReadFile(compdbPath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not read '%s': %s", compdbPath, err))
+	}
+
+	entries := make([]compdbEntry, 0)
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return errors.New(fmt.Sprintf("Could not parse '%s': %s", compdbPath, err))
+	}
+
+	compiles := make([]*compdbCompile, 0)
+	links := make([]*compdbLink, 0)
+	for _, entry := range entries {
+		if entry.Directory == "" {
+			entry.Directory = filepath.Dir(compdbPath)
+		}
+
+		entryCompiles, link := parseCompdbEntry(entry)
+		for _, compile := range entryCompiles {
+			if rel, err := filepath.Rel(args.WorkspaceDir, compile.src); err != nil || strings.HasPrefix(rel, "..") {
+				log.Warningf("Skipping '%s', which isn't in the workspace", compile.src)
+				continue
+			}
+
+			compiles = append(compiles, compile)
+		}
+
+		if link != nil {
+			links = append(links, link)
+		}
+	}
+
+	// Group the sources by the target they are linked into, if that's known.
+	objectCompiles := make(map[string]*compdbCompile)
+	for _, compile := range compiles {
+		objectCompiles[compile.object] = compile
+	}
+
+	groups := make(map[string][]*compdbCompile)
+	grouped := make(map[*compdbCompile]bool)
+	for _, link := range links {
+		for _, object := range link.objects {
+			if compile, ok := objectCompiles[object]; ok && !grouped[compile] {
+				groups[link.output] = append(groups[link.output], compile)
+				grouped[compile] = true
+			}
+		}
+	}
+
+	targets := make(map[string][]*genTarget)
+	linkTargets := make(map[string]*genTarget)
+	libraryTargets := make(map[string]*genTarget)
+	addTarget := func(target *genTarget, dir string) {
+		if target.kind == "c++/library" {
+			libraryTargets[target.name] = target
+		}
+
+		for _, other := range targets[dir] {
+			if other.name == target.name && target.kind == "c++/library" {
+				target.name += "_lib"
+			} else if other.name == target.name {
+				target.name += "_bin"
+			}
+		}
+
+		targets[dir] = append(targets[dir], target)
+	}
+
+	for _, link := range links {
+		if len(groups[link.output]) == 0 {
+			continue
+		}
+
+		kind := executableKind(link.name())
+		if link.isLibrary {
+			kind = "c++/library"
+		}
+
+		target, dir := makeCompdbTarget(args, link.name(), kind, groups[link.output])
+		addTarget(target, dir)
+		linkTargets[link.output] = target
+	}
+
+	// Then by the CMake target they belong to, and then by directory.
+	cmakeGroups := make(map[string][]*compdbCompile)
+	dirGroups := make(map[string][]*compdbCompile)
+	for _, compile := range compiles {
+		if grouped[compile] {
+			continue
+		} else if match := cmakeObjectDirRegex.FindStringSubmatch(filepath.ToSlash(compile.object)); match != nil {
+			cmakeGroups[match[1]] = append(cmakeGroups[match[1]], compile)
+		} else {
+			dir := filepath.Dir(compile.src)
+			dirGroups[dir] = append(dirGroups[dir], compile)
+		}
+	}
+
+	cmakeNames := make([]string, 0, len(cmakeGroups))
+	for name := range cmakeGroups {
+		cmakeNames = append(cmakeNames, name)
+	}
+
+	sort.Strings(cmakeNames)
+	for _, name := range cmakeNames {
+		kind := "c++/library"
+		if hasMain(cmakeGroups[name]) {
+			kind = executableKind(name)
+		}
+
+		addTarget(makeCompdbTarget(args, name, kind, cmakeGroups[name]))
+	}
+
+	dirs := make([]string, 0, len(dirGroups))
+	for dir := range dirGroups {
+		dirs = append(dirs, dir)
+	}
+
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		libCompiles := make([]*compdbCompile, 0)
+		for _, compile := range dirGroups[dir] {
+			if name := strings.TrimSuffix(filepath.Base(compile.src), filepath.Ext(compile.src)); hasMain([]*compdbCompile{compile}) {
+				addTarget(makeCompdbTarget(args, name, executableKind(name), []*compdbCompile{compile}))
+			} else {
+				libCompiles = append(libCompiles, compile)
+			}
+		}
+
+		if len(libCompiles) > 0 {
+			addTarget(makeCompdbTarget(args, filepath.Base(dir), "c++/library", libCompiles))
+		}
+	}
+
+	// Work out the deps from the libraries linked...
+	for _, link := range links {
+		target, ok := linkTargets[link.output]
+		if !ok {
+			continue
+		}
+
+		for _, lib := range link.libs {
+			dep, ok := linkTargets[lib]
+			if strings.HasPrefix(lib, "-l") {
+				dep, ok = libraryTargets[lib[2:]]
+			}
+
+			if ok && dep != target {
+				target.deps[dep.label()] = true
+			}
+		}
+	}
+
+	// ... and the headers included.
+	_, headers, err := loadExistingTargets(args)
+	if err != nil {
+		return err
+	}
+
+	for dir, dirTargets := range targets {
+		for _, target := range dirTargets {
+			for _, hdr := range target.hdrs {
+				headers[filepath.Join(dir, filepath.FromSlash(hdr))] = target.label()
+			}
+		}
+	}
+
+	dirs = make([]string, 0, len(targets))
+	for dir := range targets {
+		dirs = append(dirs, dir)
+	}
+
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		buildFilePath := filepath.Join(dir, args.BuildFilename)
+		if content, err := ioutil.ReadFile(buildFilePath); err == nil && argsModule.IsStarlarkConfig(buildFilePath, content) {
+			log.Warningf("Skipping Starlark file '%s'; change it by hand", buildFilePath)
+			continue
+		}
+
+		for _, target := range targets[dir] {
+			if err := inferDeps(args, target, dir, headers); err != nil {
+				return err
+			}
+		}
+
+		if err := writeGenTargets(args, dir, targets[dir]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config"
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+// LintTargets loads the targets given in `targetArgs` (or every target in the
+// workspace, if there are none) and prints anything in their BUILD files which
+// is probably a mistake. An error is returned if anything was found.
+func LintTargets(args *argsModule.Args, targetArgs []string) error {
+	if len(targetArgs) == 0 {
+		targetArgs = []string{"//..."}
+	}
+
+	relStart, _ := filepath.Rel(args.WorkspaceDir, args.CurrentDir)
+	specs := make([]interfaces.TargetSpec, 0)
+	for _, target := range targetArgs {
+		targetSpecs, err := config.MakeTargetSpec(args, target, relStart, args.WorkspaceDir)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to load target '%s': %s", target, err))
+		}
+
+		specs = append(specs, targetSpecs...)
+	}
+
+	problems := config.Lint(args, specs)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("Found %d lint problem(s)", len(problems)))
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -88,12 +89,15 @@ func loadTestResult(args *args.Args, target interfaces.TargetSpec) *testResult {
 	return result
 }
 
-func runTest(args *args.Args, sem chan bool, target interfaces.TargetSpec, results chan testResult) {
+func runTest(args *args.Args, slots chan int, target interfaces.TargetSpec, results chan testResult) {
+	action := common.Action{Target: target.String(), Kind: common.TestAction}
+
 	// If we aren't being forced to run tests, then try to load a cached test
 	// result file.
 	if !args.ForceRunTests && args.TestRuns == 1 {
 		result := loadTestResult(args, target)
 		if result != nil {
+			common.RecordAction(args, action, time.Now(), 0, true)
 			results <- *result
 			return
 		}
@@ -102,21 +106,27 @@ func runTest(args *args.Args, sem chan bool, target interfaces.TargetSpec, resul
 	// Either we are being forced to run tests, or this test has not been cached
 	// recently. Run the test!
 	cmd := exec.Command(filepath.Join(target.OutputPath(), target.Name()))
-	sem <- true
-	common.RunCommand(args, cmd, nil, func(output string, success bool, d time.Duration) {
+	cmd.Env = append(os.Environ(), common.RunfilesEnv(common.RunfilesDir(target.OutputPath(), target.Name()))...)
+	action.Slot = <-slots
+	common.RunCommand(args, action, cmd, nil, func(output string, success bool, d time.Duration) {
 		result := testResult{filepath.Join(target.OutputPath(), target.Name()), target.String(), success, output, d, false}
 		result.save()
 		results <- result
-		<-sem
+		slots <- action.Slot
 	})
 }
 
 func runTests(args *args.Args, targetsToTest map[string]interfaces.TargetSpec) chan testResult {
 	rawResults := make(chan testResult)
-	concurrentTestSemaphore := make(chan bool, args.TestThreads)
+	// Each test takes a free slot while it runs.
+	testSlots := make(chan int, args.TestThreads)
+	for slot := 1; slot <= args.TestThreads; slot++ {
+		testSlots <- slot
+	}
+
 	for target := range targetsToTest {
 		for i := 0; i < int(args.TestRuns); i++ {
-			go runTest(args, concurrentTestSemaphore, targetsToTest[target], rawResults)
+			go runTest(args, testSlots, targetsToTest[target], rawResults)
 		}
 	}
 
@@ -194,7 +204,9 @@ func displayResultsForTarget(args *args.Args, target string, results []testResul
 	}
 }
 
-func RunTests(args *args.Args, targetsToTest map[string]interfaces.TargetSpec) {
+// RunTests runs the tests in `targetsToTest` and displays their results. If any
+// of them fail, an error listing them is returned.
+func RunTests(args *args.Args, targetsToTest map[string]interfaces.TargetSpec) error {
 	// Run the tests once, for each command, and collect the results.
 	rawResults := runTests(args, targetsToTest)
 
@@ -210,8 +222,27 @@ func RunTests(args *args.Args, targetsToTest map[string]interfaces.TargetSpec) {
 
 	sort.Strings(resultKeySorted)
 
+	failed := make([]string, 0)
 	for _, target := range resultKeySorted {
 		targetResults := results[target]
+		for i, result := range targetResults {
+			common.EmitBuildEvent(common.BuildEvent{
+				Type: common.TestResultEvent, Target: target, Success: common.EventBool(result.Passed),
+				Cached: result.Cached, DurationMs: int64(result.Duration / time.Millisecond),
+				Output: result.Output, Run: i + 1})
+
+			if !result.Passed && (len(failed) == 0 || failed[len(failed)-1] != target) {
+				failed = append(failed, target)
+			}
+		}
+
 		displayResultsForTarget(args, target, targetResults)
 	}
+
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf(
+			"%d of %d tests failed: %s", len(failed), len(results), strings.Join(failed, ", ")))
+	}
+
+	return nil
 }
@@ -0,0 +1,163 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config/util"
+	"github.com/op/go-logging"
+)
+
+// copyTree copies the directory `src` to `dst`, skipping any version control
+// or jbuild bookkeeping files.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, _ := filepath.Rel(src, path)
+		dstPath := filepath.Join(dst, relPath)
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+
+			return os.MkdirAll(dstPath, 0755)
+		}
+
+		if info.Name() == ".git" || info.Name() == argsModule.ArchiveMarkerFilename ||
+			info.Name() == argsModule.PatchDigestFilename {
+			return nil
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(target, dstPath)
+		}
+
+		if err := util.CopyFile(path, dstPath); err != nil {
+			return err
+		}
+
+		return os.Chmod(dstPath, info.Mode())
+	})
+}
+
+// vendorRepo copies a single external repo into the vendor directory, along
+// with the BUILD file used to build it.
+func vendorRepo(args *argsModule.Args, repo *argsModule.ExternalRepo) error {
+	log := logging.MustGetLogger("jbuild")
+
+	// If the repo is currently resolved to a vendored copy, then there will be a
+	// link to it in the external repo directory. Remove it so the original repo is
+	// fetched instead.
+	linkPath := filepath.Join(args.ExternalRepoDir, strings.Trim(repo.Path, "/"))
+	if stat, err := os.Lstat(linkPath); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(linkPath); err != nil {
+			return err
+		}
+	}
+
+	if err := argsModule.LoadExternalRepo(args, repo); err != nil {
+		return err
+	}
+
+	srcDir := repo.FsDir
+	if repo.Type == argsModule.LocalRepo {
+		srcDir = repo.LocalDir(args)
+	}
+
+	dstDir := argsModule.VendoredRepoDir(args, repo)
+	if filepath.Clean(srcDir) == dstDir {
+		log.Infof("%s is already vendored", repo.Path)
+		return nil
+	}
+
+	// Copy into a temporary directory first, so a failure never leaves a broken
+	// vendored repo behind.
+	log.Infof("Vendoring %s into %s", repo.Path, dstDir)
+	tmpDir := dstDir + ".vendor-tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+	if err := copyTree(srcDir, tmpDir); err != nil {
+		return err
+	}
+
+	// Overlay the BUILD file, if there was one.
+	buildFilePath := filepath.Join(tmpDir, args.BuildFilename)
+	if repo.Build != nil {
+		build, err := json.MarshalIndent(repo.Build, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(buildFilePath, append(build, '\n'), 0644); err != nil {
+			return err
+		}
+	} else if repo.BuildFile != "" {
+		if err := util.CopyFile(repo.BuildFilePath(args), buildFilePath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(dstDir); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpDir, dstDir)
+}
+
+// VendorExternals copies every external repo into the vendor directory and
+// writes a manifest, so that future builds use the vendored copies and never
+// need to access the network.
+func VendorExternals(args *argsModule.Args) error {
+	// Make sure the repos the external repos depend on are known, so they are
+	// vendored too.
+	if err := argsModule.ResolveExternalRepos(args); err != nil {
+		return err
+	}
+
+	repoPaths := make([]string, 0, len(args.ExternalRepos))
+	for repoPath := range args.ExternalRepos {
+		repoPaths = append(repoPaths, repoPath)
+	}
+
+	sort.Strings(repoPaths)
+
+	repos := make([]*argsModule.ExternalRepo, 0, len(repoPaths))
+	for _, repoPath := range repoPaths {
+		repo := args.ExternalRepos[repoPath]
+		if repo.VendoredFrom != nil {
+			repo = repo.VendoredFrom
+		}
+
+		if err := vendorRepo(args, repo); err != nil {
+			return errors.New(fmt.Sprintf(
+				"Could not vendor external repo '%s': %s", repoPath, err))
+		}
+
+		repos = append(repos, repo)
+	}
+
+	if err := argsModule.WriteVendorManifest(args, repos); err != nil {
+		return err
+	}
+
+	fmt.Printf("Vendored %d external repos into %s\n", len(repos), args.VendorDir)
+	return nil
+}
@@ -0,0 +1,128 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config/util"
+)
+
+// The number of differing files listed when a build isn't reproducible.
+const maxDifferencesShown = 20
+
+// listOutputs returns the files in `dir`, relative to it. Depfiles are left
+// out, as they list where the files used were by design.
+func listOutputs(dir string) (map[string]bool, error) {
+	outputs := make(map[string]bool)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(path, ".d") {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		outputs[rel] = true
+		return err
+	})
+
+	return outputs, err
+}
+
+// diffOutputs returns the files in `first` or `second` which either aren't in
+// both or have different contents, and the number of files compared.
+func diffOutputs(first, second string) ([]string, int, error) {
+	firstOutputs, err := listOutputs(first)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	secondOutputs, err := listOutputs(second)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for output := range secondOutputs {
+		firstOutputs[output] = true
+	}
+
+	differences := make([]string, 0)
+	for output := range firstOutputs {
+		firstContent, firstErr := ioutil.ReadFile(filepath.Join(first, output))
+		secondContent, secondErr := ioutil.ReadFile(filepath.Join(second, output))
+		if firstErr != nil || secondErr != nil || !bytes.Equal(firstContent, secondContent) {
+			differences = append(differences, output)
+		}
+	}
+
+	sort.Strings(differences)
+	return differences, len(firstOutputs), nil
+}
+
+// VerifyReproducible builds the targets with `build` twice, with the
+// reproducible flag set and a new output directory each time, and checks that
+// both builds gave exactly the same outputs. `args` is changed for each build
+// (and restored afterwards), so `build` should use it. The output directories
+// are kept if they differ, so that they can be compared.
+func VerifyReproducible(args *argsModule.Args, build func() error) error {
+	root, err := ioutil.TempDir("", "jbuild-reproducible")
+	if err != nil {
+		return err
+	}
+
+	// The targets loaded refer to the temporary output directories, so mustn't
+	// be used again.
+	originalArgs := *args
+	defer func() {
+		*args = originalArgs
+		util.ClearCaches()
+	}()
+
+	genDir, err := filepath.Rel(args.OutputDir, args.GenOutputDir)
+	if err != nil || strings.HasPrefix(genDir, "..") {
+		genDir = "gen"
+	}
+
+	outputDirs := []string{filepath.Join(root, "1"), filepath.Join(root, "2")}
+	for i, outputDir := range outputDirs {
+		fmt.Printf("Building into %s (%d/%d)...\n", outputDir, i+1, len(outputDirs))
+		args.Reproducible = true
+		args.OutputDir = outputDir
+		args.GenOutputDir = filepath.Join(outputDir, genDir)
+
+		util.ClearCaches()
+		if err := build(); err != nil {
+			os.RemoveAll(root)
+			return err
+		}
+	}
+
+	differences, compared, err := diffOutputs(outputDirs[0], outputDirs[1])
+	if err != nil {
+		return err
+	}
+
+	if len(differences) == 0 {
+		os.RemoveAll(root)
+		fmt.Printf("All %d outputs were identical.\n", compared)
+		return nil
+	}
+
+	shown := differences
+	if len(shown) > maxDifferencesShown {
+		shown = shown[:maxDifferencesShown]
+	}
+
+	for _, output := range shown {
+		fmt.Printf("  %s\n", output)
+	}
+
+	return errors.New(fmt.Sprintf(
+		"%d of %d outputs differed between builds; they were left in %s and %s",
+		len(differences), compared, outputDirs[0], outputDirs[1]))
+}
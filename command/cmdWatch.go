@@ -0,0 +1,310 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config"
+	"github.com/jeshuam/jbuild/config/interfaces"
+	"github.com/jeshuam/jbuild/config/util"
+	"github.com/op/go-logging"
+)
+
+const (
+	// How long to wait for more changes after a file changes, so that saving
+	// several files at once only causes one build.
+	watchSettleTime = 200 * time.Millisecond
+
+	// Clears the terminal and moves the cursor to the top left.
+	clearScreen = "\033[H\033[2J"
+)
+
+// A TargetLoader loads the targets to build, returning the targets asked for
+// and every target they need (including themselves).
+type TargetLoader func() (map[string]interfaces.TargetSpec, map[string]interfaces.TargetSpec, error)
+
+// A watchedFiles is the set of files a build depends on.
+type watchedFiles struct {
+	// The targets which use each source file.
+	owners map[string][]interfaces.TargetSpec
+
+	// The BUILD files the targets were loaded from.
+	configFiles map[string]bool
+
+	// The files which are only read again when the args are loaded again: the
+	// WORKSPACE file (and the others the args were loaded from) and the Starlark
+	// files loaded by BUILD files.
+	workspaceFiles map[string]bool
+
+	// The directories searched by the targets' globs.
+	globDirs map[string]bool
+
+	// The directories containing any of the files, along with the glob
+	// directories.
+	dirs map[string]bool
+}
+
+// A watchedChange describes the files which changed since the last build.
+type watchedChange struct {
+	// Source files which were changed.
+	files map[string]bool
+
+	// Whether the targets have to be loaded again, e.g. because a BUILD file
+	// changed or a file was added where a glob might match it.
+	reload bool
+
+	// Whether a file the args were loaded from (like the WORKSPACE file) or a
+	// Starlark file changed, so the args have to be loaded again too.
+	workspace bool
+}
+
+// findWatchedFiles returns the files which the targets in `targets` depend on.
+func findWatchedFiles(args *argsModule.Args, targets map[string]interfaces.TargetSpec) *watchedFiles {
+	watched := &watchedFiles{
+		owners:         make(map[string][]interfaces.TargetSpec),
+		configFiles:    make(map[string]bool),
+		workspaceFiles: make(map[string]bool),
+		globDirs:       make(map[string]bool),
+		dirs:           make(map[string]bool),
+	}
+
+	// Only watch the files the args were loaded from which are there, or could
+	// be created in a directory that is.
+	for _, file := range args.ConfigFiles() {
+		if !common.IsDir(file) && common.IsDir(filepath.Dir(file)) {
+			watched.workspaceFiles[filepath.Clean(file)] = true
+		}
+	}
+
+	for _, file := range argsModule.StarlarkModulePaths() {
+		watched.workspaceFiles[filepath.Clean(file)] = true
+	}
+
+	for _, spec := range targets {
+		watched.configFiles[filepath.Join(spec.Path(), args.BuildFilename)] = true
+		for _, file := range config.SourceFiles(spec) {
+			filePath := filepath.Clean(file.FsPath())
+			watched.owners[filePath] = append(watched.owners[filePath], spec)
+		}
+
+		for _, dir := range config.GlobDirs(spec) {
+			watched.globDirs[filepath.Clean(dir)] = true
+		}
+	}
+
+	for file := range watched.owners {
+		watched.dirs[filepath.Dir(file)] = true
+	}
+
+	for file := range watched.configFiles {
+		watched.dirs[filepath.Dir(file)] = true
+	}
+
+	for file := range watched.workspaceFiles {
+		watched.dirs[filepath.Dir(file)] = true
+	}
+
+	for dir := range watched.globDirs {
+		watched.dirs[dir] = true
+	}
+
+	return watched
+}
+
+// change records in `change` how the event `event` changes the build, if at
+// all.
+func (this *watchedFiles) change(args *argsModule.Args, event fsnotify.Event, change *watchedChange) {
+	file := filepath.Clean(event.Name)
+	_, isSource := this.owners[file]
+	outputRel, err := filepath.Rel(args.OutputDir, file)
+	switch {
+	// jbuild's own output never changes the build.
+	case err == nil && outputRel != ".." && !strings.HasPrefix(outputRel, ".."+string(filepath.Separator)):
+
+	case this.workspaceFiles[file]:
+		change.reload, change.workspace = true, true
+
+	case this.configFiles[file]:
+		change.reload = true
+
+	case isSource && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		change.reload = true
+
+	case isSource:
+		change.files[file] = true
+
+	// Anything added to or removed from a directory searched by a glob (even a
+	// directory, for "**") might change what it matches.
+	case this.globDirs[filepath.Dir(file)] && event.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0:
+		change.reload = true
+
+	// New files might be matched by a glob, or be a new BUILD file.
+	case event.Op&fsnotify.Create != 0 && (filepath.Base(file) == args.BuildFilename ||
+		hasExtension(file, genSrcExtensions) || hasExtension(file, genHdrExtensions)):
+		change.reload = true
+	}
+}
+
+// waitForChanges blocks until one of the files in `watched` changes, and
+// returns what changed.
+func waitForChanges(args *argsModule.Args, watcher *fsnotify.Watcher, watched *watchedFiles) (*watchedChange, error) {
+	log := logging.MustGetLogger("jbuild")
+	change := &watchedChange{files: make(map[string]bool)}
+	var settled <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil, errors.New("Stopped watching for changes")
+			}
+
+			watched.change(args, event, change)
+			if change.reload || len(change.files) > 0 {
+				settled = time.After(watchSettleTime)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil, errors.New("Stopped watching for changes")
+			}
+
+			log.Warningf("Error while watching files: %s", err)
+
+		case <-settled:
+			return change, nil
+		}
+	}
+}
+
+// affectedTargets returns the targets in `targets` which use one of `files`,
+// directly or through their dependencies. If `files` is nil, every target is
+// affected.
+func affectedTargets(targets map[string]interfaces.TargetSpec, watched *watchedFiles, files map[string]bool) map[string]interfaces.TargetSpec {
+	if files == nil {
+		return targets
+	}
+
+	changed := make(map[string]bool)
+	for file := range files {
+		for _, spec := range watched.owners[file] {
+			changed[spec.String()] = true
+		}
+	}
+
+	affected := make(map[string]interfaces.TargetSpec)
+	for name, spec := range targets {
+		if changed[name] {
+			affected[name] = spec
+			continue
+		}
+
+		for _, dep := range spec.Dependencies(true) {
+			if changed[dep.String()] {
+				affected[name] = spec
+				break
+			}
+		}
+	}
+
+	return affected
+}
+
+// WatchTargets builds the targets returned by `load` (and, if `test` is set,
+// runs the tests among the targets asked for), and then does it again each
+// time a file they use changes. Only the targets affected by a change are
+// rebuilt and retested. If a BUILD or WORKSPACE file changes, or a file is
+// added or removed, the targets are loaded again. Errors are shown rather than
+// returned, unless the targets can't be loaded to begin with.
+func WatchTargets(args *argsModule.Args, test bool, load TargetLoader) error {
+	targetsSpecified, targetsToBuild, err := load()
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	defer watcher.Close()
+	watched := findWatchedFiles(args, targetsToBuild)
+	var changedFiles map[string]bool
+	for builds := 1; ; builds++ {
+		fmt.Print(clearScreen)
+		for dir := range watched.dirs {
+			if err := watcher.Add(dir); err != nil {
+				fmt.Printf("Could not watch '%s': %s\n", dir, err)
+			}
+		}
+
+		// Build the targets which changed, along with everything they need.
+		affected := affectedTargets(targetsToBuild, watched, changedFiles)
+		toBuild := make(map[string]interfaces.TargetSpec)
+		for name, spec := range affected {
+			toBuild[name] = spec
+			for _, dep := range spec.Dependencies(true) {
+				toBuild[dep.String()] = dep
+			}
+		}
+
+		if err := BuildTargets(args, toBuild); err != nil {
+			fmt.Printf("Error: %s\n", err)
+		} else if test {
+			toTest := make(map[string]interfaces.TargetSpec)
+			for name, spec := range targetsSpecified {
+				if _, ok := affected[name]; ok && strings.HasSuffix(spec.Type(), "test") {
+					toTest[name] = spec
+				}
+			}
+
+			// The results have been shown, and the next change might fix them.
+			RunTests(args, toTest)
+		}
+
+		if args.WatchBuilds > 0 && builds >= args.WatchBuilds {
+			return nil
+		}
+
+		fmt.Printf("\n%s Watching for changes...\n", time.Now().Format("15:04:05"))
+		for {
+			change, err := waitForChanges(args, watcher, watched)
+			if err != nil {
+				return err
+			}
+
+			changedFiles = change.files
+			if !change.reload {
+				break
+			}
+
+			// Load everything again from scratch.
+			if change.workspace {
+				newArgs, err := argsModule.Load(args.CurrentDir, args)
+				if err != nil {
+					fmt.Printf("Error: %s\n", err)
+					continue
+				}
+
+				*args = newArgs
+			}
+
+			util.ClearCaches()
+			newTargetsSpecified, newTargetsToBuild, err := load()
+			if err != nil {
+				fmt.Printf("%sError: %s\n", clearScreen, err)
+				continue
+			}
+
+			targetsSpecified, targetsToBuild = newTargetsSpecified, newTargetsToBuild
+			watched = findWatchedFiles(args, targetsToBuild)
+			changedFiles = nil
+			break
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+	"github.com/op/go-logging"
+)
+
+// A worker runs actions sent by other machines with the remote_executor flag.
+// Inputs are kept in `blobDir`, named by their digest, so they only need to be
+// uploaded once.
+type worker struct {
+	blobDir string
+	slots   chan bool
+}
+
+// blobPath returns where the blob with the digest `digest` is kept, or an error
+// if `digest` isn't a valid digest.
+func (this *worker) blobPath(digest string) (string, error) {
+	if len(digest) != 64 || strings.Trim(digest, "0123456789abcdef") != "" {
+		return "", errors.New(fmt.Sprintf("Invalid digest '%s'", digest))
+	}
+
+	return filepath.Join(this.blobDir, digest), nil
+}
+
+// execPath returns where the file at `path` (relative to the root of an action)
+// goes within `root`, or an error if it would be outside of `root`.
+func execPath(root, path string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(path))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(os.PathSeparator)) {
+		return "", errors.New(fmt.Sprintf("Invalid path '%s'", path))
+	}
+
+	return filepath.Join(root, clean), nil
+}
+
+// missing returns the digests in the request which the worker doesn't have.
+func (this *worker) missing(w http.ResponseWriter, r *http.Request) {
+	var digests []string
+	if err := json.NewDecoder(r.Body).Decode(&digests); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	missing := make([]string, 0)
+	for _, digest := range digests {
+		path, err := this.blobPath(digest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !common.FileExists(path) {
+			missing = append(missing, digest)
+		}
+	}
+
+	json.NewEncoder(w).Encode(missing)
+}
+
+// upload stores the blob in the request, after checking it has the digest it
+// was uploaded as.
+func (this *worker) upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Blobs must be uploaded with PUT", http.StatusMethodNotAllowed)
+		return
+	}
+
+	digest := strings.TrimPrefix(r.URL.Path, common.RemoteBlobPath)
+	path, err := this.blobPath(digest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if common.Digest(content) != digest {
+		http.Error(w, "Content doesn't match the digest", http.StatusBadRequest)
+		return
+	}
+
+	// Write to a temporary file first, so a blob is never seen half written.
+	tmp, err := ioutil.TempFile(this.blobDir, "upload")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tmp.Write(content)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// materialize copies the inputs of `request` into `root`.
+func (this *worker) materialize(request *common.RemoteRequest, root string) error {
+	for _, input := range request.Inputs {
+		path, err := execPath(root, input.Path)
+		if err != nil {
+			return err
+		}
+
+		blob, err := this.blobPath(input.Digest)
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(blob)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Missing input '%s'", input.Path))
+		}
+
+		mode := os.FileMode(0644)
+		if input.Executable {
+			mode = 0755
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(path, content, mode); err != nil {
+			return err
+		}
+	}
+
+	// Make the directories the outputs go in, as the command would expect them
+	// to exist.
+	for _, output := range request.Outputs {
+		path, err := execPath(root, output)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// run runs `request` in a new directory, and returns the response to send.
+func (this *worker) run(request *common.RemoteRequest) common.RemoteResponse {
+	root, err := ioutil.TempDir("", "jbuild-exec")
+	if err != nil {
+		return common.RemoteResponse{Error: err.Error()}
+	}
+
+	defer os.RemoveAll(root)
+	response := common.RemoteResponse{Root: root}
+	if err := this.materialize(request, root); err != nil {
+		response.Error = err.Error()
+		return response
+	}
+
+	dir, err := execPath(root, request.Dir)
+	if err != nil || len(request.Args) == 0 {
+		response.Error = "Invalid command"
+		return response
+	}
+
+	// Paths in the workspace of the client now refer to the same place in root.
+	replacer := strings.NewReplacer(request.Root, root)
+	cmdArgs := make([]string, 0, len(request.Args))
+	for _, arg := range request.Args {
+		cmdArgs = append(cmdArgs, replacer.Replace(arg))
+	}
+
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	cmd.Dir = dir
+	if len(request.Env) > 0 {
+		for _, env := range request.Env {
+			cmd.Env = append(cmd.Env, replacer.Replace(env))
+		}
+	}
+
+	this.slots <- true
+	output, err := cmd.CombinedOutput()
+	<-this.slots
+
+	// Show paths as the client would have seen them.
+	response.Output = strings.Replace(string(output), root, request.Root, -1)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		response.ExitCode = exitErr.ExitCode()
+		return response
+	} else if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+
+	for _, output := range request.Outputs {
+		path, _ := execPath(root, output)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			response.Error = fmt.Sprintf("Output '%s' was not created", output)
+			return response
+		}
+
+		response.Outputs = append(response.Outputs, common.RemoteOutput{Path: output, Content: content})
+	}
+
+	return response
+}
+
+// execute runs the RemoteRequest in the request.
+func (this *worker) execute(w http.ResponseWriter, r *http.Request) {
+	var request common.RemoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := this.run(&request)
+	if response.Error != "" {
+		logging.MustGetLogger("jbuild").Warningf("Could not run %s: %s", strings.Join(request.Args, " "), response.Error)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// ServeWorker runs the actions sent to `listener` by other machines, keeping
+// the inputs it is sent in `blobDir`. At most args.Threads actions are run at
+// once.
+func ServeWorker(args *argsModule.Args, listener net.Listener, blobDir string) error {
+	threads := args.Threads
+	if threads < 1 {
+		threads = 1
+	}
+
+	worker := &worker{blobDir: blobDir, slots: make(chan bool, threads)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(common.RemoteMissingPath, worker.missing)
+	mux.HandleFunc(common.RemoteBlobPath, worker.upload)
+	mux.HandleFunc(common.RemoteExecutePath, worker.execute)
+	return http.Serve(listener, mux)
+}
+
+// RunWorker runs a worker on the address given by the worker_address flag until
+// it is killed.
+func RunWorker(args *argsModule.Args, output io.Writer) error {
+	listener, err := net.Listen("tcp", args.WorkerAddress)
+	if err != nil {
+		return err
+	}
+
+	blobDir, err := ioutil.TempDir("", "jbuild-blobs")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(blobDir)
+	fmt.Fprintf(output, "Worker listening on %s\n", listener.Addr())
+	return ServeWorker(args, listener, blobDir)
+}
@@ -0,0 +1,161 @@
+package command
+
+import (
+	"sync"
+
+	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config"
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+// A scheduledAction is a command waiting to be run by an actionScheduler.
+type scheduledAction struct {
+	task      common.CmdSpec
+	resources common.Resources
+	priority  int
+}
+
+// An actionScheduler runs the commands sent to its task queue. At most
+// args.Threads commands run at once, and a command only starts once there are
+// enough resources left for it. Commands for targets on the critical path
+// (i.e. with the longest chain of targets waiting on them) are started first.
+type actionScheduler struct {
+	args    *args.Args
+	targets map[string]interfaces.TargetSpec
+
+	// The priority of the actions of each target.
+	priorities map[string]int
+
+	// The resources of this machine, and how much of them the running actions
+	// are using.
+	capacity common.Resources
+	used     common.Resources
+
+	lock      sync.Mutex
+	pending   []scheduledAction
+	running   int
+	freeSlots []int
+}
+
+// actionPriorities returns the length of the longest chain of targets in
+// `targets` which depend on each target, including itself.
+func actionPriorities(targets map[string]interfaces.TargetSpec) map[string]int {
+	dependents := make(map[string][]string)
+	for name, spec := range targets {
+		for _, dep := range spec.Dependencies(false) {
+			dependents[dep.String()] = append(dependents[dep.String()], name)
+		}
+	}
+
+	priorities := make(map[string]int)
+	var priority func(name string) int
+	priority = func(name string) int {
+		if p, ok := priorities[name]; ok {
+			return p
+		}
+
+		longest := 0
+		for _, dependent := range dependents[name] {
+			if p := priority(dependent); p > longest {
+				longest = p
+			}
+		}
+
+		priorities[name] = longest + 1
+		return longest + 1
+	}
+
+	for name := range targets {
+		priority(name)
+	}
+
+	return priorities
+}
+
+// startScheduler starts running the commands sent to `taskQueue` for the
+// targets in `targets`.
+func startScheduler(args *args.Args, targets map[string]interfaces.TargetSpec, taskQueue chan common.CmdSpec) {
+	scheduler := &actionScheduler{
+		args:       args,
+		targets:    targets,
+		priorities: actionPriorities(targets),
+		capacity:   common.LocalResources(args),
+	}
+
+	for slot := args.Threads; slot >= 1; slot-- {
+		scheduler.freeSlots = append(scheduler.freeSlots, slot)
+	}
+
+	go func() {
+		for task := range taskQueue {
+			scheduler.add(task)
+		}
+	}()
+}
+
+// add queues `task` to be run once there is room for it.
+func (this *actionScheduler) add(task common.CmdSpec) {
+	action := scheduledAction{task: task, resources: common.DefaultResources[task.Action.Kind]}
+	if spec, ok := this.targets[task.Action.Target]; ok {
+		action.resources = config.ActionResources(spec, task.Action.Kind)
+		action.priority = this.priorities[task.Action.Target]
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.pending = append(this.pending, action)
+	this.startActions()
+}
+
+// startActions starts as many pending actions as there is room for, highest
+// priority first. Actions which need more than this machine has are run on
+// their own. The lock must be held.
+func (this *actionScheduler) startActions() {
+	for len(this.freeSlots) > 0 {
+		best := -1
+		for i, action := range this.pending {
+			fits := this.running == 0 || this.used.Add(action.resources).Fits(this.capacity)
+			if fits && (best == -1 || action.priority > this.pending[best].priority) {
+				best = i
+			}
+		}
+
+		if best == -1 {
+			return
+		}
+
+		action := this.pending[best]
+		this.pending = append(this.pending[:best], this.pending[best+1:]...)
+		this.used = this.used.Add(action.resources)
+		this.running++
+		slot := this.freeSlots[len(this.freeSlots)-1]
+		this.freeSlots = this.freeSlots[:len(this.freeSlots)-1]
+		go this.run(action, slot)
+	}
+}
+
+// run runs `action` using the worker `slot`, and then starts whatever can run
+// now that it has finished.
+func (this *actionScheduler) run(action scheduledAction, slot int) {
+	task := action.task
+	task.Action.Slot = slot
+
+	// Try to acquire the lock for the command.
+	if task.Lock != nil {
+		task.Lock.Lock()
+	}
+
+	common.RunCommand(this.args, task.Action, task.Cmd, task.Result, task.Complete)
+
+	if task.Lock != nil {
+		task.Lock.Unlock()
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.used = this.used.Sub(action.resources)
+	this.running--
+	this.freeSlots = append(this.freeSlots, slot)
+	this.startActions()
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jeshuam/jbuild/args"
+)
+
+// ActionEnv returns the environment to run an action with: the variables in
+// `toolchainEnv` (as NAME=value), along with those given by the action_env
+// flag. Variables passed through without a value take it from the environment
+// of jbuild, and are left out if it isn't set there. Later variables replace
+// earlier ones with the same name, and the result is sorted so that it is the
+// same every time.
+func ActionEnv(args *args.Args, toolchainEnv []string) []string {
+	values := make(map[string]string)
+	for _, variable := range append(toolchainEnv, args.ActionEnv...) {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) == 2 {
+			values[parts[0]] = parts[1]
+		} else if value, ok := os.LookupEnv(parts[0]); ok {
+			values[parts[0]] = value
+		}
+	}
+
+	env := make([]string, 0, len(values))
+	for name, value := range values {
+		env = append(env, name+"="+value)
+	}
+
+	sort.Strings(env)
+	return env
+}
+
+// ActionKey returns the digest of everything about `cmd` which can change what
+// it outputs (other than its inputs): the command itself, where it is run and
+// its environment.
+func ActionKey(cmd *exec.Cmd) string {
+	parts := append([]string{cmd.Dir}, cmd.Args...)
+	parts = append(parts, "")
+	parts = append(parts, cmd.Env...)
+	return Digest([]byte(strings.Join(parts, "\x00")))
+}
+
+// actionKeyPath returns where the key of the action which created `output` is
+// recorded.
+func actionKeyPath(args *args.Args, output string) string {
+	return filepath.Join(args.ActionCacheDir, Digest([]byte(output)))
+}
+
+// ActionKeyChanged returns true iff `output` wasn't last created by running
+// `cmd`, or if it isn't known what created it.
+func ActionKeyChanged(args *args.Args, output string, cmd *exec.Cmd) bool {
+	key, err := ioutil.ReadFile(actionKeyPath(args, output))
+	return err != nil || string(key) != ActionKey(cmd)
+}
+
+// saveActionKey records that `output` was created by running `cmd`.
+func saveActionKey(args *args.Args, output string, cmd *exec.Cmd) error {
+	if err := os.MkdirAll(args.ActionCacheDir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(actionKeyPath(args, output), []byte(ActionKey(cmd)), 0644)
+}
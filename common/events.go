@@ -0,0 +1,121 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// The types of event written to the build event stream.
+const (
+	BuildStartedEvent     = "build_started"
+	TargetConfiguredEvent = "target_configured"
+	ActionStartedEvent    = "action_started"
+	ActionFinishedEvent   = "action_finished"
+	TargetCompletedEvent  = "target_completed"
+	TestResultEvent       = "test_result"
+	BuildFinishedEvent    = "build_finished"
+)
+
+// A BuildEvent is a single line of the build event stream. Only the fields
+// which make sense for the type of event are set.
+type BuildEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Set for build_started.
+	Arguments    []string `json:"arguments,omitempty"`
+	WorkspaceDir string   `json:"workspace_dir,omitempty"`
+
+	// Set for events about targets and actions.
+	Target     string `json:"target,omitempty"`
+	TargetType string `json:"target_type,omitempty"`
+
+	// Set for events about actions.
+	Kind    string   `json:"kind,omitempty"`
+	File    string   `json:"file,omitempty"`
+	Command []string `json:"command,omitempty"`
+	Output  string   `json:"output,omitempty"`
+	Remote  bool     `json:"remote,omitempty"`
+
+	// Set for events which finish something. Up-to-date targets and cached test
+	// results are successful, but weren't built or run again.
+	Success    *bool  `json:"success,omitempty"`
+	Cached     bool   `json:"cached,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	// Set for test_result, counting from 1.
+	Run int `json:"run,omitempty"`
+
+	// Set for build_finished.
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+var (
+	// Where build events are written, or nil if they aren't being written.
+	buildEventFile    *os.File
+	buildEventEncoder *json.Encoder
+	buildEventLock    sync.Mutex
+)
+
+// StartBuildEvents starts writing build events to the file at `path`, one JSON
+// object per line.
+func StartBuildEvents(path string) error {
+	buildEventLock.Lock()
+	defer buildEventLock.Unlock()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	buildEventFile, buildEventEncoder = file, json.NewEncoder(file)
+	return nil
+}
+
+// EmitBuildEvent writes `event` to the build event stream, if one was started.
+// The time of the event is filled in if it isn't set.
+func EmitBuildEvent(event BuildEvent) {
+	buildEventLock.Lock()
+	defer buildEventLock.Unlock()
+	if buildEventEncoder == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	buildEventEncoder.Encode(event)
+}
+
+// FinishBuildEvents writes the build_finished event, describing how the build
+// ended, and stops writing build events.
+func FinishBuildEvents(buildErr error) error {
+	exitCode := 0
+	event := BuildEvent{Type: BuildFinishedEvent, Success: EventBool(buildErr == nil), ExitCode: &exitCode}
+	if buildErr != nil {
+		exitCode = 1
+		event.Error = buildErr.Error()
+	}
+
+	EmitBuildEvent(event)
+
+	buildEventLock.Lock()
+	defer buildEventLock.Unlock()
+	if buildEventFile == nil {
+		return nil
+	}
+
+	err := buildEventFile.Close()
+	buildEventFile, buildEventEncoder = nil, nil
+	return err
+}
+
+// EventBool returns a pointer to `value`, for the optional fields of a
+// BuildEvent.
+func EventBool(value bool) *bool {
+	return &value
+}
@@ -0,0 +1,211 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeshuam/jbuild/args"
+)
+
+// The kinds of action recorded in a profile.
+const (
+	CompileAction  = "compile"
+	LinkAction     = "link"
+	GenruleAction  = "genrule"
+	DoxygenAction  = "doxygen"
+	TestAction     = "test"
+	UpToDateAction = "up-to-date"
+)
+
+// An Action describes a command run while building or testing, for profiling.
+type Action struct {
+	Target string // The target the command is run for.
+	Kind   string // What the command does, e.g. CompileAction.
+	File   string // The file the command works on, if there is just one.
+	Slot   int    // The worker which ran the command, starting at 1.
+
+	// The files the command reads and writes. Inputs are only needed for
+	// commands which can be run remotely, and the key of the command is
+	// recorded for the first output.
+	Inputs  []string
+	Outputs []string
+}
+
+// Name returns the name to show for the action.
+func (this Action) Name() string {
+	if this.File != "" {
+		return this.File
+	}
+
+	return this.Target
+}
+
+// A ProfileEvent is an action recorded in a profile. Cached actions weren't
+// run at all, because their outputs were already up to date.
+type ProfileEvent struct {
+	Action
+	Start    time.Duration // When the action started, since the profile started.
+	Duration time.Duration
+	Cached   bool
+}
+
+// End returns when the action finished, since the profile started.
+func (this ProfileEvent) End() time.Duration {
+	return this.Start + this.Duration
+}
+
+// A Profile is the set of actions run by a command.
+type Profile struct {
+	Events []ProfileEvent
+
+	// The number of actions which could run at once.
+	Slots int
+
+	// The dependencies of each target.
+	Deps map[string][]string
+}
+
+// A traceEvent is an event in the Chrome trace event format. Times are in
+// microseconds.
+type traceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat,omitempty"`
+	Phase     string                 `json:"ph"`
+	Timestamp int64                  `json:"ts"`
+	Duration  int64                  `json:"dur"`
+	Pid       int                    `json:"pid"`
+	Tid       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// A trace is a file in the Chrome trace event format. Other than traceEvents,
+// the fields are ignored by Chrome.
+type trace struct {
+	TraceEvents     []traceEvent        `json:"traceEvents"`
+	DisplayTimeUnit string              `json:"displayTimeUnit"`
+	Slots           int                 `json:"slots"`
+	Deps            map[string][]string `json:"deps"`
+}
+
+var (
+	// The actions recorded since the profile was started.
+	profileEvents  []ProfileEvent
+	profileStarted time.Time
+	profileLock    sync.Mutex
+)
+
+// StartProfile forgets any actions recorded so far, and starts recording
+// actions from now.
+func StartProfile() {
+	profileLock.Lock()
+	defer profileLock.Unlock()
+	profileEvents = nil
+	profileStarted = time.Now()
+}
+
+// RecordAction records that `action` was run at `start` for `duration`, or
+// that it was skipped if `cached` is set. Nothing is recorded unless the
+// profile flag is set.
+func RecordAction(args *args.Args, action Action, start time.Time, duration time.Duration, cached bool) {
+	if args.Profile == "" {
+		return
+	}
+
+	profileLock.Lock()
+	defer profileLock.Unlock()
+	profileEvents = append(profileEvents, ProfileEvent{
+		action, start.Sub(profileStarted), duration, cached})
+}
+
+// WriteProfile writes the actions recorded since the profile was started to
+// `path` in the Chrome trace event format, which can be loaded by
+// chrome://tracing. `deps` are the dependencies of each target built.
+func WriteProfile(path string, slots int, deps map[string][]string) error {
+	profileLock.Lock()
+	defer profileLock.Unlock()
+
+	output := trace{DisplayTimeUnit: "ms", Slots: slots, Deps: deps}
+	output.TraceEvents = append(output.TraceEvents, traceEvent{
+		Name: "thread_name", Phase: "M", Pid: 1, Tid: 0,
+		Args: map[string]interface{}{"name": "cache hits"}})
+	for slot := 1; slot <= slots; slot++ {
+		output.TraceEvents = append(output.TraceEvents, traceEvent{
+			Name: "thread_name", Phase: "M", Pid: 1, Tid: slot,
+			Args: map[string]interface{}{"name": fmt.Sprintf("worker %d", slot)}})
+	}
+
+	for _, event := range profileEvents {
+		tid := event.Slot
+		if event.Cached {
+			tid = 0
+		}
+
+		output.TraceEvents = append(output.TraceEvents, traceEvent{
+			Name:      event.Name(),
+			Category:  event.Kind,
+			Phase:     "X",
+			Timestamp: int64(event.Start / time.Microsecond),
+			Duration:  int64(event.Duration / time.Microsecond),
+			Pid:       1,
+			Tid:       tid,
+			Args: map[string]interface{}{
+				"target": event.Target,
+				"file":   event.File,
+				"slot":   event.Slot,
+				"cached": event.Cached,
+			},
+		})
+	}
+
+	content, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// ReadProfile reads the profile written to `path` by WriteProfile. The events
+// are sorted by when they started.
+func ReadProfile(path string) (*Profile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var input trace
+	if err := json.Unmarshal(content, &input); err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not read profile '%s': %s", path, err))
+	}
+
+	profile := &Profile{Slots: input.Slots, Deps: input.Deps}
+	for _, event := range input.TraceEvents {
+		if event.Phase != "X" {
+			continue
+		}
+
+		action := Action{Kind: event.Category}
+		action.Target, _ = event.Args["target"].(string)
+		action.File, _ = event.Args["file"].(string)
+		slot, _ := event.Args["slot"].(float64)
+		action.Slot = int(slot)
+		cached, _ := event.Args["cached"].(bool)
+		profile.Events = append(profile.Events, ProfileEvent{
+			action,
+			time.Duration(event.Timestamp) * time.Microsecond,
+			time.Duration(event.Duration) * time.Microsecond,
+			cached,
+		})
+	}
+
+	sort.SliceStable(profile.Events, func(i, j int) bool {
+		return profile.Events[i].Start < profile.Events[j].Start
+	})
+
+	return profile, nil
+}
@@ -0,0 +1,266 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jeshuam/jbuild/args"
+)
+
+// Actions are run on workers over HTTP. Files are sent to a worker by their
+// digest (the hex encoded SHA-256 of their content), and only uploaded if the
+// worker doesn't have them already:
+//
+//	POST /blobs/missing  takes a JSON list of digests, and returns the ones the
+//	                     worker doesn't have.
+//	PUT /blobs/DIGEST    uploads the file with the digest DIGEST.
+//	POST /execute        takes a RemoteRequest, runs it and returns a
+//	                     RemoteResponse.
+const (
+	RemoteMissingPath = "/blobs/missing"
+	RemoteBlobPath    = "/blobs/"
+	RemoteExecutePath = "/execute"
+)
+
+// A RemoteInput is a file needed to run an action on a worker.
+type RemoteInput struct {
+	Path       string // Relative to the root, with forward slashes.
+	Digest     string
+	Executable bool
+}
+
+// A RemoteOutput is a file created by running an action on a worker.
+type RemoteOutput struct {
+	Path    string // Relative to the root, with forward slashes.
+	Content []byte
+}
+
+// A RemoteRequest asks a worker to run a command. Any arguments or environment
+// variables which mention `Root` (the client's workspace directory) are changed
+// to refer to where the worker put the inputs instead.
+type RemoteRequest struct {
+	Root    string
+	Dir     string // Relative to the root.
+	Args    []string
+	Env     []string // If empty, the worker's environment is used.
+	Inputs  []RemoteInput
+	Outputs []string
+}
+
+// A RemoteResponse is the result of running a RemoteRequest. `Error` is set if
+// the command couldn't be run at all, rather than failing.
+type RemoteResponse struct {
+	Root     string // Where the worker ran the command.
+	ExitCode int
+	Output   string
+	Outputs  []RemoteOutput
+	Error    string
+}
+
+var (
+	// The digest of each file sent to a worker, keyed by path, size and
+	// modification time.
+	remoteDigests     = make(map[string]string)
+	remoteDigestsLock sync.Mutex
+)
+
+// Digest returns the digest of `content`.
+func Digest(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// remoteInput returns the RemoteInput for the file at `path`, which is
+// relative to `root`, along with its content.
+func remoteInput(root, path string) (RemoteInput, []byte, error) {
+	stat, err := os.Stat(filepath.Join(root, path))
+	if err != nil {
+		return RemoteInput{}, nil, err
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(root, path))
+	if err != nil {
+		return RemoteInput{}, nil, err
+	}
+
+	key := fmt.Sprintf("%s\x00%d\x00%d", path, stat.Size(), stat.ModTime().UnixNano())
+	remoteDigestsLock.Lock()
+	digest, ok := remoteDigests[key]
+	if !ok {
+		digest = Digest(content)
+		remoteDigests[key] = digest
+	}
+
+	remoteDigestsLock.Unlock()
+	return RemoteInput{filepath.ToSlash(path), digest, stat.Mode()&0111 != 0}, content, nil
+}
+
+// relativeToRoot returns `path` relative to `root`, or an error if it isn't
+// inside `root`.
+func relativeToRoot(root, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", errors.New(fmt.Sprintf("'%s' is outside the workspace", path))
+	}
+
+	return rel, nil
+}
+
+// postJson sends `request` as JSON to `url`, and decodes the JSON response into
+// `response`.
+func postJson(url string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	httpResponse, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(httpResponse.Body)
+		return errors.New(fmt.Sprintf("%s: %s", httpResponse.Status, strings.TrimSpace(string(message))))
+	}
+
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+// uploadInputs uploads the inputs in `inputs` which the worker at `base`
+// doesn't have yet. `contents` has the content of each input.
+func uploadInputs(base string, inputs []RemoteInput, contents map[string][]byte) error {
+	digests := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		digests = append(digests, input.Digest)
+	}
+
+	var missing []string
+	if err := postJson(base+RemoteMissingPath, digests, &missing); err != nil {
+		return err
+	}
+
+	for _, digest := range missing {
+		request, err := http.NewRequest(http.MethodPut, base+RemoteBlobPath+digest, bytes.NewReader(contents[digest]))
+		if err != nil {
+			return err
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return err
+		}
+
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return errors.New(fmt.Sprintf("Could not upload %s: %s", digest, response.Status))
+		}
+	}
+
+	return nil
+}
+
+// runRemotely runs `cmd` for `action` on the worker given by the
+// remote_executor flag, and writes the outputs of the action where `cmd` would
+// have. The output of the command is returned, and whether it was run at all.
+// If it was, the error is from the command itself, e.g. a compile error;
+// otherwise, the error says why it couldn't be run remotely.
+func runRemotely(args *args.Args, action Action, cmd *exec.Cmd) (string, bool, error) {
+	root := args.WorkspaceDir
+	request := RemoteRequest{Root: root, Dir: ".", Args: cmd.Args, Env: cmd.Env}
+	if cmd.Dir != "" {
+		dir, err := relativeToRoot(root, cmd.Dir)
+		if err != nil {
+			return "", false, err
+		}
+
+		request.Dir = filepath.ToSlash(dir)
+	}
+
+	contents := make(map[string][]byte)
+	for _, path := range action.Inputs {
+		rel, err := relativeToRoot(root, path)
+		if err != nil {
+			return "", false, err
+		}
+
+		input, content, err := remoteInput(root, rel)
+		if err != nil {
+			return "", false, err
+		}
+
+		request.Inputs = append(request.Inputs, input)
+		contents[input.Digest] = content
+	}
+
+	for _, path := range action.Outputs {
+		rel, err := relativeToRoot(root, path)
+		if err != nil {
+			return "", false, err
+		}
+
+		request.Outputs = append(request.Outputs, filepath.ToSlash(rel))
+	}
+
+	base := "http://" + args.RemoteExecutor
+	if err := uploadInputs(base, request.Inputs, contents); err != nil {
+		return "", false, err
+	}
+
+	var response RemoteResponse
+	if err := postJson(base+RemoteExecutePath, request, &response); err != nil {
+		return "", false, err
+	}
+
+	if response.Error != "" {
+		return response.Output, false, errors.New(response.Error)
+	} else if response.ExitCode != 0 {
+		return response.Output, true, errors.New(fmt.Sprintf("exited with code %d", response.ExitCode))
+	}
+
+	// Only the outputs which were asked for are written. A worker which returns
+	// anything else can't be trusted with this action, so it is run locally.
+	requested := make(map[string]bool, len(request.Outputs))
+	for _, output := range request.Outputs {
+		requested[output] = true
+	}
+
+	for _, output := range response.Outputs {
+		if !requested[output.Path] {
+			return "", false, errors.New(fmt.Sprintf(
+				"worker returned '%s', which is not an output of this action", output.Path))
+		}
+	}
+
+	for _, output := range response.Outputs {
+		path := filepath.Join(root, filepath.FromSlash(output.Path))
+		content := output.Content
+
+		// Depfiles list the files read, which are where the worker put them.
+		if strings.HasSuffix(path, ".d") {
+			content = bytes.Replace(content, []byte(response.Root), []byte(root), -1)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", false, err
+		}
+
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			return "", false, err
+		}
+	}
+
+	return response.Output, true, nil
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jeshuam/jbuild/args"
+)
+
+// Resources are the CPU and RAM (in MB) needed by an action, or available to
+// run actions. Zero RAM available means there is no limit.
+type Resources struct {
+	CPU float64
+	RAM float64
+}
+
+var (
+	// The resources needed by each kind of action, unless the target says
+	// otherwise.
+	DefaultResources = map[string]Resources{
+		CompileAction: {1, 512},
+		LinkAction:    {1, 2048},
+		GenruleAction: {1, 256},
+		DoxygenAction: {1, 512},
+		TestAction:    {1, 512},
+	}
+)
+
+// Add returns the resources needed by both `this` and `other`.
+func (this Resources) Add(other Resources) Resources {
+	return Resources{this.CPU + other.CPU, this.RAM + other.RAM}
+}
+
+// Sub returns the resources left after taking `other` from `this`.
+func (this Resources) Sub(other Resources) Resources {
+	return Resources{this.CPU - other.CPU, this.RAM - other.RAM}
+}
+
+// Fits returns true iff `this` is within `capacity`.
+func (this Resources) Fits(capacity Resources) bool {
+	return this.CPU <= capacity.CPU && (capacity.RAM <= 0 || this.RAM <= capacity.RAM)
+}
+
+// hostMemory returns the total physical memory of this machine in MB, or 0 if
+// it isn't known.
+func hostMemory() float64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseFloat(fields[1], 64)
+			if err == nil {
+				return kb / 1024
+			}
+		}
+	}
+
+	return 0
+}
+
+// LocalResources returns the resources available for running actions on this
+// machine. Unless set by the flags, there is a CPU for each thread, and two
+// thirds of the physical memory can be used.
+func LocalResources(args *args.Args) Resources {
+	local := Resources{args.LocalCpuResources, float64(args.LocalRamResources)}
+	if local.CPU <= 0 {
+		local.CPU = float64(args.Threads)
+	}
+
+	if local.RAM <= 0 {
+		local.RAM = hostMemory() * 2 / 3
+	}
+
+	return local
+}
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"path/filepath"
+
+	"github.com/jeshuam/jbuild/args"
+)
+
+// The name of the file in a runfiles directory which lists the runfiles, one
+// "path target" pair per line. Paths are relative to the workspace and always
+// use "/", and targets are the files they refer to.
+const RunfilesManifest = "MANIFEST"
+
+// The C++ runfiles library. It is header-only, so that it can be included by
+// any target without adding a dependency.
+const runfilesHeader = `// Generated by jbuild. Do not edit.
+//
+// Finds the runfiles of a binary or test built by jbuild (the data files of it
+// and the libraries it depends on) by their path in the workspace:
+//
+//   #include "jbuild/runfiles.h"
+//
+//   std::string path = jbuild::runfiles::Rlocation("some/dir/data.txt", argv[0]);
+#pragma once
+
+#include <cstdlib>
+#include <fstream>
+#include <string>
+
+namespace jbuild {
+namespace runfiles {
+
+// Dir returns the runfiles directory of the running binary. This is
+// RUNFILES_DIR (or TEST_SRCDIR) if it is set, which it is for "jbuild run" and
+// "jbuild test". Otherwise, it is found next to the binary at argv0, if given.
+inline std::string Dir(const char* argv0 = nullptr) {
+  for (const char* name : {"RUNFILES_DIR", "TEST_SRCDIR"}) {
+    const char* value = std::getenv(name);
+    if (value != nullptr && value[0] != '\0') {
+      return value;
+    }
+  }
+
+  if (argv0 == nullptr || argv0[0] == '\0') {
+    return "";
+  }
+
+  std::string binary = argv0;
+  const std::string exe = ".exe";
+  if (binary.size() > exe.size() &&
+      binary.compare(binary.size() - exe.size(), exe.size(), exe) == 0) {
+    binary.resize(binary.size() - exe.size());
+  }
+
+  return binary + ".runfiles";
+}
+
+// Rlocation returns the path of the runfile at "path" in the workspace (which
+// may start with "//"), or "" if it isn't one of the runfiles of the binary.
+inline std::string Rlocation(std::string path, const char* argv0 = nullptr) {
+  if (path.compare(0, 2, "//") == 0) {
+    path = path.substr(2);
+  }
+
+  const std::string dir = Dir(argv0);
+  if (dir.empty() || path.empty()) {
+    return "";
+  }
+
+  std::ifstream manifest(dir + "/MANIFEST");
+  std::string line;
+  while (std::getline(manifest, line)) {
+    if (line.size() > path.size() && line[path.size()] == ' ' &&
+        line.compare(0, path.size(), path) == 0) {
+      return dir + "/" + path;
+    }
+  }
+
+  return "";
+}
+
+}  // namespace runfiles
+}  // namespace jbuild
+`
+
+// RunfilesDir returns the directory holding the runfiles of the executable
+// `name` built into `dir`.
+func RunfilesDir(dir, name string) string {
+	return filepath.Join(dir, name+".runfiles")
+}
+
+// RunfilesEnv returns the environment variables which tell an executable where
+// its runfiles in `runfilesDir` are.
+func RunfilesEnv(runfilesDir string) []string {
+	return []string{
+		"RUNFILES_DIR=" + runfilesDir,
+		"RUNFILES_MANIFEST_FILE=" + filepath.Join(runfilesDir, RunfilesManifest),
+		"TEST_SRCDIR=" + runfilesDir,
+	}
+}
+
+// RunfilesIncludeDir returns the directory the runfiles library is written to.
+// It is added to the include path of all C++ targets.
+func RunfilesIncludeDir(args *args.Args) string {
+	return filepath.Join(args.OutputDir, ".jbuild-include")
+}
+
+// RunfilesHeader returns the path of the runfiles library, included as
+// "jbuild/runfiles.h".
+func RunfilesHeader(args *args.Args) string {
+	return filepath.Join(RunfilesIncludeDir(args), "jbuild", "runfiles.h")
+}
+
+// WriteRunfilesHeader writes the runfiles library, if it isn't there already.
+func WriteRunfilesHeader(args *args.Args) error {
+	return writeIfChanged(RunfilesHeader(args), []byte(runfilesHeader))
+}
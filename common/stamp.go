@@ -0,0 +1,205 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jeshuam/jbuild/args"
+)
+
+// The WORKSPACE option giving a command which prints extra status keys, one
+// "KEY value" pair per line.
+const WorkspaceStatusKey = "workspace_status_command"
+
+// The status keys set by jbuild itself, and the values they have in builds
+// which aren't stamped. Keys printed by the workspace status command are empty
+// in builds which aren't stamped.
+var unstampedStatus = map[string]string{
+	"BUILD_SCM_REVISION": "unknown",
+	"BUILD_SCM_STATUS":   "unknown",
+	"BUILD_TIMESTAMP":    "0",
+	"BUILD_USER":         "unknown",
+}
+
+// StampDir returns the directory the stamp files are written to. It is added to
+// the include path of C++ targets which are stamped.
+func StampDir(args *args.Args) string {
+	return filepath.Join(args.OutputDir, ".jbuild-stamp")
+}
+
+// StampFile returns the path of the file listing the workspace status, one
+// "KEY value" pair per line. This is what $(STAMP_FILE) is replaced with in
+// genrules.
+func StampFile(args *args.Args) string {
+	return filepath.Join(StampDir(args), "workspace_status.txt")
+}
+
+// StampHeader returns the path of the header which #defines each status key,
+// included as "jbuild/stamp.h".
+func StampHeader(args *args.Args) string {
+	return filepath.Join(StampDir(args), "jbuild", "stamp.h")
+}
+
+// git runs git with `gitArgs` in the workspace, returning what it printed.
+func git(args *args.Args, gitArgs ...string) (string, error) {
+	cmd := exec.Command("git", gitArgs...)
+	cmd.Dir = args.WorkspaceDir
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+// parseStatus adds the "KEY value" pairs in `output` to `status`. Values are
+// left empty unless `stamp` is set.
+func parseStatus(output string, status map[string]string, stamp bool) {
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if parts[0] == "" {
+			continue
+		}
+
+		value := ""
+		if stamp && len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+		}
+
+		status[parts[0]] = value
+	}
+}
+
+// WorkspaceStatus returns the status keys for the build. If the stamp flag is
+// set, these describe the git commit the workspace is at, whether it has been
+// changed, when the build happened and who ran it. Otherwise, they have fixed
+// values so that stamped outputs don't need to be rebuilt every time.
+func WorkspaceStatus(args *args.Args) (map[string]string, error) {
+	status := make(map[string]string)
+	for key, value := range unstampedStatus {
+		status[key] = value
+	}
+
+	if args.Stamp {
+		if revision, err := git(args, "rev-parse", "HEAD"); err == nil {
+			status["BUILD_SCM_REVISION"] = revision
+		}
+
+		if changes, err := git(args, "status", "--porcelain"); err == nil && changes != "" {
+			status["BUILD_SCM_STATUS"] = "modified"
+		} else if err == nil {
+			status["BUILD_SCM_STATUS"] = "clean"
+		}
+
+		status["BUILD_TIMESTAMP"] = strconv.FormatInt(time.Now().Unix(), 10)
+		if usr, err := user.Current(); err == nil {
+			status["BUILD_USER"] = usr.Username
+		}
+	}
+
+	// The command is run even when not stamping, so that the same keys exist.
+	command, _ := args.WorkspaceOptions[WorkspaceStatusKey].(string)
+	if command == "" {
+		return status, nil
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Dir = args.WorkspaceDir
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"%s '%s' failed: %s\n%s", WorkspaceStatusKey, command, err, stderr.String()))
+	}
+
+	parseStatus(string(output), status, args.Stamp)
+	return status, nil
+}
+
+// cString returns `value` as a C string literal.
+func cString(value string) string {
+	var literal strings.Builder
+	literal.WriteByte('"')
+	for _, c := range []byte(value) {
+		switch {
+		case c == '"' || c == '\\':
+			literal.WriteByte('\\')
+			literal.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&literal, "\\%03o", c)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+
+	literal.WriteByte('"')
+	return literal.String()
+}
+
+// macroName returns `key` as the name of a macro.
+func macroName(key string) string {
+	return strings.Map(func(c rune) rune {
+		if c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			return unicode.ToUpper(c)
+		}
+
+		return '_'
+	}, key)
+}
+
+// writeIfChanged writes `content` to the file at `path`, unless it already has
+// that content. This keeps the modification time of the file the same, so
+// that nothing using it is rebuilt.
+func writeIfChanged(path string, content []byte) error {
+	if existing, err := ioutil.ReadFile(path); err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// WriteStampFiles writes the workspace status to the stamp file and header.
+func WriteStampFiles(args *args.Args) error {
+	status, err := WorkspaceStatus(args)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(status))
+	for key := range status {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	var file, header bytes.Buffer
+	header.WriteString("// Generated by jbuild from the workspace status. Do not edit.\n#pragma once\n\n")
+	for _, key := range keys {
+		fmt.Fprintf(&file, "%s %s\n", key, status[key])
+		fmt.Fprintf(&header, "#define %s %s\n", macroName(key), cString(status[key]))
+	}
+
+	if err := writeIfChanged(StampFile(args), file.Bytes()); err != nil {
+		return err
+	}
+
+	return writeIfChanged(StampHeader(args), header.Bytes())
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
 	"time"
 
@@ -33,9 +34,10 @@ type CmdSpec struct {
 	Lock     *sync.Mutex
 	Result   chan error
 	Complete func(string, bool, time.Duration)
+	Action   Action
 }
 
-func RunCommand(args *args.Args, cmd *exec.Cmd, result chan error, complete func(string, bool, time.Duration)) {
+func RunCommand(args *args.Args, action Action, cmd *exec.Cmd, result chan error, complete func(string, bool, time.Duration)) {
 	log := logging.MustGetLogger("jbuild")
 
 	// Print the command.
@@ -52,7 +54,7 @@ func RunCommand(args *args.Args, cmd *exec.Cmd, result chan error, complete func
 		}
 
 		if args.ShowCommandEnv {
-			log.Debug(cmd.Env)
+			log.Debugf("env: %s", strings.Join(cmd.Env, " "))
 		}
 	}
 
@@ -62,15 +64,44 @@ func RunCommand(args *args.Args, cmd *exec.Cmd, result chan error, complete func
 	cmd.Stderr = &out
 
 	// Run the command.
+	EmitBuildEvent(BuildEvent{
+		Type: ActionStartedEvent, Target: action.Target, Kind: action.Kind,
+		File: action.File, Command: cmd.Args})
 	startTime := time.Now()
-	err := cmd.Run()
+	output, err, remote := "", error(nil), false
+
+	// Compiles can run on a worker instead, but are run here if the worker
+	// can't run them. If the command itself failed, that is the result.
+	if args.RemoteExecutor != "" && action.Kind == CompileAction && len(action.Inputs) > 0 {
+		output, remote, err = runRemotely(args, action, cmd)
+		if !remote {
+			log.Warningf("Running %s locally, as running it remotely failed: %s", action.Name(), err)
+		}
+	}
+
+	if !remote {
+		err = cmd.Run()
+		output = out.String()
+	}
+
 	elaspedTime := time.Since(startTime)
+	RecordAction(args, action, startTime, elaspedTime, false)
+
+	finished := BuildEvent{
+		Type: ActionFinishedEvent, Target: action.Target, Kind: action.Kind,
+		File: action.File, Command: cmd.Args, Output: output, Remote: remote,
+		Success: EventBool(err == nil), DurationMs: int64(elaspedTime / time.Millisecond)}
+	if err != nil {
+		finished.Error = err.Error()
+	}
+
+	EmitBuildEvent(finished)
 	if err != nil {
 		if complete != nil {
-			complete(out.String(), false, elaspedTime)
+			complete(output, false, elaspedTime)
 		}
-		if out.String() != "" {
-			result <- errors.New(out.String())
+		if output != "" {
+			result <- errors.New(output)
 		} else {
 			result <- err
 		}
@@ -78,8 +109,15 @@ func RunCommand(args *args.Args, cmd *exec.Cmd, result chan error, complete func
 		return
 	}
 
+	// Remember how the outputs were made, so they are remade if that changes.
+	if len(action.Outputs) > 0 {
+		if err := saveActionKey(args, action.Outputs[0], cmd); err != nil {
+			log.Warningf("Could not record how %s was built: %s", action.Outputs[0], err)
+		}
+	}
+
 	if complete != nil {
-		complete(out.String(), true, elaspedTime)
+		complete(output, true, elaspedTime)
 	}
 	result <- nil
 }
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"time"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config/util"
+)
+
+// changedSince returns true iff the file at `path` was modified (or removed)
+// after `since`.
+func changedSince(path string, since time.Time) bool {
+	stat, err := os.Stat(path)
+	return err != nil || stat.ModTime().After(since)
+}
+
+// CachesChangedSince returns true iff the specs cached so far might no longer
+// match their BUILD files, because a BUILD file or Starlark file they were
+// loaded from, or a directory searched by one of their globs changed after
+// `since`. Changes to the files the args were loaded from are found by
+// ConfigFilesChanged.
+func CachesChangedSince(args *argsModule.Args, since time.Time) bool {
+	for _, path := range argsModule.StarlarkModulePaths() {
+		if changedSince(path, since) {
+			return true
+		}
+	}
+
+	for _, spec := range util.SpecCache {
+		targetSpec, ok := spec.(*TargetSpecImpl)
+		if !ok || targetSpec.source.path == "" {
+			continue
+		}
+
+		if changedSince(targetSpec.source.path, since) ||
+			dirsChangedSince(targetSpec.globDirs, since) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConfigFileTimes returns when each of the files the args were loaded from was
+// last modified, or the zero time if it doesn't exist.
+func ConfigFileTimes(args *argsModule.Args) map[string]time.Time {
+	times := make(map[string]time.Time)
+	for _, path := range args.ConfigFiles() {
+		times[path] = time.Time{}
+		if stat, err := os.Stat(path); err == nil {
+			times[path] = stat.ModTime()
+		}
+	}
+
+	return times
+}
+
+// ConfigFilesChanged returns true iff any of the files in `times` (from
+// ConfigFileTimes) has been created or removed since then, or modified since
+// then or after `since`.
+func ConfigFilesChanged(times map[string]time.Time, since time.Time) bool {
+	for path, modTime := range times {
+		stat, err := os.Stat(path)
+		if err != nil {
+			if !modTime.IsZero() {
+				return true
+			}
+		} else if !stat.ModTime().Equal(modTime) || stat.ModTime().After(since) {
+			return true
+		}
+	}
+
+	return false
+}
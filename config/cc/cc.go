@@ -10,6 +10,7 @@ import (
 
 	"github.com/jeshuam/jbuild/args"
 	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config/interfaces"
 	"github.com/jeshuam/jbuild/config/util"
 	"github.com/jeshuam/jbuild/progress"
 	"github.com/op/go-logging"
@@ -21,11 +22,17 @@ var (
 	locksMutex = new(sync.Mutex)
 )
 
+// compileAction returns the action which compiles `srcFile` for `target`.
+func compileAction(target *Target, srcFile interfaces.FileSpec) common.Action {
+	return common.Action{Target: target.Spec.String(), Kind: common.CompileAction, File: srcFile.String()}
+}
+
 // Compile the source files within the given target.
 func compileFiles(args *args.Args, target *Target, progressBar *progress.ProgressBar, taskQueue chan common.CmdSpec, force bool) ([]string, int, error) {
 	objs := make([]string, 0, len(target.srcs()))
 	results := make(chan error, len(target.srcs()))
 	nCompiled := 0
+	compiled := make(map[string]interfaces.FileSpec)
 
 	for _, srcFile := range target.srcs() {
 		// Display the source file we are building.
@@ -46,19 +53,31 @@ func compileFiles(args *args.Args, target *Target, progressBar *progress.Progres
 			return nil, 0, err
 		}
 
-		// If the object is newer than the source file, don't compile it again.
+		// Build the compilation command.
+		cmd := compileCommand(args, target, srcPath, objPath)
+
+		// If the object is newer than the source file and was compiled the same
+		// way, don't compile it again.
 		if !force {
 			srcStat, _ := os.Stat(srcPath)
 			objStat, _ := os.Stat(objPath)
 			srcChanged := true
 			depsChanged := false
 			if objStat != nil {
-				depsChanged = target.depsChangedSince(objStat)
-				srcChanged = !objStat.ModTime().After(srcStat.ModTime())
+				depsChanged = target.depsChangedSince(objStat) ||
+					target.stampChangedFor(srcPath, objPath, objStat)
+				srcChanged = !objStat.ModTime().After(srcStat.ModTime()) ||
+					common.ActionKeyChanged(args, objPath, cmd)
+			}
+
+			// Objects compiled without a depfile can't be checked.
+			if writesDepfile(args, target) && !common.FileExists(depfilePath(objPath)) {
+				srcChanged = true
 			}
 
 			// Recompile this file if the deps or src has changed.
 			if !depsChanged && !srcChanged {
+				common.RecordAction(args, compileAction(target, srcFile), time.Now(), 0, true)
 				progressBar.Increment()
 				continue
 			}
@@ -73,20 +92,24 @@ func compileFiles(args *args.Args, target *Target, progressBar *progress.Progres
 		}
 		locksMutex.Unlock()
 
-		// Build the compilation command.
 		if srcFile.IsGenerated() {
 			log.Debugf("... compile %s (generated)", srcFile)
 		} else {
 			log.Debugf("... compile %s", srcFile)
 		}
 
-		cmd := compileCommand(args, target, srcPath, objPath)
-
 		// Run the command.
 		nCompiled++
+		compiled[objPath] = srcFile
+		action := compileAction(target, srcFile)
+		action.Outputs = compileOutputs(args, target, objPath)
+		if args.RemoteExecutor != "" {
+			action.Inputs = compileInputs(args, target, srcFile, objPath)
+		}
+
 		taskQueue <- common.CmdSpec{cmd, lock, results, func(string, bool, time.Duration) {
 			progressBar.Increment()
-		}}
+		}, action}
 	}
 
 	// Check results.
@@ -97,6 +120,20 @@ func compileFiles(args *args.Args, target *Target, progressBar *progress.Progres
 		}
 	}
 
+	// Make sure only the headers the target is allowed to use were included.
+	if args.LayeringCheck && args.CCCompiler != "cl.exe" && !args.DryRun {
+		for _, objPath := range objs {
+			srcFile, ok := compiled[objPath]
+			if !ok {
+				continue
+			}
+
+			if err := checkLayering(args, target, srcFile, objPath); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
 	return objs, nCompiled, nil
 }
 
@@ -108,7 +145,12 @@ func linkObjects(args *args.Args, target *Target, progressBar *progress.Progress
 
 	// Work out the output filepath.
 	outputPath := target.OutputPath()
-	if nCompiled == 0 && common.FileExists(outputPath) && !target.depsUpdated() {
+	outputStat, _ := os.Stat(outputPath)
+	linkAction := common.Action{Target: target.Spec.String(), Kind: common.LinkAction, Outputs: []string{outputPath}}
+	cmd := linkCommand(args, target, objects, outputPath)
+	if nCompiled == 0 && outputStat != nil && !target.depsUpdated() && !target.globsChanged(outputStat) &&
+		!common.ActionKeyChanged(args, outputPath, cmd) {
+		common.RecordAction(args, linkAction, time.Now(), 0, true)
 		progressBar.Increment()
 		return outputPath, nil
 	}
@@ -125,12 +167,11 @@ func linkObjects(args *args.Args, target *Target, progressBar *progress.Progress
 
 	// Now, we need to build up the command to run.
 	log.Debugf("... link %s", outputPath)
-	cmd := linkCommand(args, target, objects, outputPath)
 
 	// Run the command.
 	taskQueue <- common.CmdSpec{cmd, lock, result, func(string, bool, time.Duration) {
 		progressBar.Increment()
-	}}
+	}, linkAction}
 
 	err := <-result
 	if err != nil {
@@ -4,12 +4,38 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
 	"github.com/jeshuam/jbuild/config/util"
 )
 
+// reproducibleCompileFlags returns the flags which stop objects depending on
+// where they were built or when. The output directory is usually inside the
+// workspace, so it is mapped last to take precedence.
+func reproducibleCompileFlags(args *args.Args) []string {
+	flags := []string{"-Wno-builtin-macro-redefined"}
+	for _, macro := range []string{"__DATE__", "__TIME__", "__TIMESTAMP__"} {
+		flags = append(flags, "-D"+macro+"=\"redacted\"")
+	}
+
+	prefixes := [][]string{
+		{args.ExternalRepoDir, "external"},
+		{args.WorkspaceDir, "."},
+		{args.OutputDir, "bin"},
+	}
+
+	for _, prefix := range prefixes {
+		flags = append(flags,
+			"-ffile-prefix-map="+prefix[0]+"="+prefix[1],
+			"-fdebug-prefix-map="+prefix[0]+"="+prefix[1])
+	}
+
+	return flags
+}
+
 func compileCommand(args *args.Args, target *Target, src, obj string) *exec.Cmd {
 	compiler := args.CCCompiler
 
@@ -21,6 +47,15 @@ func compileCommand(args *args.Args, target *Target, src, obj string) *exec.Cmd
 		flags = append(flags, []string{
 			"-fcolor-diagnostics",
 			"-c", "-o", obj, src}...)
+
+		// The layering check needs to know which headers were included.
+		if writesDepfile(args, target) {
+			flags = append(flags, "-MD", "-MF", depfilePath(obj))
+		}
+	}
+
+	if compiler == "cl.exe" && args.Reproducible {
+		flags = append(flags, "/Brepro")
 	}
 
 	// Add the OS as a #define, which could be useful.
@@ -39,18 +74,34 @@ func compileCommand(args *args.Args, target *Target, src, obj string) *exec.Cmd
 		}
 	}
 
+	if target.Stamp {
+		if compiler == "cl.exe" {
+			flags = append(flags, "/I"+common.StampDir(args))
+		} else {
+			flags = append(flags, "-I"+common.StampDir(args))
+		}
+	}
+
 	if compiler == "cl.exe" {
 		flags = append(flags, "/I"+args.WorkspaceDir)
 		flags = append(flags, "/I"+args.ExternalRepoDir)
 		flags = append(flags, "/I"+args.GenOutputDir)
+		flags = append(flags, "/I"+common.RunfilesIncludeDir(args))
 	} else {
 		flags = append(flags, "-I"+args.WorkspaceDir)
 		flags = append(flags, "-I"+args.ExternalRepoDir)
 		flags = append(flags, "-I"+args.GenOutputDir)
+		flags = append(flags, "-I"+common.RunfilesIncludeDir(args))
 	}
 
-	// Make the command.
-	command := exec.Command(compiler, util.MakeUnique(flags)...)
+	// Make the command. The prefix maps all have the same name, so are added
+	// after removing the duplicate flags.
+	flags = util.MakeUnique(flags)
+	if args.Reproducible && compiler != "cl.exe" {
+		flags = append(flags, reproducibleCompileFlags(args)...)
+	}
+
+	command := exec.Command(compiler, flags...)
 
 	// Prepare the command's environment. This will do different things depending
 	// on whether this is windows or linux.
@@ -82,8 +133,16 @@ func linkCommand(args *args.Args, target *Target, objs []string, output string)
 	flags := []string{}
 	if linker == "lib.exe" || linker == "link.exe" {
 		flags = []string{"/OUT:" + output, "msvcrt.lib"}
+		if args.Reproducible {
+			flags = append(flags, "/Brepro")
+		}
 	} else if linker == "ar" {
-		flags = []string{"cr", output}
+		// Deterministic archives have no timestamps, owners or modes.
+		if args.Reproducible {
+			flags = []string{"crD", output}
+		} else {
+			flags = []string{"cr", output}
+		}
 	} else {
 		if target.IsLibrary() {
 			flags = append(flags, "-shared")
@@ -92,7 +151,13 @@ func linkCommand(args *args.Args, target *Target, objs []string, output string)
 		flags = append(flags, []string{"-o", output}...)
 	}
 
-	// Add the objects to the command-line.
+	// Add the objects to the command-line. Sources can come from globs, which
+	// don't always list files in the same order.
+	if args.Reproducible {
+		objs = append([]string{}, objs...)
+		sort.Strings(objs)
+	}
+
 	flags = append(flags, objs...)
 
 	// Link in libraries for binaries.
@@ -2,13 +2,24 @@ package cc
 
 import (
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
 )
 
-func prepareEnvironment(*args.Args, *Target, *exec.Cmd) {
+// The PATH that compile and link commands are run with, after the directory of
+// the compiler or linker itself.
+const actionPath = "/usr/local/bin:/usr/bin:/bin"
 
+func prepareEnvironment(args *args.Args, target *Target, cmd *exec.Cmd) {
+	path := actionPath
+	if filepath.IsAbs(cmd.Path) {
+		path = filepath.Dir(cmd.Path) + ":" + path
+	}
+
+	cmd.Env = common.ActionEnv(args, []string{"PATH=" + path, "LANG=C"})
 }
 
 func LibraryName(name string) string {
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
 	"golang.org/x/sys/windows/registry"
 )
 
@@ -69,7 +70,14 @@ func prepareEnvironment(args *args.Args, target *Target, cmd *exec.Cmd) {
 		windowsLoadSdkDir(args)
 	}
 
-	env := os.Environ()
+	// The compiler and linker need to know where Windows is and where to put
+	// temporary files, but nothing else is passed through unless asked for.
+	env := make([]string, 0)
+	for _, name := range []string{"SystemRoot", "TEMP", "TMP"} {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
 
 	// Set PATH.
 	env = append(env, fmt.Sprintf(
@@ -101,7 +109,7 @@ func prepareEnvironment(args *args.Args, target *Target, cmd *exec.Cmd) {
 		filepath.Join(ucrtSdkDir, "Lib", ucrtSdkVersion, "ucrt", "x64"),
 		filepath.Join(ucrtSdkDir, "Lib", ucrtSdkVersion, "um", "x64")))
 
-	cmd.Env = env
+	cmd.Env = common.ActionEnv(args, env)
 
 	filename := filepath.Base(cmd.Args[0])
 	if filename == "cl.exe" || filename == "link.exe" || filename == "lib.exe" {
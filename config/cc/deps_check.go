@@ -0,0 +1,167 @@
+package cc
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+// A DepsReport describes which of the dependencies of a C++ target are used by
+// the target itself.
+type DepsReport struct {
+	// Direct deps which the target doesn't use.
+	Unused []interfaces.TargetSpec
+
+	// Deps which the target uses, but only depends on through another dep.
+	Missing []interfaces.TargetSpec
+
+	// How the target uses each of the missing deps, keyed by the dep.
+	Reasons map[string]string
+}
+
+// readSymbols returns the symbols in the object file or library at `file`
+// which are defined (or, if `defined` isn't set, are used but not defined).
+func readSymbols(file string, defined bool) (map[string]bool, error) {
+	flag := "--undefined-only"
+	if defined {
+		flag = "--defined-only"
+	}
+
+	output, err := exec.Command("nm", "-C", flag, file).Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not read the symbols in '%s': %s", file, err))
+	}
+
+	symbols := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		// Libraries list the symbols in each of their objects under the object's
+		// name. Undefined symbols don't have an address.
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasSuffix(line, ":") {
+			continue
+		} else if len(fields[0]) > 1 {
+			fields = fields[1:]
+		}
+
+		// Only global symbols can be used by other objects.
+		symbolType := fields[0]
+		if len(fields) > 1 && (!defined || strings.ToUpper(symbolType) == symbolType) {
+			symbols[strings.Join(fields[1:], " ")] = true
+		}
+	}
+
+	return symbols, nil
+}
+
+// usedBy returns how a target uses this library, given the headers the target
+// includes (mapped to how they were included) and the symbols it uses but
+// doesn't define. An empty string means the library isn't used.
+func (this *Target) usedBy(includedHeaders map[string]string, undefined map[string]bool) (string, error) {
+	for _, hdr := range this.hdrs() {
+		if include, ok := includedHeaders[filepath.Clean(hdr.FsPath())]; ok {
+			return fmt.Sprintf("includes \"%s\"", include), nil
+		}
+	}
+
+	if len(this.srcs()) == 0 {
+		return "", nil
+	}
+
+	defined, err := readSymbols(this.OutputPath(), true)
+	if err != nil {
+		return "", err
+	}
+
+	used := make([]string, 0)
+	for symbol := range undefined {
+		if defined[symbol] {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) == 0 {
+		return "", nil
+	}
+
+	sort.Strings(used)
+	return fmt.Sprintf("uses '%s'", used[0]), nil
+}
+
+// CheckDeps compares the headers this target includes and the symbols it links
+// against with its deps. The target (and its deps) must have been built with
+// depfiles.
+func (this *Target) CheckDeps(args *args.Args) (*DepsReport, error) {
+	report := &DepsReport{Reasons: make(map[string]string)}
+	includedHeaders := make(map[string]string)
+	undefined := make(map[string]bool)
+	for _, src := range this.srcs() {
+		obj := src.FsOutputPath() + ".o"
+		depfileFiles, err := readDepfile(depfilePath(obj))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not read the depfile for %s: %s", src, err))
+		}
+
+		includes, err := directIncludes(args, this, src, depfileFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, include := range includes {
+			for _, candidate := range include.candidates {
+				if _, ok := includedHeaders[candidate]; !ok {
+					includedHeaders[candidate] = include.name
+				}
+			}
+		}
+
+		symbols, err := readSymbols(obj, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for symbol := range symbols {
+			undefined[symbol] = true
+		}
+	}
+
+	// Nothing was compiled, so there is no way to tell what is used.
+	if len(this.srcs()) == 0 {
+		return report, nil
+	}
+
+	direct := make(map[string]bool)
+	for _, dep := range this.Spec.Dependencies(false) {
+		direct[dep.String()] = true
+	}
+
+	for _, depSpec := range linkOrder(this.Spec) {
+		dep, ok := depSpec.Target().(*Target)
+		if !ok || !dep.IsLibrary() {
+			continue
+		}
+
+		usedBy, err := dep.usedBy(includedHeaders, undefined)
+		if err != nil {
+			return nil, err
+		}
+
+		// Libraries without any files of their own just group other libraries, so
+		// are never reported as unused.
+		if direct[depSpec.String()] {
+			if usedBy == "" && (len(dep.srcs()) > 0 || len(dep.hdrs()) > 0) {
+				report.Unused = append(report.Unused, depSpec)
+			}
+		} else if usedBy != "" {
+			report.Missing = append(report.Missing, depSpec)
+			report.Reasons[depSpec.String()] = usedBy
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,244 @@
+package cc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config/interfaces"
+	"github.com/jeshuam/jbuild/config/util"
+)
+
+var (
+	// Matches an #include (or #import) line, capturing the header included.
+	includeRegex = regexp.MustCompile(`^\s*#\s*(?:include|import)\s*[<"]([^>"]+)[>"]`)
+)
+
+// depfilePath returns the path of the depfile written when compiling `obj`.
+func depfilePath(obj string) string {
+	return obj + ".d"
+}
+
+// writesDepfile returns true iff a depfile is written when compiling each
+// source of `target`. Stamped targets need them to know which objects include
+// the stamp header.
+func writesDepfile(args *args.Args, target *Target) bool {
+	return (args.LayeringCheck || args.WriteDepfiles || target.Stamp) && args.CCCompiler != "cl.exe"
+}
+
+// readDepfile returns the files listed as prerequisites in the Makefile style
+// depfile at `depfile`, i.e. the source file and every header it included.
+func readDepfile(depfile string) ([]string, error) {
+	content, err := ioutil.ReadFile(depfile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prerequisites can be split over multiple lines, and come after the target.
+	text := strings.Replace(string(content), "\r", "", -1)
+	text = strings.Replace(text, "\\\n", " ", -1)
+	if colon := strings.Index(text, ": "); colon >= 0 {
+		text = text[colon+2:]
+	}
+
+	// Spaces within a path are escaped with a backslash.
+	files := make([]string, 0)
+	current := ""
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text) && text[i+1] == ' ':
+			current += " "
+			i++
+		case text[i] == ' ' || text[i] == '\t' || text[i] == '\n':
+			if current != "" {
+				files = append(files, filepath.Clean(current))
+			}
+
+			current = ""
+		default:
+			current += string(text[i])
+		}
+	}
+
+	if current != "" {
+		files = append(files, filepath.Clean(current))
+	}
+
+	return files, nil
+}
+
+// ReadIncludes returns the headers named in the #include lines of the file at
+// `filePath`.
+func ReadIncludes(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	includes := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if match := includeRegex.FindStringSubmatch(scanner.Text()); match != nil {
+			includes = append(includes, match[1])
+		}
+	}
+
+	return includes, scanner.Err()
+}
+
+// isSourceFile returns true iff `file` is in the workspace (or an external
+// repo), rather than e.g. a system header or a generated file.
+func isSourceFile(args *args.Args, file string) bool {
+	for _, dir := range []string{args.OutputDir, args.WorkspaceDir, args.ExternalRepoDir} {
+		if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return dir != args.OutputDir
+		}
+	}
+
+	return false
+}
+
+// headerOwners returns the C++ targets loaded so far which have `header` in
+// their hdrs.
+func headerOwners(header string) []string {
+	owners := make([]string, 0)
+	for _, spec := range util.SpecCache {
+		targetSpec, ok := spec.(interfaces.TargetSpec)
+		if !ok {
+			continue
+		}
+
+		target, ok := targetSpec.Target().(*Target)
+		if !ok {
+			continue
+		}
+
+		for _, hdr := range target.hdrs() {
+			if filepath.Clean(hdr.FsPath()) == header {
+				owners = append(owners, targetSpec.String())
+				break
+			}
+		}
+	}
+
+	sort.Strings(owners)
+	return owners
+}
+
+// A resolvedInclude is an #include in one of a target's files, along with the
+// headers in the workspace it could refer to.
+type resolvedInclude struct {
+	file       interfaces.FileSpec
+	name       string
+	candidates []string
+}
+
+// directIncludes returns the #includes of headers in the workspace which are
+// written in the files belonging to `target` that were used to compile `src`
+// (i.e. `src` itself and the target's headers). `depfileFiles` are the files
+// listed in the depfile written when compiling `src`, which are used to find
+// which header each #include refers to.
+func directIncludes(args *args.Args, target *Target, src interfaces.FileSpec, depfileFiles []string) ([]resolvedInclude, error) {
+	used := make(map[string]bool)
+	for _, file := range depfileFiles {
+		used[file] = true
+	}
+
+	resolved := make([]resolvedInclude, 0)
+	for _, file := range append([]interfaces.FileSpec{src}, target.hdrs()...) {
+		if !used[filepath.Clean(file.FsPath())] {
+			continue
+		}
+
+		includes, err := ReadIncludes(file.FsPath())
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not read %s: %s", file, err))
+		}
+
+		for _, include := range includes {
+			suffix := string(filepath.Separator) + filepath.FromSlash(path.Clean(include))
+			candidates := make([]string, 0)
+			for _, depfileFile := range depfileFiles {
+				if strings.HasSuffix(depfileFile, suffix) && isSourceFile(args, depfileFile) {
+					candidates = append(candidates, depfileFile)
+				}
+			}
+
+			if len(candidates) > 0 {
+				resolved = append(resolved, resolvedInclude{file, include, candidates})
+			}
+		}
+	}
+
+	return resolved, nil
+}
+
+// checkLayering makes sure that the files belonging to `target` which were used
+// to compile `src` only include headers from the workspace which belong to
+// `target` or one of its direct deps. Headers included by those headers aren't
+// checked; they are checked when their own target is compiled.
+func checkLayering(args *args.Args, target *Target, src interfaces.FileSpec, obj string) error {
+	depfileFiles, err := readDepfile(depfilePath(obj))
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not read the depfile for %s: %s", src, err))
+	}
+
+	includes, err := directIncludes(args, target, src, depfileFiles)
+	if err != nil {
+		return err
+	}
+
+	// The headers which can be included directly.
+	allowed := make(map[string]bool)
+	for _, file := range target.files() {
+		allowed[filepath.Clean(file.FsPath())] = true
+	}
+
+	for _, depSpec := range target.Spec.Dependencies(false) {
+		if dep, ok := depSpec.Target().(*Target); ok {
+			for _, hdr := range dep.hdrs() {
+				allowed[filepath.Clean(hdr.FsPath())] = true
+			}
+		}
+	}
+
+	problems := make([]string, 0)
+	for _, include := range includes {
+		isAllowed := false
+		for _, candidate := range include.candidates {
+			isAllowed = isAllowed || allowed[candidate]
+		}
+
+		if isAllowed {
+			continue
+		}
+
+		message := fmt.Sprintf("%s includes \"%s\", which isn't in the hdrs of %s or any of its direct deps",
+			include.file, include.name, target.Spec)
+		if owners := headerOwners(include.candidates[0]); len(owners) > 0 {
+			message += fmt.Sprintf("; add %s to its deps", strings.Join(owners, " or "))
+		} else {
+			message += "; add it to the hdrs of a target"
+		}
+
+		problems = append(problems, message)
+	}
+
+	if len(problems) > 0 {
+		// Compile the file again next time, so the problems are reported again.
+		os.Remove(obj)
+		os.Remove(depfilePath(obj))
+		return errors.New(strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package cc
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+// compileInputs returns the files needed to compile `srcFile` for `target`
+// somewhere else: the source file, the headers of the target and its
+// dependencies, and any other headers in the workspace which the depfile from
+// the last compile says were included.
+func compileInputs(args *args.Args, target *Target, srcFile interfaces.FileSpec, objPath string) []string {
+	seen := make(map[string]bool)
+	inputs := make([]string, 0)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			inputs = append(inputs, path)
+		}
+	}
+
+	add(srcFile.FsPath())
+	for _, hdr := range target.hdrs() {
+		add(hdr.FsPath())
+	}
+
+	for _, hdr := range extractFileSpecs(target.Srcs, []string{".h", ".hpp"}) {
+		add(hdr.FsPath())
+	}
+
+	for _, depSpec := range target.Spec.Dependencies(true) {
+		if dep, ok := depSpec.Target().(*Target); ok {
+			for _, hdr := range dep.hdrs() {
+				add(hdr.FsPath())
+			}
+		}
+	}
+
+	if target.Stamp {
+		add(common.StampHeader(args))
+	}
+
+	add(common.RunfilesHeader(args))
+
+	// System headers are left out, as the worker has its own.
+	included, _ := readDepfile(depfilePath(objPath))
+	for _, path := range included {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(args.WorkspaceDir, path)
+		}
+
+		if strings.HasPrefix(path, args.WorkspaceDir+string(filepath.Separator)) {
+			add(path)
+		}
+	}
+
+	return inputs
+}
+
+// compileOutputs returns the files written when compiling to `objPath`.
+func compileOutputs(args *args.Args, target *Target, objPath string) []string {
+	if writesDepfile(args, target) {
+		return []string{objPath, depfilePath(objPath)}
+	}
+
+	return []string{objPath}
+}
@@ -0,0 +1,117 @@
+package cc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config/util"
+)
+
+// RunfilesDir returns the directory holding the runfiles of this target. Only
+// binaries and tests have runfiles.
+func (this *Target) RunfilesDir() string {
+	return common.RunfilesDir(this.Spec.OutputPath(), this.Spec.Name())
+}
+
+// runfiles returns the data files of this target and its dependencies, keyed by
+// their path in the workspace.
+func (this *Target) runfiles() map[string]string {
+	runfiles := make(map[string]string)
+	for _, dataSpec := range this.data() {
+		runfiles[strings.TrimPrefix(dataSpec.String(), "//")] = dataSpec.FsPath()
+	}
+
+	return runfiles
+}
+
+// runfilesManifest returns the content of the MANIFEST for `runfiles`.
+func runfilesManifest(runfiles map[string]string) []byte {
+	paths := make([]string, 0, len(runfiles))
+	for path := range runfiles {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+	var manifest bytes.Buffer
+	for _, path := range paths {
+		fmt.Fprintf(&manifest, "%s %s\n", path, runfiles[path])
+	}
+
+	return manifest.Bytes()
+}
+
+// runfilesChanged returns true iff the runfiles tree of this target doesn't
+// list the runfiles it has now.
+func (this *Target) runfilesChanged() bool {
+	if !this.IsExecutable() {
+		return false
+	}
+
+	manifest, err := ioutil.ReadFile(filepath.Join(this.RunfilesDir(), common.RunfilesManifest))
+	return err != nil || !bytes.Equal(manifest, runfilesManifest(this.runfiles()))
+}
+
+// linkRunfile makes `link` refer to the file at `target`. If symlinks can't be
+// made (e.g. on Windows without the right privileges), the file is copied, and
+// copied again whenever it changes.
+func linkRunfile(target, link string) error {
+	linkStat, err := os.Lstat(link)
+	if err == nil {
+		if linkStat.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		targetStat, err := os.Stat(target)
+		if err != nil || !targetStat.ModTime().After(linkStat.ModTime()) {
+			return err
+		}
+
+		os.Remove(link)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return err
+	}
+
+	if os.Symlink(target, link) == nil {
+		return nil
+	}
+
+	return util.CopyFile(target, link)
+}
+
+// writeRunfiles makes the runfiles tree of this target: a link to each of its
+// runfiles at its path in the workspace, and a MANIFEST listing them. The tree
+// is made again from scratch if the runfiles have changed, so that nothing
+// which was removed is left behind.
+func (this *Target) writeRunfiles() error {
+	runfiles := this.runfiles()
+	runfilesDir := this.RunfilesDir()
+	manifestPath := filepath.Join(runfilesDir, common.RunfilesManifest)
+	manifest := runfilesManifest(runfiles)
+	if existing, err := ioutil.ReadFile(manifestPath); err != nil || !bytes.Equal(existing, manifest) {
+		if err := os.RemoveAll(runfilesDir); err != nil {
+			return err
+		}
+	}
+
+	for path, target := range runfiles {
+		if err := linkRunfile(target, filepath.Join(runfilesDir, filepath.FromSlash(path))); err != nil {
+			return err
+		}
+	}
+
+	// The MANIFEST is written last, so that it is only there if the tree is
+	// complete.
+	if err := os.MkdirAll(runfilesDir, 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(manifestPath, manifest, 0644)
+}
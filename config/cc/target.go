@@ -1,446 +1,627 @@
-package cc
-
-import (
-	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
-
-	"github.com/jeshuam/jbuild/args"
-	"github.com/jeshuam/jbuild/common"
-	"github.com/jeshuam/jbuild/config/filegroup"
-	"github.com/jeshuam/jbuild/config/genrule"
-	"github.com/jeshuam/jbuild/config/interfaces"
-	"github.com/jeshuam/jbuild/progress"
-)
-
-type TargetType int
-
-const (
-	Unknown TargetType = iota
-	Binary
-	Test
-	Library
-)
-
-type Target struct {
-	Spec         interfaces.TargetSpec
-	Args         *args.Args
-	Type         TargetType
-	Srcs         []interfaces.Spec       `types:"file,filegroup,genrule"`
-	Hdrs         []interfaces.Spec       `types:"file,filegroup,genrule"`
-	Deps         []interfaces.TargetSpec `types:"c++/library,genrule"`
-	Data         []interfaces.Spec       `types:"file,filegroup"`
-	CompileFlags []string
-	LinkFlags    []string
-	Includes     []interfaces.DirSpec
-	Libs         []interfaces.Spec `types:"file,filegroup"`
-}
-
-////////////////////////////////////////////////////////////////////////////////
-//                          Interface Implementation                          //
-////////////////////////////////////////////////////////////////////////////////
-
-func (this *Target) String() string {
-	return fmt.Sprintf(
-		"C++ Target: srcs=%s, hdrs=%s, compile_flags=%s, link_flags=%s",
-		this.Srcs, this.Hdrs, this.CompileFlags, this.LinkFlags)
-}
-
-func (this *Target) GetType() string {
-	switch this.Type {
-	case Binary:
-		return "c++/binary"
-	case Test:
-		return "c++/test"
-	case Library:
-		return "c++/library"
-	default:
-		return "c++/unknown"
-	}
-}
-
-func (this *Target) Processed() bool {
-	// If we have nothing to do, then we must be processed.
-	if this.TotalOps() == 0 {
-		return true
-	}
-
-	// If the output file doesn't exist, then this target isn't processed.
-	if !common.FileExists(this.OutputPath()) {
-		return false
-	}
-
-	// If the BUILD or WORKSPACE files that this file was build in have changed,
-	// then we haven't processed.
-	outputStat, _ := os.Stat(this.OutputPath())
-	buildStat, _ := os.Stat(filepath.Join(this.Spec.Path(), this.Args.BuildFilename))
-
-	// If we couldn't find the BUILD file, maybe it was an external?
-	if buildStat == nil {
-		externalRepo, ok := this.Args.ExternalRepos["//"+this.Spec.Dir()]
-		if !ok {
-			panic("Build file not found for non-external repo??")
-		}
-
-		if externalRepo.BuildFile != "" {
-			buildStat, _ = os.Stat(externalRepo.BuildFile)
-		}
-	}
-
-	if buildStat != nil && buildStat.ModTime().After(outputStat.ModTime()) {
-		return false
-	}
-
-	workspaceStat, _ := os.Stat(filepath.Join(this.Args.WorkspaceDir, this.Args.WorkspaceFilename))
-	if workspaceStat.ModTime().After(outputStat.ModTime()) {
-		return false
-	}
-
-	// Validate each file.
-	for _, fileSpec := range this.files() {
-		fileStat, _ := os.Stat(fileSpec.FsPath())
-		if fileStat.ModTime().After(outputStat.ModTime()) {
-			return false
-		}
-	}
-
-	// We haven't been processed if our dependencies haven't been.
-	for _, depSpec := range this.Spec.Dependencies(true) {
-		if !depSpec.Target().Processed() {
-			return false
-		}
-	}
-
-	// If one of our deps has updated, we should too.
-	if this.depsUpdated() {
-		return false
-	}
-
-	// Check if any data files need to be updated.
-	for _, dataFileSpec := range this.data() {
-		dataFileStat, _ := os.Stat(dataFileSpec.FsPath())
-		outputDataFileStat, _ := os.Stat(dataFileSpec.FsOutputPath())
-		if outputDataFileStat == nil || dataFileStat.ModTime().After(outputDataFileStat.ModTime()) {
-			return false
-		}
-	}
-
-	return true
-}
-
-func (this *Target) TotalOps() int {
-	numSrcs := len(this.srcs())
-	ops := numSrcs + len(this.data())
-	if numSrcs > 0 {
-		ops += 1 // for linking
-	}
-
-	return ops
-}
-
-func (this *Target) OutputFiles() []string {
-	if len(this.srcs()) == 0 {
-		return []string{}
-	}
-
-	return []string{this.OutputPath()}
-}
-
-func (this *Target) Validate() error {
-	// TODO(jeshua): Decide what to put here once JSON validation is done.
-	return nil
-}
-
-func (this *Target) Process(args *args.Args, progressBar *progress.ProgressBar, workQueue chan common.CmdSpec) error {
-	// Make the output directory for this target.
-	err := os.MkdirAll(this.Spec.OutputPath(), 0755)
-	if err != nil {
-		return err
-	}
-
-	// Check if we should force compile.
-	outputStat, _ := os.Stat(this.OutputPath())
-	forceCompile := false
-	if outputStat != nil {
-		buildStat, _ := os.Stat(filepath.Join(this.Spec.Path(), this.Args.BuildFilename))
-		workspaceStat, _ := os.Stat(filepath.Join(this.Args.WorkspaceDir, this.Args.WorkspaceFilename))
-
-		// If we couldn't find the BUILD file, maybe it was an external?
-		if buildStat == nil {
-			externalRepo, ok := this.Args.ExternalRepos["//"+this.Spec.Dir()]
-			if !ok {
-				panic("Build file not found for non-external repo??")
-			}
-
-			if externalRepo.BuildFile != "" {
-				buildStat, _ = os.Stat(externalRepo.BuildFile)
-			}
-		}
-
-		// Check if header files are newer than the output file.
-		for _, hdrFile := range this.hdrs() {
-			hdrStat, _ := os.Stat(hdrFile.FsPath())
-			if hdrStat.ModTime().After(outputStat.ModTime()) {
-				forceCompile = true
-				break
-			}
-		}
-
-		forceCompile = forceCompile ||
-			(buildStat != nil && buildStat.ModTime().After(outputStat.ModTime())) ||
-			workspaceStat.ModTime().After(outputStat.ModTime())
-	}
-
-	// If there are no source files and this is a library, just finish.
-	if this.IsLibrary() && len(this.srcs()) == 0 {
-		progressBar.Finish()
-		return nil
-	}
-
-	// Compile all of the source files.
-	progressBar.SetOperation("compiling")
-	objFiles, nCompiled, err := compileFiles(args, this, progressBar, workQueue, forceCompile)
-	if err != nil {
-		return err
-	}
-
-	// Link all object files into a binary. What this binary is depends on the
-	// type of the target. We only have to do that if something in the target was
-	// compiled (this should avoid expensive and pointless linking steps).
-	progressBar.SetOperation("linking")
-	_, err = linkObjects(args, this, progressBar, workQueue, objFiles, nCompiled)
-	if err != nil {
-		return err
-	}
-
-	// Copy the data to the output directory.
-	progressBar.SetOperation("copying data")
-	err = copyData(this, progressBar)
-	if err != nil {
-		return err
-	}
-
-	// Save the output of this processing command.
-	progressBar.Finish()
-	return nil
-}
-
-////////////////////////////////////////////////////////////////////////////////
-//                             Utility Functions                              //
-////////////////////////////////////////////////////////////////////////////////
-
-// IsLibrary returns true iff this target refers to a library output file.
-func (this *Target) IsLibrary() bool {
-	return this.Type == Library
-}
-
-// IsBinary returns true iff this target refers to a binary output file.
-func (this *Target) IsBinary() bool {
-	return this.Type == Binary
-}
-
-// IsTest returns true iff this target refers to a test output file.
-func (this *Target) IsTest() bool {
-	return this.Type == Test
-}
-
-// IsExecutable returns true iff the output of this target is executable.
-func (this *Target) IsExecutable() bool {
-	return this.IsTest() || this.IsBinary()
-}
-
-// OutputPath returns the fully specified output path for this target.
-func (this *Target) OutputPath() string {
-	if this.IsLibrary() {
-		return filepath.Join(this.Spec.OutputPath(), LibraryName(this.Spec.Name()))
-	} else {
-		return filepath.Join(this.Spec.OutputPath(), BinaryName(this.Spec.Name()))
-	}
-}
-
-// extractFileSpecs goes through a list of generic specs and returns a list of
-// file specs. It is assumed that the specs are either filegroup targets,
-// genrules or FileSpecs. If suffixes is supplied, then make sure each file has
-// that suffix.
-func extractFileSpecs(specs []interfaces.Spec, suffixes []string) []interfaces.FileSpec {
-	fileSpecs := make([]interfaces.FileSpec, 0, len(specs))
-	for _, spec := range specs {
-		switch spec.(type) {
-		case interfaces.FileSpec:
-			fileSpecs = append(fileSpecs, spec.(interfaces.FileSpec))
-		case interfaces.TargetSpec:
-			targetSpec := spec.(interfaces.TargetSpec)
-			switch targetSpec.Target().(type) {
-			case *filegroup.Target:
-				target := targetSpec.Target().(*filegroup.Target)
-				fileSpecs = append(fileSpecs, target.AllFiles()...)
-			case *genrule.Target:
-				target := targetSpec.Target().(*genrule.Target)
-				fileSpecs = append(fileSpecs, target.Out...)
-			}
-		}
-	}
-
-	// Filter the file specs if necessary.
-	if suffixes != nil {
-		filteredFileSpecs := make([]interfaces.FileSpec, 0, len(fileSpecs))
-		for _, fileSpec := range fileSpecs {
-			for _, suffix := range suffixes {
-				if strings.HasSuffix(fileSpec.Filename(), suffix) {
-					filteredFileSpecs = append(filteredFileSpecs, fileSpec)
-				}
-			}
-		}
-
-		return filteredFileSpecs
-	}
-
-	return fileSpecs
-}
-
-// files returns a list of all files for this target (i.e. source files, data
-// files and header files).
-func (this *Target) files() []interfaces.FileSpec {
-	fileSpecs := this.srcs()
-	fileSpecs = append(fileSpecs, this.hdrs()...)
-	fileSpecs = append(fileSpecs, this.data()...)
-	fileSpecs = append(fileSpecs, this.libs()...)
-	return fileSpecs
-}
-
-// Srcs returns a list of all sources for this current target with all
-// filegroups expanded.
-func (this *Target) srcs() []interfaces.FileSpec {
-	return extractFileSpecs(this.Srcs, []string{".cc", ".cpp", ".c", ".cxx"})
-}
-
-// Hdrs returns a list of all headers for this current target with all
-// filegroups expanded.
-func (this *Target) hdrs() []interfaces.FileSpec {
-	return extractFileSpecs(this.Hdrs, []string{".h", ".hpp"})
-}
-
-// CompileFlags returns a list of all compile flags for this current target and
-// all dependent targets with all filegroups expanded.
-func (this *Target) compileFlags() []string {
-	compileFlags := this.CompileFlags
-	for _, dep := range this.Spec.Dependencies(true) {
-		switch dep.Target().(type) {
-		case *Target:
-			compileFlags = append(compileFlags, dep.Target().(*Target).CompileFlags...)
-		}
-	}
-
-	return compileFlags
-}
-
-// LinkFlags returns a list of all link flags for this current target and all
-// dependent targets with all filegroups expanded.
-func (this *Target) linkFlags() []string {
-	linkFlags := this.LinkFlags
-	for _, dep := range this.Spec.Dependencies(true) {
-		switch dep.Target().(type) {
-		case *Target:
-			linkFlags = append(linkFlags, dep.Target().(*Target).LinkFlags...)
-		}
-	}
-
-	return linkFlags
-}
-
-// Includes returns a list of all include paths for this current target and all
-// dependent targets with all filegroups expanded.
-func (this *Target) includes() []interfaces.DirSpec {
-	includes := this.Includes
-	for _, dep := range this.Spec.Dependencies(true) {
-		switch dep.Target().(type) {
-		case *Target:
-			includes = append(includes, dep.Target().(*Target).Includes...)
-		}
-	}
-
-	return includes
-}
-
-// Libs returns a list of all libs for this current target and all dependent
-// targets with all filegroups expanded.
-func (this *Target) libs() []interfaces.FileSpec {
-	libs := extractFileSpecs(this.Libs, nil)
-	for _, dep := range this.Spec.Dependencies(true) {
-		switch dep.Target().(type) {
-		case *Target:
-			libs = append(
-				libs, extractFileSpecs(dep.Target().(*Target).Libs, nil)...)
-		}
-	}
-
-	return libs
-}
-
-// Data returns a list of all data for this current target and all dependent
-// targets with all filegroups expanded.
-func (this *Target) data() []interfaces.FileSpec {
-	data := extractFileSpecs(this.Data, nil)
-	for _, dep := range this.Spec.Dependencies(true) {
-		switch dep.Target().(type) {
-		case *Target:
-			data = append(
-				data, extractFileSpecs(dep.Target().(*Target).Data, nil)...)
-		}
-	}
-
-	return data
-}
-
-// DepOutputs returns a list of output files for all dependencies recursively.
-func (this *Target) depOutputs() []string {
-	outputs := make([]string, 0)
-	for _, dep := range this.Spec.Dependencies(true) {
-		switch dep.Target().(type) {
-		case *Target:
-			if dep.Target().GetType() == "c++/library" {
-				outputs = append(outputs, dep.Target().OutputFiles()...)
-			}
-		}
-	}
-
-	return outputs
-}
-
-// DepsChangedSince returns true iff at least one of the dependencies has
-// changed. This will scan through the header files of the dependencies and
-// check whether they have changed relative to the given object.
-func (this *Target) depsChangedSince(objStat os.FileInfo) bool {
-	for _, depSpec := range this.Spec.Dependencies(true) {
-		switch depSpec.Target().(type) {
-		case *Target:
-			dep := depSpec.Target().(*Target)
-			for _, depFile := range dep.hdrs() {
-				depFileStat, _ := os.Stat(depFile.FsPath())
-				if depFileStat.ModTime().After(objStat.ModTime()) {
-					return true
-				}
-			}
-		}
-	}
-
-	return false
-}
-
-// depsUpdated returns true iff at least one of the dependencies outputs has
-// changed relative to this target's output.
-func (this *Target) depsUpdated() bool {
-	// Check all of our object files, and compare them.
-	for _, outFile := range this.OutputFiles() {
-		outFileStat, _ := os.Stat(outFile)
-		for _, depOutFile := range this.depOutputs() {
-			depOutFileStat, _ := os.Stat(depOutFile)
-			if depOutFileStat.ModTime().After(outFileStat.ModTime()) {
-				return true
-			}
-		}
-	}
-
-	return false
-}
+package cc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config/filegroup"
+	"github.com/jeshuam/jbuild/config/genrule"
+	"github.com/jeshuam/jbuild/config/interfaces"
+	"github.com/jeshuam/jbuild/progress"
+)
+
+type TargetType int
+
+const (
+	Unknown TargetType = iota
+	Binary
+	Test
+	Library
+)
+
+type Target struct {
+	Spec         interfaces.TargetSpec
+	Args         *args.Args
+	Type         TargetType
+	Srcs         []interfaces.Spec       `types:"file,filegroup,genrule"`
+	Hdrs         []interfaces.Spec       `types:"file,filegroup,genrule"`
+	Deps         []interfaces.TargetSpec `types:"c++/library,genrule"`
+	Data         []interfaces.Spec       `types:"file,filegroup"`
+	CompileFlags []string
+	LinkFlags    []string
+	Includes     []interfaces.DirSpec
+	Libs         []interfaces.Spec `types:"file,filegroup"`
+
+	// If set, the workspace status can be used by including "jbuild/stamp.h".
+	Stamp bool
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                          Interface Implementation                          //
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *Target) String() string {
+	return fmt.Sprintf(
+		"C++ Target: srcs=%s, hdrs=%s, compile_flags=%s, link_flags=%s",
+		this.Srcs, this.Hdrs, this.CompileFlags, this.LinkFlags)
+}
+
+func (this *Target) GetType() string {
+	switch this.Type {
+	case Binary:
+		return "c++/binary"
+	case Test:
+		return "c++/test"
+	case Library:
+		return "c++/library"
+	default:
+		return "c++/unknown"
+	}
+}
+
+func (this *Target) Processed() bool {
+	// If we have nothing to do, then we must be processed.
+	if this.TotalOps() == 0 {
+		return true
+	}
+
+	// If the output file doesn't exist, then this target isn't processed.
+	if !common.FileExists(this.OutputPath()) {
+		return false
+	}
+
+	// If the BUILD or WORKSPACE files that this file was build in have changed,
+	// then we haven't processed.
+	outputStat, _ := os.Stat(this.OutputPath())
+	buildStat := this.buildFileStat()
+	if buildStat != nil && buildStat.ModTime().After(outputStat.ModTime()) {
+		return false
+	}
+
+	workspaceStat, _ := os.Stat(filepath.Join(this.Args.WorkspaceDir, this.Args.WorkspaceFilename))
+	if workspaceStat.ModTime().After(outputStat.ModTime()) {
+		return false
+	}
+
+	// Validate each file.
+	for _, fileSpec := range this.files() {
+		fileStat, _ := os.Stat(fileSpec.FsPath())
+		if fileStat.ModTime().After(outputStat.ModTime()) {
+			return false
+		}
+	}
+
+	// We haven't been processed if our dependencies haven't been.
+	for _, depSpec := range this.Spec.Dependencies(true) {
+		if !depSpec.Target().Processed() {
+			return false
+		}
+	}
+
+	// If files were added or removed where our globs (or the globs of our
+	// filegroups) look, they might match different files now.
+	if this.globsChanged(outputStat) {
+		return false
+	}
+
+	// If one of our deps has updated, we should too.
+	if this.depsUpdated() {
+		return false
+	}
+
+	// Check if any data files need to be updated.
+	for _, dataFileSpec := range this.data() {
+		dataFileStat, _ := os.Stat(dataFileSpec.FsPath())
+		outputDataFileStat, _ := os.Stat(dataFileSpec.FsOutputPath())
+		if outputDataFileStat == nil || dataFileStat.ModTime().After(outputDataFileStat.ModTime()) {
+			return false
+		}
+	}
+
+	// Binaries and tests need their runfiles tree made again if their runfiles
+	// have changed.
+	if this.runfilesChanged() {
+		return false
+	}
+
+	// Objects which include the stamp header need to be rebuilt when the
+	// workspace status changes.
+	if this.objectsStampChanged() {
+		return false
+	}
+
+	// The outputs need to be rebuilt if the commands used to build them (or the
+	// environment they are run in) have changed.
+	if this.commandsChanged() {
+		return false
+	}
+
+	return true
+}
+
+// commandsChanged returns true iff the commands which compile and link this
+// target aren't the ones its outputs were last built with.
+func (this *Target) commandsChanged() bool {
+	objs := make([]string, 0, len(this.srcs()))
+	for _, srcFile := range this.srcs() {
+		objPath := srcFile.FsOutputPath() + ".o"
+		objs = append(objs, objPath)
+		if common.ActionKeyChanged(this.Args, objPath, compileCommand(this.Args, this, srcFile.FsPath(), objPath)) {
+			return true
+		}
+	}
+
+	return common.ActionKeyChanged(this.Args, this.OutputPath(), linkCommand(this.Args, this, objs, this.OutputPath()))
+}
+
+func (this *Target) TotalOps() int {
+	numSrcs := len(this.srcs())
+	ops := numSrcs + len(this.data())
+	if numSrcs > 0 {
+		ops += 1 // for linking
+	}
+
+	return ops
+}
+
+func (this *Target) OutputFiles() []string {
+	if len(this.srcs()) == 0 {
+		return []string{}
+	}
+
+	return []string{this.OutputPath()}
+}
+
+func (this *Target) Validate() error {
+	// TODO(jeshua): Decide what to put here once JSON validation is done.
+	return nil
+}
+
+func (this *Target) Process(args *args.Args, progressBar *progress.ProgressBar, workQueue chan common.CmdSpec) error {
+	// Make the output directory for this target.
+	err := os.MkdirAll(this.Spec.OutputPath(), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Check if we should force compile.
+	outputStat, _ := os.Stat(this.OutputPath())
+	forceCompile := false
+	if outputStat != nil {
+		buildStat := this.buildFileStat()
+		workspaceStat, _ := os.Stat(filepath.Join(this.Args.WorkspaceDir, this.Args.WorkspaceFilename))
+
+		// Check if header files are newer than the output file.
+		for _, hdrFile := range this.hdrs() {
+			hdrStat, _ := os.Stat(hdrFile.FsPath())
+			if hdrStat.ModTime().After(outputStat.ModTime()) {
+				forceCompile = true
+				break
+			}
+		}
+
+		forceCompile = forceCompile ||
+			(buildStat != nil && buildStat.ModTime().After(outputStat.ModTime())) ||
+			workspaceStat.ModTime().After(outputStat.ModTime())
+	}
+
+	// If there are no source files and this is a library, just finish.
+	if this.IsLibrary() && len(this.srcs()) == 0 {
+		progressBar.Finish()
+		return nil
+	}
+
+	// Compile all of the source files.
+	progressBar.SetOperation("compiling")
+	objFiles, nCompiled, err := compileFiles(args, this, progressBar, workQueue, forceCompile)
+	if err != nil {
+		return err
+	}
+
+	// Link all object files into a binary. What this binary is depends on the
+	// type of the target. We only have to do that if something in the target was
+	// compiled (this should avoid expensive and pointless linking steps).
+	progressBar.SetOperation("linking")
+	_, err = linkObjects(args, this, progressBar, workQueue, objFiles, nCompiled)
+	if err != nil {
+		return err
+	}
+
+	// Copy the data to the output directory.
+	progressBar.SetOperation("copying data")
+	err = copyData(this, progressBar)
+	if err != nil {
+		return err
+	}
+
+	// Binaries and tests each have their own runfiles tree, so that they can
+	// find their data wherever they are run from.
+	if this.IsExecutable() {
+		err = this.writeRunfiles()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Save the output of this processing command.
+	progressBar.Finish()
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                             Utility Functions                              //
+////////////////////////////////////////////////////////////////////////////////
+
+// IsLibrary returns true iff this target refers to a library output file.
+func (this *Target) IsLibrary() bool {
+	return this.Type == Library
+}
+
+// IsBinary returns true iff this target refers to a binary output file.
+func (this *Target) IsBinary() bool {
+	return this.Type == Binary
+}
+
+// IsTest returns true iff this target refers to a test output file.
+func (this *Target) IsTest() bool {
+	return this.Type == Test
+}
+
+// IsExecutable returns true iff the output of this target is executable.
+func (this *Target) IsExecutable() bool {
+	return this.IsTest() || this.IsBinary()
+}
+
+// OutputPath returns the fully specified output path for this target.
+func (this *Target) OutputPath() string {
+	if this.IsLibrary() {
+		return filepath.Join(this.Spec.OutputPath(), LibraryName(this.Spec.Name()))
+	} else {
+		return filepath.Join(this.Spec.OutputPath(), BinaryName(this.Spec.Name()))
+	}
+}
+
+// buildFileStat returns the stat of the BUILD file this target was defined in.
+// For external repos this may be nil, as frozen repos can't change without
+// being re-fetched.
+func (this *Target) buildFileStat() os.FileInfo {
+	externalRepo, ok := this.Args.ExternalRepos["//"+this.Spec.Dir()]
+	if ok {
+		buildFilePath := externalRepo.BuildFilePath(this.Args)
+		if buildFilePath == "" {
+			return nil
+		}
+
+		buildStat, _ := os.Stat(buildFilePath)
+		return buildStat
+	}
+
+	buildStat, _ := os.Stat(filepath.Join(this.Spec.Path(), this.Args.BuildFilename))
+	if buildStat == nil {
+		panic("Build file not found for non-external repo??")
+	}
+
+	return buildStat
+}
+
+// extractFileSpecs goes through a list of generic specs and returns a list of
+// file specs. It is assumed that the specs are either filegroup targets,
+// genrules or FileSpecs. If suffixes is supplied, then make sure each file has
+// that suffix.
+func extractFileSpecs(specs []interfaces.Spec, suffixes []string) []interfaces.FileSpec {
+	fileSpecs := make([]interfaces.FileSpec, 0, len(specs))
+	for _, spec := range specs {
+		switch spec.(type) {
+		case interfaces.FileSpec:
+			fileSpecs = append(fileSpecs, spec.(interfaces.FileSpec))
+		case interfaces.TargetSpec:
+			targetSpec := spec.(interfaces.TargetSpec)
+			switch targetSpec.Target().(type) {
+			case *filegroup.Target:
+				target := targetSpec.Target().(*filegroup.Target)
+				fileSpecs = append(fileSpecs, target.AllFiles()...)
+			case *genrule.Target:
+				target := targetSpec.Target().(*genrule.Target)
+				fileSpecs = append(fileSpecs, target.Out...)
+			}
+		}
+	}
+
+	// Filter the file specs if necessary.
+	if suffixes != nil {
+		filteredFileSpecs := make([]interfaces.FileSpec, 0, len(fileSpecs))
+		for _, fileSpec := range fileSpecs {
+			for _, suffix := range suffixes {
+				if strings.HasSuffix(fileSpec.Filename(), suffix) {
+					filteredFileSpecs = append(filteredFileSpecs, fileSpec)
+				}
+			}
+		}
+
+		return filteredFileSpecs
+	}
+
+	return fileSpecs
+}
+
+// files returns a list of all files for this target (i.e. source files, data
+// files and header files).
+func (this *Target) files() []interfaces.FileSpec {
+	fileSpecs := this.srcs()
+	fileSpecs = append(fileSpecs, this.hdrs()...)
+	fileSpecs = append(fileSpecs, this.data()...)
+	fileSpecs = append(fileSpecs, this.libs()...)
+	return fileSpecs
+}
+
+// Srcs returns a list of all sources for this current target with all
+// filegroups expanded.
+func (this *Target) srcs() []interfaces.FileSpec {
+	return extractFileSpecs(this.Srcs, []string{".cc", ".cpp", ".c", ".cxx"})
+}
+
+// Hdrs returns a list of all headers for this current target with all
+// filegroups expanded.
+func (this *Target) hdrs() []interfaces.FileSpec {
+	return extractFileSpecs(this.Hdrs, []string{".h", ".hpp"})
+}
+
+// SrcFiles returns the sources of this target with all filegroups expanded.
+func (this *Target) SrcFiles() []interfaces.FileSpec {
+	return this.srcs()
+}
+
+// HdrFiles returns the headers of this target with all filegroups expanded.
+func (this *Target) HdrFiles() []interfaces.FileSpec {
+	return this.hdrs()
+}
+
+// CompileFlags returns a list of all compile flags for this current target and
+// all dependent targets with all filegroups expanded.
+func (this *Target) compileFlags() []string {
+	compileFlags := this.CompileFlags
+	for _, dep := range this.Spec.Dependencies(true) {
+		switch dep.Target().(type) {
+		case *Target:
+			compileFlags = append(compileFlags, dep.Target().(*Target).CompileFlags...)
+		}
+	}
+
+	return compileFlags
+}
+
+// LinkFlags returns a list of all link flags for this current target and all
+// dependent targets with all filegroups expanded.
+func (this *Target) linkFlags() []string {
+	linkFlags := this.LinkFlags
+	for _, dep := range this.Spec.Dependencies(true) {
+		switch dep.Target().(type) {
+		case *Target:
+			linkFlags = append(linkFlags, dep.Target().(*Target).LinkFlags...)
+		}
+	}
+
+	return linkFlags
+}
+
+// Includes returns a list of all include paths for this current target and all
+// dependent targets with all filegroups expanded.
+func (this *Target) includes() []interfaces.DirSpec {
+	includes := this.Includes
+	for _, dep := range this.Spec.Dependencies(true) {
+		switch dep.Target().(type) {
+		case *Target:
+			includes = append(includes, dep.Target().(*Target).Includes...)
+		}
+	}
+
+	return includes
+}
+
+// Libs returns a list of all libs for this current target and all dependent
+// targets with all filegroups expanded.
+func (this *Target) libs() []interfaces.FileSpec {
+	libs := extractFileSpecs(this.Libs, nil)
+	for _, dep := range this.Spec.Dependencies(true) {
+		switch dep.Target().(type) {
+		case *Target:
+			libs = append(
+				libs, extractFileSpecs(dep.Target().(*Target).Libs, nil)...)
+		}
+	}
+
+	return libs
+}
+
+// Data returns a list of all data for this current target and all dependent
+// targets with all filegroups expanded.
+func (this *Target) data() []interfaces.FileSpec {
+	data := extractFileSpecs(this.Data, nil)
+	for _, dep := range this.Spec.Dependencies(true) {
+		switch dep.Target().(type) {
+		case *Target:
+			data = append(
+				data, extractFileSpecs(dep.Target().(*Target).Data, nil)...)
+		}
+	}
+
+	return data
+}
+
+// linkOrder returns every dependency of `spec` (directly or not), ordered so
+// that each one comes before the dependencies it has. Static libraries have to
+// be linked in this order, as the linker only looks for symbols in the
+// libraries which come after the object using them.
+func linkOrder(spec interfaces.TargetSpec) []interfaces.TargetSpec {
+	visited := make(map[string]bool)
+	order := make([]interfaces.TargetSpec, 0)
+	var visit func(spec interfaces.TargetSpec)
+	visit = func(spec interfaces.TargetSpec) {
+		deps := spec.Dependencies(false)
+		sort.Slice(deps, func(i, j int) bool {
+			return deps[i].String() < deps[j].String()
+		})
+
+		for _, dep := range deps {
+			if !visited[dep.String()] {
+				visited[dep.String()] = true
+				visit(dep)
+				order = append(order, dep)
+			}
+		}
+	}
+
+	visit(spec)
+
+	// Each dependency was added after its own dependencies, so reverse the order.
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+
+	return order
+}
+
+// DepOutputs returns a list of output files for all dependencies recursively.
+func (this *Target) depOutputs() []string {
+	outputs := make([]string, 0)
+	for _, dep := range linkOrder(this.Spec) {
+		switch dep.Target().(type) {
+		case *Target:
+			if dep.Target().GetType() == "c++/library" {
+				outputs = append(outputs, dep.Target().OutputFiles()...)
+			}
+		}
+	}
+
+	return outputs
+}
+
+// stampChangedSince returns true iff this target is stamped and the stamp
+// header has changed since `stat`.
+func (this *Target) stampChangedSince(stat os.FileInfo) bool {
+	if !this.Stamp {
+		return false
+	}
+
+	stampStat, _ := os.Stat(common.StampHeader(this.Args))
+	return stampStat == nil || stampStat.ModTime().After(stat.ModTime())
+}
+
+// includesStamp returns true iff the object at `objPath`, compiled from
+// `srcPath`, includes the stamp header. This is read from the depfile of the
+// object. Without one (e.g. with cl.exe), the source and the headers of this
+// target are scanned for the #include instead.
+func (this *Target) includesStamp(srcPath, objPath string) bool {
+	stampHeader := common.StampHeader(this.Args)
+	if included, err := readDepfile(depfilePath(objPath)); err == nil {
+		for _, path := range included {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(this.Args.WorkspaceDir, path)
+			}
+
+			if path == stampHeader {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	files := []string{srcPath}
+	for _, hdr := range this.hdrs() {
+		files = append(files, hdr.FsPath())
+	}
+
+	for _, file := range files {
+		includes, _ := ReadIncludes(file)
+		for _, include := range includes {
+			if include == "jbuild/stamp.h" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// stampChangedFor returns true iff the object at `objPath`, compiled from
+// `srcPath`, includes the stamp header and it has changed since `objStat`.
+func (this *Target) stampChangedFor(srcPath, objPath string, objStat os.FileInfo) bool {
+	return this.stampChangedSince(objStat) && this.includesStamp(srcPath, objPath)
+}
+
+// objectsStampChanged returns true iff any of the objects of this target
+// include the stamp header and were compiled before it last changed.
+func (this *Target) objectsStampChanged() bool {
+	if !this.Stamp {
+		return false
+	}
+
+	for _, srcFile := range this.srcs() {
+		objPath := srcFile.FsOutputPath() + ".o"
+		objStat, _ := os.Stat(objPath)
+		if objStat == nil || this.stampChangedFor(srcFile.FsPath(), objPath, objStat) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DepsChangedSince returns true iff at least one of the dependencies has
+// changed. This will scan through the header files of the dependencies and
+// check whether they have changed relative to the given object.
+func (this *Target) depsChangedSince(objStat os.FileInfo) bool {
+	for _, depSpec := range this.Spec.Dependencies(true) {
+		switch depSpec.Target().(type) {
+		case *Target:
+			dep := depSpec.Target().(*Target)
+			for _, depFile := range dep.hdrs() {
+				depFileStat, _ := os.Stat(depFile.FsPath())
+				if depFileStat.ModTime().After(objStat.ModTime()) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// globsChanged returns true iff the files matched by the globs of this target
+// or any of its dependencies may have changed since `outputStat`.
+func (this *Target) globsChanged(outputStat os.FileInfo) bool {
+	if this.Spec.GlobsChangedSince(outputStat) {
+		return true
+	}
+
+	for _, depSpec := range this.Spec.Dependencies(true) {
+		if depSpec.GlobsChangedSince(outputStat) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// depsUpdated returns true iff at least one of the dependencies outputs has
+// changed relative to this target's output.
+func (this *Target) depsUpdated() bool {
+	// Check all of our object files, and compare them.
+	for _, outFile := range this.OutputFiles() {
+		outFileStat, _ := os.Stat(outFile)
+		for _, depOutFile := range this.depOutputs() {
+			depOutFileStat, _ := os.Stat(depOutFile)
+			if depOutFileStat.ModTime().After(outFileStat.ModTime()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -72,7 +72,8 @@ func (this *Target) Process(args *args.Args, progressBar *progress.ProgressBar,
 
 	// Run doxygen.
 	results := make(chan error)
-	taskQueue <- common.CmdSpec{cmd, nil, results, nil}
+	taskQueue <- common.CmdSpec{cmd, nil, results, nil,
+		common.Action{Kind: common.DoxygenAction, File: this.DoxyfilePath()}}
 
 	// Wait for it to finish.
 	err = <-results
@@ -20,6 +20,10 @@ import (
 	"github.com/op/go-logging"
 )
 
+// Genrule commands containing this are given the path of the file listing the
+// workspace status.
+const StampFileVariable = "$(STAMP_FILE)"
+
 type Target struct {
 	Type string
 	Spec interfaces.TargetSpec   // The spec of this target.
@@ -65,7 +69,7 @@ func (this *Target) Processed() bool {
 			return false
 		}
 
-		if newestInputFile.ModTime().After(outFileStat.ModTime()) {
+		if newestInputFile != nil && newestInputFile.ModTime().After(outFileStat.ModTime()) {
 			return false
 		}
 
@@ -76,11 +80,33 @@ func (this *Target) Processed() bool {
 		if workspaceStat != nil && workspaceStat.ModTime().After(outFileStat.ModTime()) {
 			return false
 		}
+
+		if this.Spec.GlobsChangedSince(outFileStat) {
+			return false
+		}
+
+		if this.UsesStamp() {
+			stampStat, _ := os.Stat(common.StampFile(this.Args))
+			if stampStat == nil || stampStat.ModTime().After(outFileStat.ModTime()) {
+				return false
+			}
+		}
 	}
 
 	return true
 }
 
+// UsesStamp returns true iff one of the commands uses the workspace status.
+func (this *Target) UsesStamp() bool {
+	for _, cmd := range this.Cmds {
+		if strings.Contains(cmd, StampFileVariable) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (this *Target) TotalOps() int {
 	return len(this.Cmds)
 }
@@ -143,6 +169,11 @@ func (this *Target) Process(args *args.Args, progress *progress.ProgressBar, wor
 			"${BIN_DIR}",
 			strings.Replace(args.OutputDir, "\\", "/", -1),
 			-1)
+		cmdString = strings.Replace(
+			cmdString,
+			StampFileVariable,
+			strings.Replace(common.StampFile(args), "\\", "/", -1),
+			-1)
 
 		// First, see if we are redirecting.
 		cmdParts := strings.Split(cmdString, ">")
@@ -168,7 +199,8 @@ func (this *Target) Process(args *args.Args, progress *progress.ProgressBar, wor
 
 		// Run the command.
 		log.Debugf("... run %s", cmd.Args)
-		workQueue <- common.CmdSpec{cmd, nil, result, complete}
+		workQueue <- common.CmdSpec{cmd, nil, result, complete,
+			common.Action{Target: this.Spec.String(), Kind: common.GenruleAction}}
 
 		// Wait for the result.
 		err = <-result
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+)
+
+// A globPattern is a parsed "glob:" spec. Options follow the pattern, separated
+// by semicolons, e.g. "glob:**/*.cc;exclude=**/*_test.cc;exclude_directories=0".
+type globPattern struct {
+	// The pattern files must match. Patterns are relative to the package doing
+	// the globbing, unless they start with //.
+	include string
+
+	// Patterns for files which shouldn't be matched, even if they match include.
+	exclude []string
+
+	// If set (the default), only files are matched, not directories.
+	excludeDirectories bool
+}
+
+// parseGlob parses a "glob:" spec, without the "glob:" prefix.
+func parseGlob(rawGlob string) (*globPattern, error) {
+	parts := strings.Split(rawGlob, ";")
+	glob := &globPattern{include: parts[0], excludeDirectories: true}
+	if glob.include == "" {
+		return nil, errors.New(fmt.Sprintf("Glob '%s' has no pattern", rawGlob))
+	}
+
+	for _, option := range parts[1:] {
+		keyValue := strings.SplitN(option, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, errors.New(fmt.Sprintf(
+				"Glob option '%s' in '%s' should be key=value", option, rawGlob))
+		}
+
+		switch keyValue[0] {
+		case "exclude":
+			glob.exclude = append(glob.exclude, keyValue[1])
+		case "exclude_directories":
+			excludeDirectories, err := strconv.ParseBool(keyValue[1])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf(
+					"Glob option 'exclude_directories' in '%s' should be 0 or 1", rawGlob))
+			}
+
+			glob.excludeDirectories = excludeDirectories
+		default:
+			return nil, errors.New(fmt.Sprintf(
+				"Unknown glob option '%s' in '%s'", keyValue[0], rawGlob))
+		}
+	}
+
+	return glob, nil
+}
+
+// resolveGlobPattern returns `pattern` relative to the root of the workspace
+// (using "/" as the separator), given the package doing the globbing is `cwd`.
+func resolveGlobPattern(pattern, cwd string) string {
+	if strings.HasPrefix(pattern, "//") {
+		return path.Clean(strings.Trim(pattern, "/"))
+	}
+
+	return path.Join(filepath.ToSlash(cwd), pattern)
+}
+
+// matchGlobSegments returns true iff the path `name` matches `pattern`, both
+// split into their path segments. A "**" segment matches any number of
+// directories.
+func matchGlobSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchGlobSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	matched, _ := path.Match(pattern[0], name[0])
+	return matched && matchGlobSegments(pattern[1:], name[1:])
+}
+
+// A globber finds the paths which match a glob. It never crosses into another
+// package, i.e. a directory below the package doing the globbing which has its
+// own BUILD file.
+type globber struct {
+	args      *argsModule.Args
+	buildBase string
+	glob      *globPattern
+
+	// The directory (relative to buildBase) which other packages must be below.
+	packageDir string
+
+	// The paths matched (relative to buildBase), and the directories searched to
+	// find them.
+	matches []string
+	dirs    []string
+}
+
+// isOtherPackage returns true iff `dir` is a package other than the one doing
+// the globbing (or is jbuild's output directory).
+func (this *globber) isOtherPackage(dir string) bool {
+	fsDir := filepath.Join(this.buildBase, filepath.FromSlash(dir))
+	if fsDir == this.args.OutputDir {
+		return true
+	}
+
+	if dir == this.packageDir || strings.HasPrefix(dir, "../") ||
+		(this.packageDir != "." && !strings.HasPrefix(dir, this.packageDir+"/")) {
+		return false
+	}
+
+	return common.FileExists(filepath.Join(fsDir, this.args.BuildFilename))
+}
+
+// match adds the paths within `dir` which match `segments` to the matches.
+func (this *globber) match(dir string, segments []string) {
+	fsDir := filepath.Join(this.buildBase, filepath.FromSlash(dir))
+	entries, err := ioutil.ReadDir(fsDir)
+	if err != nil {
+		return
+	}
+
+	this.dirs = append(this.dirs, fsDir)
+	segment, rest := segments[0], segments[1:]
+	if segment == "**" {
+		this.match(dir, rest)
+	}
+
+	for _, entry := range entries {
+		entryPath := path.Join(dir, entry.Name())
+		isDir := common.IsDir(filepath.Join(fsDir, entry.Name()))
+		if isDir && this.isOtherPackage(entryPath) {
+			continue
+		}
+
+		if segment == "**" {
+			if isDir {
+				this.match(entryPath, segments)
+			}
+
+			continue
+		}
+
+		if matched, _ := path.Match(segment, entry.Name()); !matched {
+			continue
+		}
+
+		if len(rest) > 0 {
+			if isDir {
+				this.match(entryPath, rest)
+			}
+		} else if !isDir || !this.glob.excludeDirectories {
+			this.matches = append(this.matches, entryPath)
+		}
+	}
+}
+
+// expand returns the paths (relative to `buildBase`, using "/" as the
+// separator) which match the glob in sorted order, along with the directories
+// which were searched to find them. `cwd` is the package doing the globbing.
+func (this *globPattern) expand(args *argsModule.Args, cwd, buildBase string) ([]string, []string) {
+	include := resolveGlobPattern(this.include, cwd)
+	segments := strings.Split(include, "/")
+	if segments[len(segments)-1] == "**" {
+		segments = append(segments, "*")
+	}
+
+	// Start searching from the last directory which doesn't need to be matched.
+	start := 0
+	for start < len(segments)-1 && !strings.ContainsAny(segments[start], "*?[") {
+		start++
+	}
+
+	searchDir := path.Join(segments[:start]...)
+	if searchDir == "" {
+		searchDir = "."
+	}
+
+	// Patterns starting with // can search another package, but not the packages
+	// below that.
+	globber := &globber{args: args, buildBase: buildBase, glob: this}
+	globber.packageDir = path.Clean(filepath.ToSlash(cwd))
+	if strings.HasPrefix(this.include, "//") {
+		globber.packageDir = searchDir
+	}
+
+	for dir := searchDir; dir != globber.packageDir && dir != "." && dir != ".."; dir = path.Dir(dir) {
+		if globber.isOtherPackage(dir) {
+			return []string{}, []string{}
+		}
+	}
+
+	globber.match(searchDir, segments[start:])
+
+	// Remove anything excluded (and any duplicates).
+	excludes := make([][]string, 0, len(this.exclude))
+	for _, exclude := range this.exclude {
+		excludes = append(excludes, strings.Split(resolveGlobPattern(exclude, cwd), "/"))
+	}
+
+	sort.Strings(globber.matches)
+	matches := make([]string, 0, len(globber.matches))
+	for i, match := range globber.matches {
+		if i > 0 && match == globber.matches[i-1] {
+			continue
+		}
+
+		excluded := false
+		for _, exclude := range excludes {
+			excluded = excluded || matchGlobSegments(exclude, strings.Split(match, "/"))
+		}
+
+		if !excluded {
+			matches = append(matches, match)
+		}
+	}
+
+	return matches, globber.dirs
+}
+
+// dirsChangedSince returns true iff anything may have been added to or removed
+// from any of `dirs` since `since`.
+func dirsChangedSince(dirs map[string]bool, since time.Time) bool {
+	for dir := range dirs {
+		dirStat, _ := os.Stat(dir)
+		if dirStat == nil || dirStat.ModTime().After(since) {
+			return true
+		}
+	}
+
+	return false
+}
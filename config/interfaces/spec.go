@@ -1,5 +1,9 @@
 package interfaces
 
+import (
+	"os"
+)
+
 type Spec interface {
 	// Dir should return the directory that this Spec references, relative to the
 	// root of the workspace.
@@ -68,4 +72,9 @@ type TargetSpec interface {
 	Dependencies(all bool) []TargetSpec
 
 	ReadyToProcess() bool
+
+	// GlobsChangedSince should return true iff files may have been added to or
+	// removed from the directories searched by this target's globs since the
+	// file described by `outputStat` was last modified.
+	GlobsChangedSince(outputStat os.FileInfo) bool
 }
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+// A LintProblem is something in a BUILD file which is probably a mistake, even
+// though it builds.
+type LintProblem struct {
+	Position argsModule.Position
+	Message  string
+}
+
+func (this LintProblem) String() string {
+	return fmt.Sprintf("%s: %s", this.Position, this.Message)
+}
+
+// specsInField returns the specs held by the field `fieldName` of `target`, or
+// nil if it doesn't have that field.
+func specsInField(target *TargetSpecImpl, fieldName string) []interfaces.Spec {
+	_, targetValue, err := getReflectTypeAndValueForTarget(target.Target())
+	if err != nil {
+		return nil
+	}
+
+	fieldValue := targetValue.Elem().FieldByName(fieldName)
+	if !fieldValue.IsValid() {
+		return nil
+	}
+
+	specs := make([]interfaces.Spec, 0)
+	switch fieldValue.Type() {
+	case reflect.TypeOf([]interfaces.Spec{}):
+		specs = append(specs, fieldValue.Interface().([]interfaces.Spec)...)
+	case reflect.TypeOf([]interfaces.TargetSpec{}):
+		for _, spec := range fieldValue.Interface().([]interfaces.TargetSpec) {
+			specs = append(specs, spec)
+		}
+	}
+
+	return specs
+}
+
+// lintUnusedFilegroups finds filegroups which none of `targets` use.
+func lintUnusedFilegroups(targets []*TargetSpecImpl) []LintProblem {
+	used := make(map[string]bool)
+	for _, target := range targets {
+		for _, dep := range target.Dependencies(false) {
+			used[dep.String()] = true
+		}
+	}
+
+	problems := make([]LintProblem, 0)
+	for _, target := range targets {
+		if target.Type() == "filegroup" && !used[target.String()] {
+			problems = append(problems, LintProblem{target.position(),
+				fmt.Sprintf("filegroup %s isn't used by any target", target)})
+		}
+	}
+
+	return problems
+}
+
+// lintDuplicateSources finds source files which are compiled by more than one
+// of `targets`.
+func lintDuplicateSources(targets []*TargetSpecImpl) []LintProblem {
+	problems := make([]LintProblem, 0)
+	owners := make(map[string]*TargetSpecImpl)
+	for _, target := range targets {
+		for _, src := range specsInField(target, "Srcs") {
+			fileSpec, ok := src.(interfaces.FileSpec)
+			if !ok || fileSpec.IsGenerated() {
+				continue
+			}
+
+			owner, ok := owners[fileSpec.String()]
+			if !ok {
+				owners[fileSpec.String()] = target
+			} else if owner != target {
+				problems = append(problems, LintProblem{target.position("srcs"),
+					fmt.Sprintf("%s is in the srcs of both %s and %s", fileSpec, owner, target)})
+			}
+		}
+	}
+
+	return problems
+}
+
+// lintRedundantDeps finds deps which are also a dependency of another dep of
+// the same target, so needn't be listed.
+func lintRedundantDeps(targets []*TargetSpecImpl) []LintProblem {
+	problems := make([]LintProblem, 0)
+	for _, target := range targets {
+		deps := specsInField(target, "Deps")
+		for _, dep := range deps {
+			for _, other := range deps {
+				if other.String() == dep.String() {
+					continue
+				}
+
+				found := false
+				for _, transitiveDep := range other.(interfaces.TargetSpec).Dependencies(true) {
+					found = found || transitiveDep.String() == dep.String()
+				}
+
+				if found {
+					problems = append(problems, LintProblem{target.position("deps"),
+						fmt.Sprintf("dep %s of %s is redundant; it is already a dependency of %s",
+							dep, target, other)})
+					break
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// lintEmptyGlobs finds glob patterns in `json` (the JSON for `target`, or its
+// platform specific options at `keys`) which don't match any files.
+func lintEmptyGlobs(args *argsModule.Args, target *TargetSpecImpl, json map[string]interface{}, keys []string) []LintProblem {
+	jsonKeys := make([]string, 0, len(json))
+	for key := range json {
+		jsonKeys = append(jsonKeys, key)
+	}
+
+	sort.Strings(jsonKeys)
+	problems := make([]LintProblem, 0)
+	for _, key := range jsonKeys {
+		fieldKeys := append(append([]string{}, keys...), key)
+		switch value := json[key].(type) {
+		case []interface{}:
+			for _, item := range value {
+				rawSpec, ok := item.(string)
+				if !ok || !strings.HasPrefix(rawSpec, "glob:") {
+					continue
+				}
+
+				glob := strings.TrimPrefix(rawSpec, "glob:")
+				if specs, err := MakeFileSpecGlob(args, glob, target.Dir(), target.buildBase); err == nil && len(specs) == 0 {
+					problems = append(problems, LintProblem{target.position(fieldKeys...),
+						fmt.Sprintf("glob '%s' in field '%s' of %s doesn't match any files",
+							glob, key, target)})
+				}
+			}
+
+		case map[string]interface{}:
+			if platformKeys[key] && len(keys) == 0 {
+				problems = append(problems, lintEmptyGlobs(args, target, value, fieldKeys)...)
+			}
+		}
+	}
+
+	return problems
+}
+
+// Lint checks `specs` for things which are probably mistakes: filegroups which
+// aren't used by any of them, source files compiled by more than one target,
+// deps which are already a dependency of another dep and globs which don't
+// match any files. The problems found are sorted by where they are.
+func Lint(args *argsModule.Args, specs []interfaces.TargetSpec) []LintProblem {
+	targets := make([]*TargetSpecImpl, 0, len(specs))
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		if !seen[spec.String()] {
+			seen[spec.String()] = true
+			targets = append(targets, spec.(*TargetSpecImpl))
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].String() < targets[j].String()
+	})
+
+	problems := lintUnusedFilegroups(targets)
+	problems = append(problems, lintDuplicateSources(targets)...)
+	problems = append(problems, lintRedundantDeps(targets)...)
+	for _, target := range targets {
+		problems = append(problems, lintEmptyGlobs(args, target, target.json, nil)...)
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i].Position, problems[j].Position
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		} else if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+
+		return a.Col < b.Col
+	})
+
+	return problems
+}
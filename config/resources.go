@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+const (
+	// The key in a target which overrides the resources its actions need.
+	resourcesKey = "resources"
+)
+
+// loadResources returns the resources set in `targetJson` for the actions of
+// `spec`. Resources which aren't set are left as 0, meaning the default for
+// the kind of action is used.
+func loadResources(args *argsModule.Args, spec *TargetSpecImpl, targetJson map[string]interface{}, source configSource) (common.Resources, error) {
+	var resources common.Resources
+	resourcesJson, ok := targetJson[resourcesKey].(map[string]interface{})
+	if !ok {
+		return resources, nil
+	}
+
+	keys := make([]string, 0, len(resourcesJson))
+	for key := range resourcesJson {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := resourcesJson[key].(float64)
+		if !ok || value <= 0 {
+			return resources, errors.New(fmt.Sprintf(
+				"%s: field '%s' of %s: '%s' must be a positive number, got %v",
+				source.position(args, spec.Name(), resourcesKey, key), resourcesKey, spec, key, resourcesJson[key]))
+		}
+
+		switch key {
+		case "cpu":
+			resources.CPU = value
+		case "ram_mb":
+			resources.RAM = value
+		default:
+			return resources, errors.New(fmt.Sprintf(
+				"%s: field '%s' of %s: unknown resource '%s' (expected 'cpu' or 'ram_mb')",
+				source.position(args, spec.Name(), resourcesKey, key), resourcesKey, spec, key))
+		}
+	}
+
+	return resources, nil
+}
+
+// ActionResources returns the resources needed by an action of kind `kind` for
+// the target of `spec`: the default for the kind, unless the target overrides
+// it.
+func ActionResources(spec interfaces.TargetSpec, kind string) common.Resources {
+	resources := common.DefaultResources[kind]
+	if specImpl, ok := spec.(*TargetSpecImpl); ok {
+		if specImpl.resources.CPU > 0 {
+			resources.CPU = specImpl.resources.CPU
+		}
+
+		if specImpl.resources.RAM > 0 {
+			resources.RAM = specImpl.resources.RAM
+		}
+	}
+
+	return resources
+}
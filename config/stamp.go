@@ -0,0 +1,20 @@
+package config
+
+import (
+	"github.com/jeshuam/jbuild/config/cc"
+	"github.com/jeshuam/jbuild/config/genrule"
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+// UsesStamp returns true iff the target of `spec` uses the workspace status,
+// i.e. it is a stamped C++ target or a genrule using $(STAMP_FILE).
+func UsesStamp(spec interfaces.TargetSpec) bool {
+	switch target := spec.Target().(type) {
+	case *cc.Target:
+		return target.Stamp
+	case *genrule.Target:
+		return target.UsesStamp()
+	}
+
+	return false
+}
@@ -1,335 +1,428 @@
-package config
-
-import (
-	"errors"
-	"fmt"
-	"os"
-	"path/filepath"
-	"reflect"
-	"strings"
-
-	argsModule "github.com/jeshuam/jbuild/args"
-	"github.com/jeshuam/jbuild/config/cc"
-	"github.com/jeshuam/jbuild/config/doxygen"
-	"github.com/jeshuam/jbuild/config/filegroup"
-	"github.com/jeshuam/jbuild/config/genrule"
-	"github.com/jeshuam/jbuild/config/interfaces"
-	"github.com/jeshuam/jbuild/config/util"
-)
-
-var (
-	// Some useful global shortcut variables.
-	pathSeparator = string(os.PathSeparator)
-)
-
-type TargetSpecImpl struct {
-	path   string
-	name   string
-	_type  string
-	target interfaces.Target
-
-	args *argsModule.Args
-}
-
-////////////////////////////////////////////////////////////////////////////////
-//                          Interface Implementation                          //
-////////////////////////////////////////////////////////////////////////////////
-
-func (this *TargetSpecImpl) Dir() string {
-	return this.path
-}
-
-func (this *TargetSpecImpl) Path() string {
-	return filepath.Join(
-		this.args.WorkspaceDir, strings.Replace(this.path, "/", pathSeparator, -1))
-}
-
-func (this *TargetSpecImpl) String() string {
-	if this.Dir() == "." {
-		return "//:" + this.Name()
-	}
-
-	return "//" + this.Dir() + ":" + this.Name()
-}
-
-func (this *TargetSpecImpl) Type() string {
-	return this._type
-}
-
-func (this *TargetSpecImpl) Name() string {
-	return this.name
-}
-
-func (this *TargetSpecImpl) Target() interfaces.Target {
-	return this.target
-}
-
-func (this *TargetSpecImpl) OutputPath() string {
-	return filepath.Join(
-		this.args.OutputDir, strings.Replace(this.path, "/", pathSeparator, -1))
-}
-
-func (this *TargetSpecImpl) dependenciesRecurse(
-	all bool, found map[string]interfaces.TargetSpec) map[string]interfaces.TargetSpec {
-	targetType, targetValue, _ := getReflectTypeAndValueForTarget(this.Target())
-	deps := make([]interfaces.TargetSpec, 0)
-
-	found[this.String()] = this
-
-	// Iterate through the fields one by one.
-	for i := 0; i < targetType.NumField(); i++ {
-		if !targetValue.Elem().Field(i).CanInterface() {
-			continue
-		}
-
-		val := targetValue.Elem().Field(i).Interface()
-
-		switch targetType.Field(i).Type {
-		case reflect.TypeOf([]interfaces.Spec{}):
-			for _, spec := range val.([]interfaces.Spec) {
-				switch spec.(type) {
-				case interfaces.TargetSpec:
-					deps = append(deps, spec.(interfaces.TargetSpec))
-				}
-			}
-
-		case reflect.TypeOf((*interfaces.Spec)(nil)):
-			switch val.(type) {
-			case interfaces.TargetSpec:
-				deps = append(deps, val.(interfaces.TargetSpec))
-			}
-
-		case reflect.TypeOf([]interfaces.TargetSpec{}):
-			for _, spec := range val.([]interfaces.TargetSpec) {
-				deps = append(deps, spec)
-			}
-
-		case reflect.TypeOf((*interfaces.TargetSpec)(nil)):
-			deps = append(deps, val.(interfaces.TargetSpec))
-		}
-	}
-
-	// Recurse if requested.
-	if all {
-		for _, targetSpec := range deps {
-			// If we have already found this, then stop.
-			_, ok := found[targetSpec.String()]
-			if ok {
-				continue
-			}
-
-			targetSpec.(*TargetSpecImpl).dependenciesRecurse(true, found)
-		}
-	}
-
-	if !all {
-		// Add all of the direct deps.
-		for _, dep := range deps {
-			found[dep.String()] = dep
-		}
-	}
-
-	return found
-}
-
-func (this *TargetSpecImpl) Dependencies(all bool) []interfaces.TargetSpec {
-	found := this.dependenciesRecurse(all, make(map[string]interfaces.TargetSpec))
-
-	// Make a list of specs.
-	deps := make([]interfaces.TargetSpec, 0, len(found))
-	for _, v := range found {
-		if v.String() != this.String() {
-			deps = append(deps, v)
-		}
-	}
-
-	return deps
-}
-
-func (this *TargetSpecImpl) ReadyToProcess() bool {
-	for _, dep := range this.Dependencies(true) {
-		if !dep.Target().Processed() {
-			log.Infof("Not processing %s, dependency %s isn't done", this, dep)
-			return false
-		}
-	}
-
-	return true
-}
-
-////////////////////////////////////////////////////////////////////////////////
-//                            TargetSpec Methods                              //
-////////////////////////////////////////////////////////////////////////////////
-
-func (this *TargetSpecImpl) init(args *argsModule.Args, json map[string]interface{}, buildBase string) error {
-	// Extract the type from the target.
-	targetTypeInterface, ok := json["type"]
-	if !ok {
-		return errors.New(fmt.Sprintf("Target %s missing required 'type' field."))
-	}
-
-	// Save the type
-	this._type = targetTypeInterface.(string)
-
-	// If the target has already been loaded, then just return it.
-	cachedTarget, ok := util.TargetCache[this.String()]
-	if !args.NoCache && ok {
-		this.target = cachedTarget
-		return nil
-	}
-
-	// Based on the type, create a new target.
-	if strings.HasPrefix(this._type, "c++") {
-		this.target = new(cc.Target)
-	} else if strings.HasPrefix(this._type, "filegroup") {
-		this.target = new(filegroup.Target)
-	} else if strings.HasPrefix(this._type, "genrule") {
-		this.target = new(genrule.Target)
-	} else if strings.HasPrefix(this._type, "doxygen") {
-		this.target = new(doxygen.Target)
-	} else {
-		return errors.New(fmt.Sprintf("Target %s has unknown type %s", this, this._type))
-	}
-
-	// Cache the target.
-	util.TargetCache[this.String()] = this.target
-
-	// Load the target.
-	return LoadTargetFromJson(args, this, this.Target(), json, buildBase)
-}
-
-////////////////////////////////////////////////////////////////////////////////
-//                       TargetSpec Utility Functions                         //
-////////////////////////////////////////////////////////////////////////////////
-
-func expandAllTargetsInTree(args *argsModule.Args, path, buildBase string) ([]interfaces.TargetSpec, error) {
-	buildFiles, err := Glob(filepath.Join(buildBase, path, "**", args.BuildFilename))
-	if err != nil {
-		return nil, err
-	}
-
-	finalSpecs := make([]interfaces.TargetSpec, 0)
-	for _, buildFile := range buildFiles {
-		buildRelPath, _ := filepath.Rel(buildBase, buildFile)
-		buildFilePath, _ := filepath.Split(buildRelPath)
-		targets, err := expandAllTargetsInDir(args, buildFilePath, buildBase)
-		if err != nil {
-			return nil, err
-		}
-
-		finalSpecs = append(finalSpecs, targets...)
-	}
-
-	return finalSpecs, nil
-}
-
-func expandAllTargetsInDir(args *argsModule.Args, path, buildBase string) ([]interfaces.TargetSpec, error) {
-	log.Debugf("Scanning for all targets in '%s'", util.OSPathToWSPath(path))
-	buildFilepath := filepath.Join(buildBase, path, args.BuildFilename)
-	targetsJSON, err := argsModule.LoadConfigFile(args, buildFilepath)
-	if err != nil {
-		return nil, err
-	}
-
-	targets := make([]interfaces.TargetSpec, 0, len(targetsJSON))
-	for targetName := range targetsJSON {
-		targetPath := util.OSPathToWSPath(path) + ":" + targetName
-		log.Debugf("Found target '%s'", targetPath)
-		specs, err := MakeTargetSpec(args, targetPath, "", buildBase)
-		if err != nil {
-			return nil, err
-		}
-
-		targets = append(targets, specs...)
-	}
-
-	return targets, nil
-}
-
-// MakeTargetSpec constructs and returns a valid TargetSpec object, or nil if
-// the given spec doesn't refer to a valid target. rawSpec can be absolute or
-// relative to `cwd`.
-func MakeTargetSpec(args *argsModule.Args, rawSpec string, cwd string, buildBase string) ([]interfaces.TargetSpec, error) {
-	spec := new(TargetSpecImpl)
-	spec.args = args
-
-	// Split the string into it's file and dir parts.
-	rawSpecParts := strings.Split(rawSpec, ":")
-	rawPath := rawSpecParts[0]
-	if len(rawSpecParts) == 2 {
-		spec.name = rawSpecParts[1]
-	} else {
-		rawPathParts := strings.Split(rawPath, "/")
-		spec.name = rawPathParts[len(rawPathParts)-1]
-	}
-
-	// If the spec is absolute, then we can just save the path directly.
-	if strings.HasPrefix(rawSpec, "//") {
-		spec.path = strings.Trim(rawPath, "/")
-	} else {
-		// Otherwise, we need to figure out the absolute path.
-		spec.path = filepath.Join(cwd, rawPath)
-	}
-
-	// Replace any last OS-level separators. Now we can see if it has been cached!
-	spec.path = strings.Replace(spec.path, pathSeparator, "/", -1)
-	cachedSpec, ok := util.SpecCache[spec.String()]
-	if !args.NoCache && ok {
-		return []interfaces.TargetSpec{cachedSpec.(interfaces.TargetSpec)}, nil
-	}
-
-	// Final special case: if the target name is a special value, then expand the
-	// target into multiple targets.
-	if spec.name == "all" {
-		log.Infof("Expanding target '%s'", spec)
-		return expandAllTargetsInDir(args, spec.Dir(), buildBase)
-	} else if strings.HasSuffix(spec.path, "...") {
-		log.Infof("Expanding target '//%s'", spec.Dir())
-		targetPathWithoutDots, _ := filepath.Split(spec.Dir())
-		return expandAllTargetsInTree(args, targetPathWithoutDots, buildBase)
-	}
-
-	// Check to see if this spec is an external, in which case use a different
-	// BUILD file.
-	var err error
-	var buildFile map[string]interface{}
-	externalRepo, ok := args.ExternalRepos["//"+spec.Dir()]
-	if ok {
-		// Load this external repo.
-		argsModule.LoadExternalRepo(args, externalRepo)
-
-		buildFile = externalRepo.Build
-		buildBase = args.ExternalRepoDir
-
-		// If the buildFile is nil, then load it from the external repo.
-		if buildFile == nil {
-			buildFile, err = argsModule.LoadConfigFile(
-				args, filepath.Join(externalRepo.FsDir, args.BuildFilename))
-			if err != nil {
-				return nil, err
-			}
-		}
-	} else {
-		// Check to see whether the target exists. This requires that the BUILD file
-		// for this directory is parsed.
-		buildFile, err = argsModule.LoadConfigFile(
-			args, filepath.Join(spec.Path(), args.BuildFilename))
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	targetJson, ok := buildFile[spec.Name()]
-	if !ok {
-		return nil, errors.New(fmt.Sprintf("Unknown target spec %s", rawSpec))
-	}
-
-	// Otherwise, initialize the target spec and return it.
-	err = spec.init(args, targetJson.(map[string]interface{}), buildBase)
-	if err != nil {
-		return nil, err
-	}
-
-	util.SpecCache[spec.String()] = spec
-	return []interfaces.TargetSpec{spec}, nil
-}
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config/cc"
+	"github.com/jeshuam/jbuild/config/doxygen"
+	"github.com/jeshuam/jbuild/config/filegroup"
+	"github.com/jeshuam/jbuild/config/genrule"
+	"github.com/jeshuam/jbuild/config/interfaces"
+	"github.com/jeshuam/jbuild/config/util"
+)
+
+var (
+	// Some useful global shortcut variables.
+	pathSeparator = string(os.PathSeparator)
+)
+
+type TargetSpecImpl struct {
+	path   string
+	name   string
+	_type  string
+	target interfaces.Target
+
+	// The JSON the target was loaded from, and where it came from.
+	json      map[string]interface{}
+	buildBase string
+	source    configSource
+
+	// The labels which say who can depend on the target, or nil if anyone can.
+	visibility []string
+
+	// The resources the target's actions need, where they differ from the
+	// defaults.
+	resources common.Resources
+
+	// The directories searched by the globs of the target when it was loaded.
+	globDirs map[string]bool
+
+	args *argsModule.Args
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                          Interface Implementation                          //
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *TargetSpecImpl) Dir() string {
+	return this.path
+}
+
+func (this *TargetSpecImpl) Path() string {
+	return filepath.Join(
+		this.args.WorkspaceDir, strings.Replace(this.path, "/", pathSeparator, -1))
+}
+
+func (this *TargetSpecImpl) String() string {
+	if this.Dir() == "." {
+		return "//:" + this.Name()
+	}
+
+	return "//" + this.Dir() + ":" + this.Name()
+}
+
+func (this *TargetSpecImpl) Type() string {
+	return this._type
+}
+
+func (this *TargetSpecImpl) Name() string {
+	return this.name
+}
+
+func (this *TargetSpecImpl) Target() interfaces.Target {
+	return this.target
+}
+
+func (this *TargetSpecImpl) OutputPath() string {
+	return filepath.Join(
+		this.args.OutputDir, strings.Replace(this.path, "/", pathSeparator, -1))
+}
+
+func (this *TargetSpecImpl) dependenciesRecurse(
+	all bool, found map[string]interfaces.TargetSpec) map[string]interfaces.TargetSpec {
+	targetType, targetValue, _ := getReflectTypeAndValueForTarget(this.Target())
+	deps := make([]interfaces.TargetSpec, 0)
+
+	found[this.String()] = this
+
+	// Iterate through the fields one by one.
+	for i := 0; i < targetType.NumField(); i++ {
+		if !targetValue.Elem().Field(i).CanInterface() {
+			continue
+		}
+
+		val := targetValue.Elem().Field(i).Interface()
+
+		switch targetType.Field(i).Type {
+		case reflect.TypeOf([]interfaces.Spec{}):
+			for _, spec := range val.([]interfaces.Spec) {
+				switch spec.(type) {
+				case interfaces.TargetSpec:
+					deps = append(deps, spec.(interfaces.TargetSpec))
+				}
+			}
+
+		case reflect.TypeOf((*interfaces.Spec)(nil)):
+			switch val.(type) {
+			case interfaces.TargetSpec:
+				deps = append(deps, val.(interfaces.TargetSpec))
+			}
+
+		case reflect.TypeOf([]interfaces.TargetSpec{}):
+			for _, spec := range val.([]interfaces.TargetSpec) {
+				deps = append(deps, spec)
+			}
+
+		case reflect.TypeOf((*interfaces.TargetSpec)(nil)):
+			deps = append(deps, val.(interfaces.TargetSpec))
+		}
+	}
+
+	// Recurse if requested.
+	if all {
+		for _, targetSpec := range deps {
+			// If we have already found this, then stop.
+			_, ok := found[targetSpec.String()]
+			if ok {
+				continue
+			}
+
+			targetSpec.(*TargetSpecImpl).dependenciesRecurse(true, found)
+		}
+	}
+
+	if !all {
+		// Add all of the direct deps.
+		for _, dep := range deps {
+			found[dep.String()] = dep
+		}
+	}
+
+	return found
+}
+
+func (this *TargetSpecImpl) Dependencies(all bool) []interfaces.TargetSpec {
+	found := this.dependenciesRecurse(all, make(map[string]interfaces.TargetSpec))
+
+	// Make a list of specs.
+	deps := make([]interfaces.TargetSpec, 0, len(found))
+	for _, v := range found {
+		if v.String() != this.String() {
+			deps = append(deps, v)
+		}
+	}
+
+	return deps
+}
+
+func (this *TargetSpecImpl) ReadyToProcess() bool {
+	for _, dep := range this.Dependencies(true) {
+		if !dep.Target().Processed() {
+			log.Infof("Not processing %s, dependency %s isn't done", this, dep)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (this *TargetSpecImpl) GlobsChangedSince(outputStat os.FileInfo) bool {
+	return dirsChangedSince(this.globDirs, outputStat.ModTime())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                            TargetSpec Methods                              //
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *TargetSpecImpl) init(args *argsModule.Args, json map[string]interface{}, buildBase string, source configSource) error {
+	this.json, this.buildBase, this.source = json, buildBase, source
+
+	// Extract the type from the target.
+	targetTypeInterface, ok := json["type"]
+	if !ok {
+		return errors.New(fmt.Sprintf("%s: target %s is missing required field 'type'",
+			source.position(args, this.Name()), this))
+	}
+
+	// Save the type
+	this._type, ok = targetTypeInterface.(string)
+	if !ok {
+		return errors.New(fmt.Sprintf("%s: field 'type' of %s expects a string, got %s",
+			source.position(args, this.Name(), "type"), this, describeValue(targetTypeInterface)))
+	}
+
+	// If the target has already been loaded, then just return it.
+	cachedTarget, ok := util.TargetCache[this.String()]
+	if !args.NoCache && ok {
+		this.target = cachedTarget
+		return nil
+	}
+
+	// Based on the type, create a new target.
+	if strings.HasPrefix(this._type, "c++") {
+		this.target = new(cc.Target)
+	} else if strings.HasPrefix(this._type, "filegroup") {
+		this.target = new(filegroup.Target)
+	} else if strings.HasPrefix(this._type, "genrule") {
+		this.target = new(genrule.Target)
+	} else if strings.HasPrefix(this._type, "doxygen") {
+		this.target = new(doxygen.Target)
+	} else if this._type == packageGroupType {
+		return errors.New(fmt.Sprintf("%s: %s is a package group, not a target",
+			source.position(args, this.Name()), this))
+	} else {
+		return errors.New(fmt.Sprintf("%s: target %s has unknown type '%s'",
+			source.position(args, this.Name(), "type"), this, this._type))
+	}
+
+	// Make sure the target is well formed before loading anything.
+	if err := validateTarget(args, this, this.target, json, source); err != nil {
+		return err
+	}
+
+	// Cache the target.
+	util.TargetCache[this.String()] = this.target
+
+	// Load the target.
+	err := LoadTargetFromJson(args, this, this.Target(), json, buildBase)
+	if fieldErr, ok := err.(*fieldError); ok {
+		// Only point at the field if it came from this target (rather than e.g. the
+		// WORKSPACE file).
+		if _, ok := json[fieldErr.key]; ok {
+			return errors.New(fmt.Sprintf("%s: field '%s' of %s: %s",
+				source.position(args, this.Name(), fieldErr.key), fieldErr.key, this,
+				fieldErr.message))
+		}
+	}
+
+	return err
+}
+
+// position returns the position of the value found by following `keys` from
+// this target in its BUILD file.
+func (this *TargetSpecImpl) position(keys ...string) argsModule.Position {
+	return this.source.position(this.args, append([]string{this.Name()}, keys...)...)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+//                       TargetSpec Utility Functions                         //
+////////////////////////////////////////////////////////////////////////////////
+
+func expandAllTargetsInTree(args *argsModule.Args, path, buildBase string) ([]interfaces.TargetSpec, error) {
+	buildFiles, err := Glob(filepath.Join(buildBase, path, "**", args.BuildFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	finalSpecs := make([]interfaces.TargetSpec, 0)
+	for _, buildFile := range buildFiles {
+		buildRelPath, _ := filepath.Rel(buildBase, buildFile)
+		buildFilePath, _ := filepath.Split(buildRelPath)
+		targets, err := expandAllTargetsInDir(args, buildFilePath, buildBase)
+		if err != nil {
+			return nil, err
+		}
+
+		finalSpecs = append(finalSpecs, targets...)
+	}
+
+	return finalSpecs, nil
+}
+
+func expandAllTargetsInDir(args *argsModule.Args, path, buildBase string) ([]interfaces.TargetSpec, error) {
+	log.Debugf("Scanning for all targets in '%s'", util.OSPathToWSPath(path))
+	buildFilepath := filepath.Join(buildBase, path, args.BuildFilename)
+	targetsJSON, err := argsModule.LoadConfigFile(args, buildFilepath)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]interfaces.TargetSpec, 0, len(targetsJSON))
+	for targetName, targetJson := range targetsJSON {
+		if !isTargetEntry(targetName, targetJson) {
+			continue
+		}
+
+		targetPath := util.OSPathToWSPath(path) + ":" + targetName
+		log.Debugf("Found target '%s'", targetPath)
+		specs, err := MakeTargetSpec(args, targetPath, "", buildBase)
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, specs...)
+	}
+
+	return targets, nil
+}
+
+// loadBuildFile loads the BUILD file which defines the targets in the package
+// `dir`. If `dir` is an external repo, the repo is loaded and the BUILD file
+// comes from there instead. It also returns where the BUILD file came from, and
+// whether it was from an external repo.
+func loadBuildFile(args *argsModule.Args, dir string) (map[string]interface{}, configSource, bool, error) {
+	var source configSource
+	externalRepo, ok := args.ExternalRepos["//"+dir]
+	if !ok {
+		source.path = filepath.Join(args.WorkspaceDir, filepath.FromSlash(dir), args.BuildFilename)
+		buildFile, err := argsModule.LoadConfigFile(args, source.path)
+		return buildFile, source, false, err
+	}
+
+	// Load this external repo.
+	err := argsModule.LoadExternalRepo(args, externalRepo)
+	if err != nil {
+		return nil, source, true, errors.New(fmt.Sprintf(
+			"Could not load external repo '%s': %s", externalRepo.Path, err))
+	}
+
+	// If the buildFile is nil, then load it from the external repo.
+	buildFile := externalRepo.Build
+	if buildFile == nil {
+		source.path = filepath.Join(externalRepo.FsDir, args.BuildFilename)
+		buildFile, err = argsModule.LoadConfigFile(args, source.path)
+	} else if externalRepo.BuildFile != "" {
+		source.path = externalRepo.BuildFilePath(args)
+	} else {
+		// The BUILD file is embedded within the WORKSPACE file.
+		workspaceDir := externalRepo.BaseDir
+		if workspaceDir == "" {
+			workspaceDir = args.WorkspaceDir
+		}
+
+		source.path = filepath.Join(workspaceDir, args.WorkspaceFilename)
+		source.keys = []string{args.ExternalRepoKey, externalRepo.Path, "build"}
+	}
+
+	return buildFile, source, true, err
+}
+
+// MakeTargetSpec constructs and returns a valid TargetSpec object, or nil if
+// the given spec doesn't refer to a valid target. rawSpec can be absolute or
+// relative to `cwd`.
+func MakeTargetSpec(args *argsModule.Args, rawSpec string, cwd string, buildBase string) ([]interfaces.TargetSpec, error) {
+	spec := new(TargetSpecImpl)
+	spec.args = args
+
+	// Split the string into it's file and dir parts.
+	rawSpecParts := strings.Split(rawSpec, ":")
+	rawPath := rawSpecParts[0]
+	if len(rawSpecParts) == 2 {
+		spec.name = rawSpecParts[1]
+	} else {
+		rawPathParts := strings.Split(rawPath, "/")
+		spec.name = rawPathParts[len(rawPathParts)-1]
+	}
+
+	// If the spec is absolute, then we can just save the path directly.
+	if strings.HasPrefix(rawSpec, "//") {
+		spec.path = strings.Trim(rawPath, "/")
+	} else {
+		// Otherwise, we need to figure out the absolute path.
+		spec.path = filepath.Join(cwd, rawPath)
+	}
+
+	// Replace any last OS-level separators. Now we can see if it has been cached!
+	spec.path = strings.Replace(spec.path, pathSeparator, "/", -1)
+	cachedSpec, ok := util.SpecCache[spec.String()]
+	if !args.NoCache && ok {
+		return []interfaces.TargetSpec{cachedSpec.(interfaces.TargetSpec)}, nil
+	}
+
+	// Final special case: if the target name is a special value, then expand the
+	// target into multiple targets.
+	if spec.name == "all" {
+		log.Infof("Expanding target '%s'", spec)
+		return expandAllTargetsInDir(args, spec.Dir(), buildBase)
+	} else if strings.HasSuffix(spec.path, "...") {
+		log.Infof("Expanding target '//%s'", spec.Dir())
+		targetPathWithoutDots, _ := filepath.Split(spec.Dir())
+		return expandAllTargetsInTree(args, targetPathWithoutDots, buildBase)
+	}
+
+	// Check to see whether the target exists. This requires that the BUILD file
+	// for this directory is parsed.
+	buildFile, source, external, err := loadBuildFile(args, spec.Dir())
+	if err != nil {
+		return nil, err
+	} else if external {
+		buildBase = args.ExternalRepoDir
+	}
+
+	targetJsonInterface, ok := buildFile[spec.Name()]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown target spec %s", rawSpec))
+	}
+
+	targetJson, ok := targetJsonInterface.(map[string]interface{})
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%s: target %s must be an object, got %s",
+			source.position(args, spec.Name()), spec, describeValue(targetJsonInterface)))
+	}
+
+	// Otherwise, initialize the target spec and return it.
+	err = spec.init(args, targetJson, buildBase, source)
+	if err != nil {
+		return nil, err
+	}
+
+	spec.visibility, err = loadVisibility(args, buildFile, targetJson, source)
+	if err != nil {
+		return nil, err
+	}
+
+	spec.resources, err = loadResources(args, spec, targetJson, source)
+	if err != nil {
+		return nil, err
+	}
+
+	util.SpecCache[spec.String()] = spec
+	return []interfaces.TargetSpec{spec}, nil
+}
@@ -19,6 +19,13 @@ var (
 	SpecCache = make(map[string]interfaces.Spec, 0)
 )
 
+// ClearCaches forgets every spec and target loaded so far, so they are loaded
+// again from their BUILD files the next time they are needed.
+func ClearCaches() {
+	TargetCache = make(map[string]interfaces.Target, 0)
+	SpecCache = make(map[string]interfaces.Spec, 0)
+}
+
 func checkForDependencyCyclesRecurse(
 	spec interfaces.TargetSpec, visited []string, seq int) error {
 	// If this node has already been visited in the current recursive stack, then
@@ -0,0 +1,287 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+var (
+	// Keys which hold platform specific options rather than a field.
+	platformKeys = map[string]bool{"linux": true, "windows": true, "darwin": true}
+)
+
+// A configSource describes where the JSON for a target came from, so errors can
+// point at the right place.
+type configSource struct {
+	// The config file the target was defined in.
+	path string
+
+	// The keys leading to the targets within the file (e.g. for a BUILD file
+	// which is embedded in the WORKSPACE file).
+	keys []string
+}
+
+// position returns the position of the value found by following `keys` from
+// the targets in this source.
+func (this configSource) position(args *argsModule.Args, keys ...string) argsModule.Position {
+	return argsModule.ConfigPosition(args, this.path, append(append([]string{}, this.keys...), keys...)...)
+}
+
+// A fieldError is a problem with the value of a single field of a target. The
+// target adds the position of the field when reporting it.
+type fieldError struct {
+	key     string
+	message string
+}
+
+func (this *fieldError) Error() string {
+	return fmt.Sprintf("Field '%s': %s", this.key, this.message)
+}
+
+// fieldErrorMessage returns the message of `err` without the field it is
+// about, if it is a fieldError.
+func fieldErrorMessage(err error) string {
+	if fieldErr, ok := err.(*fieldError); ok {
+		return fieldErr.message
+	}
+
+	return err.Error()
+}
+
+// fieldKey returns the JSON key used for the struct field `fieldName` (i.e.
+// CompileFlags --> compile_flags).
+func fieldKey(fieldName string) string {
+	key := make([]rune, 0, len(fieldName)+4)
+	for i, c := range fieldName {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				key = append(key, '_')
+			}
+
+			c = unicode.ToLower(c)
+		}
+
+		key = append(key, c)
+	}
+
+	return string(key)
+}
+
+// targetSchema returns a description of the value expected by each field which
+// can be set from JSON, keyed by the JSON key.
+func targetSchema(targetType reflect.Type) map[string]string {
+	schema := make(map[string]string)
+	for i := 0; i < targetType.NumField(); i++ {
+		field := targetType.Field(i)
+		if field.PkgPath != "" || field.Name == "Spec" || field.Name == "Args" || field.Name == "Type" {
+			continue
+		}
+
+		switch field.Type.Kind() {
+		case reflect.Slice:
+			schema[fieldKey(field.Name)] = "a list of strings"
+		case reflect.String:
+			schema[fieldKey(field.Name)] = "a string"
+		case reflect.Bool:
+			schema[fieldKey(field.Name)] = "a boolean"
+		}
+	}
+
+	// Every target can restrict who depends on it, and say what its actions
+	// need to run.
+	schema["visibility"] = "a list of strings"
+	schema[resourcesKey] = "an object of numbers"
+
+	return schema
+}
+
+// describeValue returns a short description of a JSON value's type.
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	}
+
+	return fmt.Sprintf("a %T", value)
+}
+
+// checkValue returns an error if `value` isn't what `expected` describes.
+func checkValue(value interface{}, expected string) error {
+	switch expected {
+	case "a string":
+		if _, ok := value.(string); ok {
+			return nil
+		}
+
+	case "a boolean":
+		if _, ok := value.(bool); ok {
+			return nil
+		}
+
+	case "a list of strings":
+		items, ok := value.([]interface{})
+		if !ok {
+			break
+		}
+
+		for _, item := range items {
+			if _, ok := item.(string); !ok {
+				return errors.New(fmt.Sprintf(
+					"expects %s, got a list containing %s", expected, describeValue(item)))
+			}
+		}
+
+		return nil
+
+	case "an object of numbers":
+		items, ok := value.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		for _, item := range items {
+			if _, ok := item.(float64); !ok {
+				return errors.New(fmt.Sprintf(
+					"expects %s, got an object containing %s", expected, describeValue(item)))
+			}
+		}
+
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf("expects %s, got %s", expected, describeValue(value)))
+}
+
+// editDistance returns the Levenshtein distance between `a` and `b`.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+// suggestField returns the field in `schema` which `key` was most likely meant
+// to be, or "" if nothing is close enough.
+func suggestField(schema map[string]string, key string) string {
+	suggestion := ""
+	bestDistance := 3
+	for field := range schema {
+		distance := editDistance(strings.ToLower(key), field)
+		if distance < bestDistance || (distance == bestDistance && field < suggestion) {
+			suggestion, bestDistance = field, distance
+		}
+	}
+
+	if bestDistance >= len(key) {
+		return ""
+	}
+
+	return suggestion
+}
+
+// validateFields checks each field in `json` against `schema`, and adds a
+// message to `problems` for each one which is wrong.
+func validateFields(args *argsModule.Args, spec interfaces.TargetSpec, schema map[string]string, json map[string]interface{}, source configSource, keys []string, problems []string) []string {
+	jsonKeys := make([]string, 0, len(json))
+	for key := range json {
+		jsonKeys = append(jsonKeys, key)
+	}
+
+	sort.Strings(jsonKeys)
+	for _, key := range jsonKeys {
+		value := json[key]
+		fieldKeys := append(append([]string{}, keys...), key)
+		position := source.position(args, fieldKeys...)
+
+		if key == "type" {
+			continue
+		} else if platformKeys[key] {
+			platformJson, ok := value.(map[string]interface{})
+			if !ok {
+				problems = append(problems, fmt.Sprintf(
+					"%s: platform options '%s' of %s must be an object, got %s",
+					position, key, spec, describeValue(value)))
+			} else if len(keys) == 1 {
+				problems = validateFields(args, spec, schema, platformJson, source, fieldKeys, problems)
+			}
+
+			continue
+		}
+
+		expected, ok := schema[key]
+		if !ok {
+			message := fmt.Sprintf("%s: unknown field '%s' in %s", position, key, spec)
+			if suggestion := suggestField(schema, key); suggestion != "" {
+				message += fmt.Sprintf("; did you mean '%s'?", suggestion)
+			}
+
+			problems = append(problems, message)
+			continue
+		}
+
+		if err := checkValue(value, expected); err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"%s: field '%s' of %s %s", position, key, spec, err))
+		}
+	}
+
+	return problems
+}
+
+// validateTarget checks the JSON for `spec` against the fields of `target`
+// before anything is loaded, and reports every problem found.
+func validateTarget(args *argsModule.Args, spec interfaces.TargetSpec, target interfaces.Target, targetJson map[string]interface{}, source configSource) error {
+	targetType, _, err := getReflectTypeAndValueForTarget(target)
+	if err != nil {
+		return err
+	}
+
+	problems := validateFields(
+		args, spec, targetSchema(targetType), targetJson, source, []string{spec.Name()}, nil)
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	argsModule "github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+const (
+	// The key in a BUILD file which sets the visibility of the targets in it
+	// which don't set their own.
+	defaultVisibilityKey = "default_visibility"
+
+	// The type of a package group, i.e. a named set of packages which can be used
+	// in a visibility list. Package groups aren't targets, so can't be built.
+	packageGroupType = "package_group"
+
+	// Visibility labels which don't refer to a package.
+	publicVisibility  = "//visibility:public"
+	privateVisibility = "//visibility:private"
+)
+
+// isTargetEntry returns true iff the entry `name` in a BUILD file, which has
+// the value `value`, defines a target (rather than e.g. a package group).
+func isTargetEntry(name string, value interface{}) bool {
+	if name == defaultVisibilityKey {
+		return false
+	}
+
+	targetJson, ok := value.(map[string]interface{})
+	return !ok || targetJson["type"] != packageGroupType
+}
+
+// packageName returns the name of the package in `dir`, using "" for the root
+// of the workspace.
+func packageName(dir string) string {
+	dir = strings.Trim(filepath.ToSlash(dir), "/")
+	if dir == "." {
+		return ""
+	}
+
+	return dir
+}
+
+// splitLabel splits the label `label` into its package and name. Labels not
+// starting with // are relative to the package `dir`.
+func splitLabel(label, dir string) (string, string) {
+	labelParts := strings.SplitN(label, ":", 2)
+	labelPath := labelParts[0]
+	name := path.Base(labelPath)
+	if len(labelParts) == 2 {
+		name = labelParts[1]
+	}
+
+	if strings.HasPrefix(label, "//") {
+		return packageName(labelPath), name
+	}
+
+	return packageName(path.Join(packageName(dir), labelPath)), name
+}
+
+// matchPackage returns true iff the package `pkg` matches `pattern`, a package
+// from a package group (e.g. "//foo" or "//foo/..." for foo and everything
+// below it).
+func matchPackage(pattern, pkg string) bool {
+	if strings.HasSuffix(pattern, "/...") {
+		base := packageName(strings.TrimSuffix(pattern, "/..."))
+		return base == "" || pkg == base || strings.HasPrefix(pkg, base+"/")
+	}
+
+	return packageName(pattern) == pkg
+}
+
+// packageGroupContains returns true iff the package group `name` in the
+// package `groupPkg` (or one of the groups it includes) contains the package
+// `pkg`. `seen` holds the groups already checked, so include cycles end.
+func packageGroupContains(args *argsModule.Args, groupPkg, name, pkg string, seen map[string]bool) (bool, error) {
+	label := "//" + groupPkg + ":" + name
+	if seen[label] {
+		return false, nil
+	}
+
+	seen[label] = true
+	buildFile, source, _, err := loadBuildFile(args, groupPkg)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("Could not load package group %s: %s", label, err))
+	}
+
+	groupJson, ok := buildFile[name].(map[string]interface{})
+	if !ok || groupJson["type"] != packageGroupType {
+		return false, errors.New(fmt.Sprintf("%s is not a package group", label))
+	}
+
+	for _, key := range []string{"packages", "includes"} {
+		values, err := loadStrings(groupJson, key)
+		if err != nil {
+			return false, errors.New(fmt.Sprintf("%s: field '%s' of package group %s %s",
+				source.position(args, name, key), key, label, fieldErrorMessage(err)))
+		}
+
+		for _, value := range values {
+			if key == "packages" && matchPackage(value, pkg) {
+				return true, nil
+			} else if key == "includes" {
+				includePkg, includeName := splitLabel(value, groupPkg)
+				found, err := packageGroupContains(args, includePkg, includeName, pkg, seen)
+				if err != nil || found {
+					return found, err
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// isVisibleTo returns true iff `target` can be depended on by targets in the
+// package `pkg`. Targets are always visible within their own package, and to
+// everything if they (and their BUILD file) don't restrict their visibility.
+func (this *TargetSpecImpl) isVisibleTo(pkg string) (bool, error) {
+	targetPkg := packageName(this.Dir())
+	if this.visibility == nil || targetPkg == pkg {
+		return true, nil
+	}
+
+	for _, label := range this.visibility {
+		if label == publicVisibility {
+			return true, nil
+		} else if label == privateVisibility {
+			continue
+		}
+
+		labelPkg, name := splitLabel(label, targetPkg)
+		switch name {
+		case "__pkg__":
+			if pkg == labelPkg {
+				return true, nil
+			}
+
+		case "__subpackages__":
+			if labelPkg == "" || pkg == labelPkg || strings.HasPrefix(pkg, labelPkg+"/") {
+				return true, nil
+			}
+
+		default:
+			found, err := packageGroupContains(this.args, labelPkg, name, pkg, make(map[string]bool))
+			if err != nil || found {
+				return found, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// checkVisibility returns an error if `dep` can't be depended on by `spec`.
+func checkVisibility(dep, spec interfaces.TargetSpec) error {
+	depImpl, ok := dep.(*TargetSpecImpl)
+	if !ok {
+		return nil
+	}
+
+	visible, err := depImpl.isVisibleTo(packageName(spec.Dir()))
+	if err != nil {
+		return err
+	} else if !visible {
+		return errors.New(fmt.Sprintf("%s depends on %s, which isn't visible to it (visibility = %s)",
+			spec, dep, depImpl.visibility))
+	}
+
+	return nil
+}
+
+// loadVisibility returns the visibility of the target with the JSON
+// `targetJson`, which is defined in `buildFile`. A nil visibility means the
+// target is visible everywhere.
+func loadVisibility(args *argsModule.Args, buildFile, targetJson map[string]interface{}, source configSource) ([]string, error) {
+	if _, ok := targetJson["visibility"]; ok {
+		return loadStrings(targetJson, "visibility")
+	}
+
+	if _, ok := buildFile[defaultVisibilityKey]; !ok {
+		return nil, nil
+	}
+
+	visibility, err := loadStrings(buildFile, defaultVisibilityKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s %s", source.position(args, defaultVisibilityKey),
+			defaultVisibilityKey, fieldErrorMessage(err)))
+	}
+
+	return visibility, nil
+}
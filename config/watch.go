@@ -0,0 +1,53 @@
+package config
+
+import (
+	"github.com/jeshuam/jbuild/config/interfaces"
+)
+
+// SourceFiles returns the files (other than generated files) listed directly
+// in the fields of the target of `spec`. Files in a filegroup belong to the
+// filegroup, rather than the targets which use it.
+func SourceFiles(spec interfaces.TargetSpec) []interfaces.FileSpec {
+	specImpl, ok := spec.(*TargetSpecImpl)
+	if !ok {
+		return nil
+	}
+
+	targetType, _, err := getReflectTypeAndValueForTarget(spec.Target())
+	if err != nil {
+		return nil
+	}
+
+	files := make([]interfaces.FileSpec, 0)
+	for i := 0; i < targetType.NumField(); i++ {
+		field := targetType.Field(i)
+		if field.Tag.Get("types") == "" {
+			continue
+		}
+
+		for _, fieldSpec := range specsInField(specImpl, field.Name) {
+			if fileSpec, ok := fieldSpec.(interfaces.FileSpec); ok && !fileSpec.IsGenerated() {
+				files = append(files, fileSpec)
+			}
+		}
+	}
+
+	return files
+}
+
+// GlobDirs returns the directories searched by the globs in the BUILD file
+// entry of `spec`. Adding a file or directory to one of them might change what
+// the globs match.
+func GlobDirs(spec interfaces.TargetSpec) []string {
+	specImpl, ok := spec.(*TargetSpecImpl)
+	if !ok {
+		return nil
+	}
+
+	dirs := make([]string, 0, len(specImpl.globDirs))
+	for dir := range specImpl.globDirs {
+		dirs = append(dirs, dir)
+	}
+
+	return dirs
+}
@@ -1,171 +1,330 @@
-package jbuild
-
-import (
-	"errors"
-	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
-
-	"github.com/jeshuam/jbuild/args"
-	jbuildCommands "github.com/jeshuam/jbuild/command"
-	"github.com/jeshuam/jbuild/config"
-	"github.com/jeshuam/jbuild/config/interfaces"
-	"github.com/jeshuam/jbuild/config/util"
-	"github.com/jeshuam/jbuild/progress"
-	"github.com/op/go-logging"
-)
-
-var (
-	validCommands = map[string]bool{
-		"build": true,
-		"test":  true,
-		"run":   true,
-		"clean": true,
-	}
-
-	format = logging.MustStringFormatter(
-		`%{color}%{level:.1s} %{shortfunc}() >%{color:reset} %{message}`)
-)
-
-func printUsage() {
-	fmt.Println("Usage: jbuild [flags] build|test|run|clean [target [targets...]]")
-}
-
-func JBuildRun(args args.Args, cmdArgs []string) error {
-	log := logging.MustGetLogger("jbuild")
-
-	// Disable logging if necessary.
-	logging.SetFormatter(format)
-	if args.ShowLog {
-		logging.SetLevel(logging.DEBUG, "jbuild")
-		progress.Disable()
-	} else {
-		logging.SetLevel(logging.CRITICAL, "jbuild")
-	}
-
-	// Make sure at least the command was passed.
-	if len(cmdArgs) < 1 {
-		printUsage()
-		return errors.New("No command passed on command-line")
-	}
-
-	// Get the command.
-	command := cmdArgs[0]
-	if !validCommands[command] {
-		printUsage()
-		return errors.New(fmt.Sprintf("Unknown command '%s'", command))
-	}
-
-	// If we are cleaning, just delete the output directory.
-	if command == "clean" {
-		log.Infof("Cleaning output directory '%s'", args.OutputDir)
-		if err := os.RemoveAll(args.OutputDir); err != nil {
-			return errors.New(
-				fmt.Sprintf("Could not clean output directory: '%s'", err))
-		}
-
-		// Maybe clean external repos.
-		if args.CleanExternalRepos {
-			log.Infof("Cleaning external repos...")
-			if err := os.RemoveAll(args.ExternalRepoDir); err != nil {
-				return err
-			}
-		}
-
-		return nil
-	}
-
-	// If we aren't cleaning, get more arguments.
-	if len(cmdArgs) < 2 {
-		printUsage()
-		return errors.New("No targets specified on the command-line")
-	}
-
-	// Get the current processing target.
-	targetArgs := cmdArgs[1:]
-	if command == "run" {
-		targetArgs = targetArgs[:1]
-	}
-
-	// Save 2 lists: a set of targets specified, and a set of targets to process.
-	var firstTargetSpecified interfaces.TargetSpec
-	targetsSpecified := make(map[string]interfaces.TargetSpec)
-	targetsToBuild := make(map[string]interfaces.TargetSpec)
-	for _, target := range targetArgs {
-		log.Infof("Loading target(s) '%s'", target)
-		relStart, _ := filepath.Rel(args.WorkspaceDir, args.CurrentDir)
-		specs, err := config.MakeTargetSpec(&args, target, relStart, args.WorkspaceDir)
-		if err != nil {
-			return errors.New(fmt.Sprintf("Failed to load target '%s': %s", target, err))
-		}
-
-		for _, spec := range specs {
-			// log.Infof("Processing target spec '%s'", spec)
-
-			// Make sure this spec is valid.
-			if command == "test" && !strings.HasSuffix(spec.Type(), "test") {
-				log.Warningf("Ignoring non-test target '%s'\n", spec)
-				continue
-			} else if command == "run" && !strings.HasSuffix(spec.Type(), "binary") {
-				log.Warningf("Ignoring non-binary target '%s'\n", spec)
-				continue
-			}
-
-			// log.Infof("Check '%s' for cycles", spec)
-			if err := util.CheckForDependencyCycles(spec); err != nil {
-				return err
-			}
-
-			// log.Infof("Validating '%s'", spec)
-			if err := spec.Target().Validate(); err != nil {
-				return err
-			}
-
-			// Save the target.
-			if len(targetsSpecified) == 0 {
-				firstTargetSpecified = spec
-			}
-
-			targetsSpecified[spec.String()] = spec
-			targetsToBuild[spec.String()] = spec
-			for _, spec := range spec.Dependencies(true) {
-				targetsToBuild[spec.String()] = spec
-			}
-		}
-	}
-
-	// Build the targets.
-	log.Info("Building targets...")
-	err := jbuildCommands.BuildTargets(&args, targetsToBuild)
-	if err != nil {
-		return err
-	}
-
-	// Further process the targets.
-	if command == "run" {
-		log.Infof("Running '%s'", firstTargetSpecified)
-		binary := firstTargetSpecified.Target().OutputFiles()[0]
-		cmd := exec.Command(binary, cmdArgs[2:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Stdin = os.Stdin
-		cmd.Dir = filepath.Dir(binary)
-
-		if args.ShowCommands {
-			log.Infof("$ %s", cmd.Args)
-		}
-		cmd.Run()
-	} else if command == "test" {
-		if len(targetsSpecified) == 1 {
-			log.Infof("Testing 1 target")
-		} else {
-			log.Infof("Testing %d targets", len(targetsSpecified))
-		}
-
-		jbuildCommands.RunTests(&args, targetsSpecified)
-	}
-
-	return nil
-}
+package jbuild
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeshuam/jbuild/args"
+	jbuildCommands "github.com/jeshuam/jbuild/command"
+	"github.com/jeshuam/jbuild/common"
+	"github.com/jeshuam/jbuild/config"
+	"github.com/jeshuam/jbuild/config/interfaces"
+	"github.com/jeshuam/jbuild/config/util"
+	"github.com/jeshuam/jbuild/progress"
+	"github.com/op/go-logging"
+)
+
+var (
+	validCommands = map[string]bool{
+		"build":               true,
+		"test":                true,
+		"run":                 true,
+		"clean":               true,
+		"vendor":              true,
+		"externals":           true,
+		"fmt":                 true,
+		"lint":                true,
+		"deps-check":          true,
+		"gen-build":           true,
+		"import-compdb":       true,
+		"analyze-profile":     true,
+		"serve":               true,
+		"shutdown":            true,
+		"verify-reproducible": true,
+	}
+
+	format = logging.MustStringFormatter(
+		`%{color}%{level:.1s} %{shortfunc}() >%{color:reset} %{message}`)
+)
+
+func printUsage() {
+	fmt.Println("Usage: jbuild [flags] build|test|run|clean|vendor|externals|fmt|lint|deps-check|gen-build|import-compdb|analyze-profile|serve|shutdown|worker|verify-reproducible [target [targets...]]")
+}
+
+// JBuildRun runs the jbuild command in `cmdArgs`, writing build events to the
+// build event file if one was asked for.
+func JBuildRun(args args.Args, cmdArgs []string) error {
+	if args.BuildEventJsonFile == "" {
+		return jbuildRun(args, cmdArgs)
+	}
+
+	if err := common.StartBuildEvents(args.BuildEventJsonFile); err != nil {
+		return errors.New(fmt.Sprintf(
+			"Could not write build events to '%s': %s", args.BuildEventJsonFile, err))
+	}
+
+	common.EmitBuildEvent(common.BuildEvent{
+		Type: common.BuildStartedEvent, Arguments: cmdArgs, WorkspaceDir: args.WorkspaceDir})
+	err := jbuildRun(args, cmdArgs)
+	if finishErr := common.FinishBuildEvents(err); finishErr != nil && err == nil {
+		return finishErr
+	}
+
+	return err
+}
+
+func jbuildRun(args args.Args, cmdArgs []string) error {
+	log := logging.MustGetLogger("jbuild")
+
+	// Disable logging if necessary.
+	logging.SetFormatter(format)
+	if args.ShowLog {
+		logging.SetLevel(logging.DEBUG, "jbuild")
+		progress.Disable()
+	} else {
+		logging.SetLevel(logging.CRITICAL, "jbuild")
+	}
+
+	// Make sure at least the command was passed.
+	if len(cmdArgs) < 1 {
+		printUsage()
+		return errors.New("No command passed on command-line")
+	}
+
+	// Get the command.
+	command := cmdArgs[0]
+	if !validCommands[command] {
+		printUsage()
+		return errors.New(fmt.Sprintf("Unknown command '%s'", command))
+	}
+
+	// The server runs until it is shut down, and is shut down by the client.
+	if command == "serve" {
+		return Serve(args)
+	} else if command == "shutdown" {
+		return StopServer(args)
+	}
+
+	// If we are cleaning, just delete the output directory.
+	if command == "clean" {
+		log.Infof("Cleaning output directory '%s'", args.OutputDir)
+		if err := os.RemoveAll(args.OutputDir); err != nil {
+			return errors.New(
+				fmt.Sprintf("Could not clean output directory: '%s'", err))
+		}
+
+		// Maybe clean external repos.
+		if args.CleanExternalRepos {
+			log.Infof("Cleaning external repos...")
+			if err := os.RemoveAll(args.ExternalRepoDir); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// Vendoring works on all external repos, so doesn't need any targets.
+	if command == "vendor" {
+		return jbuildCommands.VendorExternals(&args)
+	}
+
+	if command == "externals" {
+		return jbuildCommands.ListExternals(&args)
+	}
+
+	// Formatting works on BUILD files (or directories) rather than targets.
+	if command == "fmt" {
+		return jbuildCommands.FormatBuildFiles(&args, cmdArgs[1:])
+	}
+
+	// Generating BUILD files works on directories rather than targets.
+	if command == "gen-build" {
+		return jbuildCommands.GenerateBuildFiles(&args, cmdArgs[1:])
+	}
+
+	// Importing needs the compilation database to import.
+	if command == "import-compdb" {
+		if len(cmdArgs) != 2 {
+			printUsage()
+			return errors.New("import-compdb needs the path to a compile_commands.json file")
+		}
+
+		return jbuildCommands.ImportCompilationDatabase(&args, cmdArgs[1])
+	}
+
+	// Analyzing needs the profile to analyze.
+	if command == "analyze-profile" {
+		if len(cmdArgs) != 2 {
+			printUsage()
+			return errors.New("analyze-profile needs the path to a profile")
+		}
+
+		return jbuildCommands.AnalyzeProfile(&args, cmdArgs[1])
+	}
+
+	// Linting defaults to every target in the workspace.
+	if command == "lint" {
+		return jbuildCommands.LintTargets(&args, cmdArgs[1:])
+	}
+
+	// Checking deps needs to know which headers each file includes, and defaults
+	// to every target in the workspace.
+	if command == "deps-check" {
+		args.WriteDepfiles = true
+		if len(cmdArgs) < 2 {
+			cmdArgs = append(cmdArgs, "//...")
+		}
+	}
+
+	// If we aren't cleaning, get more arguments.
+	if len(cmdArgs) < 2 {
+		printUsage()
+		return errors.New("No targets specified on the command-line")
+	}
+
+	// Reproducible builds are checked by building the targets twice.
+	if command == "verify-reproducible" {
+		return jbuildCommands.VerifyReproducible(&args, func() error {
+			return jbuildRun(args, append([]string{"build"}, cmdArgs[1:]...))
+		})
+	}
+
+	// Get the current processing target.
+	targetArgs := cmdArgs[1:]
+	if command == "run" {
+		targetArgs = targetArgs[:1]
+	}
+
+	// Save 2 lists: a set of targets specified, and a set of targets to process.
+	load := func() (map[string]interfaces.TargetSpec, map[string]interfaces.TargetSpec, error) {
+		_, targetsSpecified, targetsToBuild, err := loadTargets(&args, command, targetArgs)
+		return targetsSpecified, targetsToBuild, err
+	}
+
+	// In watch mode, the targets are built (and tested) again whenever they change.
+	if args.Watch {
+		if command != "build" && command != "test" {
+			return errors.New(fmt.Sprintf("--watch doesn't work with '%s'", command))
+		}
+
+		return jbuildCommands.WatchTargets(&args, command == "test", load)
+	}
+
+	firstTargetSpecified, targetsSpecified, targetsToBuild, err := loadTargets(&args, command, targetArgs)
+	if err != nil {
+		return err
+	}
+
+	// Record everything run from here on, if asked to.
+	if args.Profile != "" {
+		common.StartProfile()
+		defer writeProfile(&args, targetsToBuild)
+	}
+
+	// Build the targets.
+	log.Info("Building targets...")
+	err = jbuildCommands.BuildTargets(&args, targetsToBuild)
+	if err != nil {
+		return err
+	}
+
+	// Further process the targets.
+	if command == "run" {
+		log.Infof("Running '%s'", firstTargetSpecified)
+		binary := firstTargetSpecified.Target().OutputFiles()[0]
+		cmd := exec.Command(binary, cmdArgs[2:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		cmd.Dir = filepath.Dir(binary)
+		cmd.Env = append(os.Environ(), common.RunfilesEnv(
+			common.RunfilesDir(firstTargetSpecified.OutputPath(), firstTargetSpecified.Name()))...)
+
+		if args.ShowCommands {
+			log.Infof("$ %s", cmd.Args)
+		}
+		cmd.Run()
+	} else if command == "test" {
+		if len(targetsSpecified) == 1 {
+			log.Infof("Testing 1 target")
+		} else {
+			log.Infof("Testing %d targets", len(targetsSpecified))
+		}
+
+		return jbuildCommands.RunTests(&args, targetsSpecified)
+	} else if command == "deps-check" {
+		return jbuildCommands.CheckDeps(&args, targetsSpecified)
+	}
+
+	return nil
+}
+
+// writeProfile writes the actions recorded while building `targetsToBuild` to
+// the profile file.
+func writeProfile(args *args.Args, targetsToBuild map[string]interfaces.TargetSpec) {
+	deps := make(map[string][]string)
+	for name, spec := range targetsToBuild {
+		for _, dep := range spec.Dependencies(false) {
+			deps[name] = append(deps[name], dep.String())
+		}
+	}
+
+	slots := args.Threads
+	if args.TestThreads > slots {
+		slots = args.TestThreads
+	}
+
+	if err := common.WriteProfile(args.Profile, slots, deps); err != nil {
+		fmt.Printf("Could not write profile '%s': %s\n", args.Profile, err)
+	}
+}
+
+// loadTargets loads the targets in `targetArgs` for `command`, returning the
+// first target loaded, the targets which were asked for and every target they
+// need (including themselves). Targets which `command` can't be used on are
+// ignored.
+func loadTargets(args *args.Args, command string, targetArgs []string) (interfaces.TargetSpec, map[string]interfaces.TargetSpec, map[string]interfaces.TargetSpec, error) {
+	log := logging.MustGetLogger("jbuild")
+	var firstTargetSpecified interfaces.TargetSpec
+	targetsSpecified := make(map[string]interfaces.TargetSpec)
+	targetsToBuild := make(map[string]interfaces.TargetSpec)
+	for _, target := range targetArgs {
+		log.Infof("Loading target(s) '%s'", target)
+		relStart, _ := filepath.Rel(args.WorkspaceDir, args.CurrentDir)
+		specs, err := config.MakeTargetSpec(args, target, relStart, args.WorkspaceDir)
+		if err != nil {
+			return nil, nil, nil, errors.New(fmt.Sprintf("Failed to load target '%s': %s", target, err))
+		}
+
+		for _, spec := range specs {
+			// log.Infof("Processing target spec '%s'", spec)
+
+			// Make sure this spec is valid.
+			if command == "test" && !strings.HasSuffix(spec.Type(), "test") {
+				log.Warningf("Ignoring non-test target '%s'\n", spec)
+				continue
+			} else if command == "run" && !strings.HasSuffix(spec.Type(), "binary") {
+				log.Warningf("Ignoring non-binary target '%s'\n", spec)
+				continue
+			}
+
+			// log.Infof("Check '%s' for cycles", spec)
+			if err := util.CheckForDependencyCycles(spec); err != nil {
+				return nil, nil, nil, err
+			}
+
+			// log.Infof("Validating '%s'", spec)
+			if err := spec.Target().Validate(); err != nil {
+				return nil, nil, nil, err
+			}
+
+			// Save the target.
+			if len(targetsSpecified) == 0 {
+				firstTargetSpecified = spec
+			}
+
+			targetsSpecified[spec.String()] = spec
+			targetsToBuild[spec.String()] = spec
+			for _, spec := range spec.Dependencies(true) {
+				targetsToBuild[spec.String()] = spec
+			}
+		}
+	}
+
+	return firstTargetSpecified, targetsSpecified, targetsToBuild, nil
+}
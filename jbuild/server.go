@@ -0,0 +1,388 @@
+package jbuild
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/config"
+	"github.com/jeshuam/jbuild/config/util"
+	"github.com/op/go-logging"
+)
+
+const (
+	// How long to wait for a newly started server to accept connections.
+	serverStartTimeout = 10 * time.Second
+)
+
+// A serverRequest asks the server to run jbuild with the given command-line
+// arguments, as if it was run in `Dir` with the environment `Env`.
+type serverRequest struct {
+	Dir  string
+	Env  []string
+	Args []string
+
+	// If set, the server stops instead.
+	Shutdown bool
+}
+
+// A serverResponse is sent by the server for each chunk of output produced
+// while running a request, followed by one with `Done` set once it finishes.
+type serverResponse struct {
+	Output []byte
+	Done   bool
+	Error  string
+}
+
+// A server runs commands for a single workspace, keeping the targets it loads
+// cached between commands.
+type server struct {
+	// The args used for the last command, the flags they were loaded from and
+	// when they were loaded.
+	args     args.Args
+	flagsKey string
+	loaded   time.Time
+
+	// When each file the args were loaded from was modified, as of when it was
+	// first read.
+	configTimes map[string]time.Time
+}
+
+// serverSocketDir returns the directory holding the sockets of the current
+// user's servers, making it if necessary. This is in $XDG_RUNTIME_DIR if it is
+// set, or ~/.jbuild otherwise, and must only be accessible by the user: anyone
+// who can connect to a server can run commands as the user who started it.
+func serverSocketDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "jbuild")
+	} else {
+		usr, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+
+		dir = filepath.Join(usr.HomeDir, ".jbuild", "servers")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	stat, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	} else if !stat.IsDir() {
+		return "", errors.New(fmt.Sprintf("'%s' is not a directory", dir))
+	} else if err := checkPrivateDir(stat); err != nil {
+		return "", errors.New(fmt.Sprintf("Will not use '%s' for jbuild servers: %s", dir, err))
+	}
+
+	return dir, nil
+}
+
+// ServerSocket returns the path of the socket used by the server for the
+// workspace in `workspaceDir`.
+func ServerSocket(workspaceDir string) (string, error) {
+	dir, err := serverSocketDir()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256([]byte(workspaceDir))
+	return filepath.Join(dir, fmt.Sprintf("%x.sock", hash[:8])), nil
+}
+
+// UsesServer returns true iff `command` should be run by the server when the
+// server flag is set. Commands which need the terminal are always run locally.
+func UsesServer(args args.Args, command string) bool {
+	return command != "run" && command != "serve" && command != "shutdown" && !args.Watch
+}
+
+// Serve runs a server for the workspace in `args` until it is asked to stop.
+// Commands are run one at a time, in the order they arrive.
+func Serve(args args.Args) error {
+	socket, err := ServerSocket(args.WorkspaceDir)
+	if err != nil {
+		return err
+	}
+
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return errors.New(fmt.Sprintf(
+			"A jbuild server is already running for '%s'", args.WorkspaceDir))
+	}
+
+	// Anything left over was left by a server which didn't stop cleanly.
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	defer listener.Close()
+	fmt.Printf("Serving '%s' on '%s'\n", args.WorkspaceDir, socket)
+	server := new(server)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		shutdown := server.handle(conn)
+		conn.Close()
+		if shutdown {
+			return nil
+		}
+	}
+}
+
+// handle runs the request sent over `conn`, returning true iff the server
+// should stop.
+func (this *server) handle(conn net.Conn) bool {
+	log := logging.MustGetLogger("jbuild")
+	encoder := json.NewEncoder(conn)
+	var request serverRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		log.Warningf("Could not read request: %s", err)
+		return false
+	}
+
+	if request.Shutdown {
+		encoder.Encode(serverResponse{Done: true})
+		return true
+	}
+
+	err := captureOutput(func() error {
+		return this.run(request)
+	}, func(output []byte) {
+		encoder.Encode(serverResponse{Output: output})
+	})
+
+	response := serverResponse{Done: true}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	encoder.Encode(response)
+	return false
+}
+
+// run runs the command in `request`. The cached targets are only kept if the
+// flags are the same as last time and none of the files they were loaded from
+// have changed.
+func (this *server) run(request serverRequest) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			this.loaded = time.Time{}
+			err = errors.New(fmt.Sprintf("The jbuild server crashed: %s", r))
+		}
+	}()
+
+	cmdArgs, err := args.ParseFlags(request.Args)
+	if err != nil {
+		return err
+	}
+
+	// Run the command in the client's environment.
+	os.Clearenv()
+	for _, variable := range request.Env {
+		if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 {
+			os.Setenv(parts[0], parts[1])
+		}
+	}
+
+	if dir, err := os.Getwd(); err == nil {
+		defer os.Chdir(dir)
+	}
+
+	if err := os.Chdir(request.Dir); err != nil {
+		return err
+	}
+
+	flagsKey := strings.Join(request.Args[:len(request.Args)-len(cmdArgs)], "\x00")
+	if this.loaded.IsZero() || flagsKey != this.flagsKey ||
+		config.ConfigFilesChanged(this.configTimes, this.loaded) ||
+		config.CachesChangedSince(&this.args, this.loaded) {
+		util.ClearCaches()
+		loaded := time.Now()
+		newArgs, err := args.Load(request.Dir, nil)
+		if err != nil {
+			this.loaded = time.Time{}
+			return err
+		}
+
+		this.args, this.flagsKey, this.loaded = newArgs, flagsKey, loaded
+		this.configTimes = config.ConfigFileTimes(&this.args)
+	}
+
+	// External repos (and so their WORKSPACE files) are loaded as they are
+	// needed, so look for new ones once the command has run.
+	defer func() {
+		for path, modTime := range config.ConfigFileTimes(&this.args) {
+			if _, ok := this.configTimes[path]; !ok {
+				this.configTimes[path] = modTime
+			}
+		}
+	}()
+
+	requestArgs := this.args
+	requestArgs.CurrentDir = request.Dir
+	return JBuildRun(requestArgs, cmdArgs)
+}
+
+// captureOutput calls `run`, passing everything it writes to stdout, stderr or
+// the log to `send`.
+func captureOutput(run func() error, send func([]byte)) error {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = writer, writer
+	logging.SetBackend(logging.NewLogBackend(writer, "", 0))
+
+	copied := make(chan bool)
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, err := reader.Read(buffer)
+			if n > 0 {
+				send(append([]byte(nil), buffer[:n]...))
+			}
+
+			if err != nil {
+				break
+			}
+		}
+
+		close(copied)
+	}()
+
+	err = run()
+	writer.Close()
+	<-copied
+	reader.Close()
+
+	os.Stdout, os.Stderr = stdout, stderr
+	logging.SetBackend(logging.NewLogBackend(stderr, "", 0))
+	return err
+}
+
+// dialServer connects to the server for the workspace in `workspaceDir`.
+func dialServer(workspaceDir string) (net.Conn, error) {
+	socket, err := ServerSocket(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.Dial("unix", socket)
+}
+
+// startServer starts a server for the workspace in `workspaceDir` in the
+// background, and connects to it once it's ready.
+func startServer(workspaceDir, workspaceFilename string) (net.Conn, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(executable, "--workspace_dir="+workspaceDir,
+		"--workspace_filename="+workspaceFilename, "serve")
+	cmd.Dir = workspaceDir
+	detachServer(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not start the jbuild server: %s", err))
+	}
+
+	cmd.Process.Release()
+	deadline := time.Now().Add(serverStartTimeout)
+	for {
+		conn, err := dialServer(workspaceDir)
+		if err == nil {
+			return conn, nil
+		} else if time.Now().After(deadline) {
+			return nil, errors.New(fmt.Sprintf("Could not connect to the jbuild server: %s", err))
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// RunOnServer runs jbuild with the command-line arguments `arguments` on the
+// server for the workspace containing `defaults.CurrentDir` (starting it if
+// necessary), writing its output to `output`.
+func RunOnServer(defaults args.Args, arguments []string, output io.Writer) error {
+	workspaceDir, err := filepath.Abs(defaults.WorkspaceDir)
+	if defaults.WorkspaceDir == "" {
+		workspaceDir, err = args.FindWorkspaceDir(defaults.CurrentDir, defaults.WorkspaceFilename)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialServer(workspaceDir)
+	if err != nil {
+		conn, err = startServer(workspaceDir, defaults.WorkspaceFilename)
+		if err != nil {
+			return err
+		}
+	}
+
+	defer conn.Close()
+	request := serverRequest{Dir: defaults.CurrentDir, Env: os.Environ(), Args: arguments}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var response serverResponse
+		if err := decoder.Decode(&response); err != nil {
+			return errors.New(fmt.Sprintf("Lost connection to the jbuild server: %s", err))
+		}
+
+		output.Write(response.Output)
+		if response.Done {
+			if response.Error != "" {
+				return errors.New(response.Error)
+			}
+
+			return nil
+		}
+	}
+}
+
+// StopServer stops the server for the workspace in `args`, if there is one.
+func StopServer(args args.Args) error {
+	conn, err := dialServer(args.WorkspaceDir)
+	if err != nil {
+		fmt.Println("No jbuild server is running for this workspace")
+		return nil
+	}
+
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(serverRequest{Shutdown: true}); err != nil {
+		return err
+	}
+
+	var response serverResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return err
+	}
+
+	fmt.Println("Stopped the jbuild server")
+	return nil
+}
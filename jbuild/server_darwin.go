@@ -0,0 +1,27 @@
+package jbuild
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detachServer makes the server started by `cmd` keep running after the client
+// (and the terminal it runs in) exits.
+func detachServer(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// checkPrivateDir returns an error unless the directory described by `stat` is
+// owned by the current user and can't be used by anyone else.
+func checkPrivateDir(stat os.FileInfo) error {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok && int(sys.Uid) != os.Getuid() {
+		return errors.New(fmt.Sprintf("it is owned by uid %d", sys.Uid))
+	} else if stat.Mode().Perm()&0077 != 0 {
+		return errors.New(fmt.Sprintf("it has mode %s, not drwx------", stat.Mode()))
+	}
+
+	return nil
+}
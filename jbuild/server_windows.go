@@ -0,0 +1,18 @@
+package jbuild
+
+import (
+	"os"
+	"os/exec"
+)
+
+// detachServer makes the server started by `cmd` keep running after the client
+// exits, which Windows does by default.
+func detachServer(cmd *exec.Cmd) {
+}
+
+// checkPrivateDir returns an error unless the directory described by `stat` can
+// only be used by the current user. Windows doesn't have Unix permissions; the
+// directory is in the user's profile, which is private by default.
+func checkPrivateDir(stat os.FileInfo) error {
+	return nil
+}
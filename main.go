@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/command"
 	"github.com/jeshuam/jbuild/jbuild"
 	"github.com/op/go-logging"
 )
@@ -21,6 +22,26 @@ func main() {
 		log.Fatalf("Error: %s", err)
 	}
 
+	// Workers don't need a workspace, as they only run what they are sent.
+	if flag.Arg(0) == "worker" {
+		defaultArgs := args.DefaultArgs()
+		if err := command.RunWorker(&defaultArgs, os.Stdout); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+
+		return
+	}
+
+	// With --server, the command is run by the workspace's server instead.
+	if defaultArgs := args.DefaultArgs(); defaultArgs.Server && jbuild.UsesServer(defaultArgs, flag.Arg(0)) {
+		defaultArgs.CurrentDir = cwd
+		if err := jbuild.RunOnServer(defaultArgs, os.Args[1:], os.Stdout); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+
+		return
+	}
+
 	// Load flags.
 	programArgs, err := args.Load(cwd, nil)
 	if err != nil {
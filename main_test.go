@@ -6,16 +6,29 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jeshuam/jbuild/args"
+	"github.com/jeshuam/jbuild/command"
 	"github.com/jeshuam/jbuild/common"
 	"github.com/jeshuam/jbuild/config"
 	"github.com/jeshuam/jbuild/config/cc"
+	"github.com/jeshuam/jbuild/config/util"
 	"github.com/jeshuam/jbuild/jbuild"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -43,6 +56,28 @@ func runBinary(binary string) (string, error) {
 	return out.String(), err
 }
 
+// runCapturingStdout runs jbuild with `cmdArgs`, returning what it printed to
+// stdout.
+func runCapturingStdout(t *testing.T, args args.Args, cmdArgs []string) (string, error) {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	defer reader.Close()
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan string)
+	go func() {
+		var out bytes.Buffer
+		out.ReadFrom(reader)
+		output <- out.String()
+	}()
+
+	err = jbuild.JBuildRun(args, cmdArgs)
+	os.Stdout = stdout
+	writer.Close()
+	return <-output, err
+}
+
 func setupTest(t *testing.T, testDir string, baseArgs *args.Args) args.Args {
 	args, err := args.Load(filepath.Join(cwd, "test", testDir), baseArgs)
 	require.NoError(t, err)
@@ -53,6 +88,9 @@ func setupTest(t *testing.T, testDir string, baseArgs *args.Args) args.Args {
 	return args
 }
 
+// listOutputFiles returns the files built into the output directory, and the
+// path of the binary `binaryName`. Runfiles trees and the files jbuild writes
+// for itself (like the runfiles library) are left out.
 func listOutputFiles(t *testing.T, args *args.Args, binaryName string) ([]string, string) {
 	files, err := config.Glob(filepath.Join(args.OutputDir, "**", "*"))
 	require.NoError(t, err)
@@ -60,8 +98,12 @@ func listOutputFiles(t *testing.T, args *args.Args, binaryName string) ([]string
 	fileNames := make([]string, 0, len(files))
 	binary := ""
 	for _, filePath := range files {
+		filePathRel, _ := filepath.Rel(args.OutputDir, filePath)
+		if strings.HasPrefix(filePathRel, ".jbuild-") || strings.Contains(filePathRel, ".runfiles"+string(filepath.Separator)) {
+			continue
+		}
+
 		if !common.IsDir(filePath) {
-			filePathRel, _ := filepath.Rel(args.OutputDir, filePath)
 			fileNames = append(fileNames, filePathRel)
 			if filePathRel == cc.BinaryName(binaryName) {
 				binary = filePath
@@ -145,6 +187,13 @@ func Test03CppMultilibrary(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "PASSED", output)
 
+	// Static libraries are linked in dependency order, however long the chain of
+	// libraries is.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":chain"}))
+	output, err = runBinary(filepath.Join(args.OutputDir, cc.BinaryName("chain")))
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
 	// Now, cleanup the output directory.
 	jbuildClean(t, args)
 }
@@ -518,3 +567,1333 @@ func Test19ExternalLibraryWithIncludedBUILDFile(t *testing.T) {
 	// Now, cleanup the output directory.
 	jbuildClean(t, args)
 }
+
+func Test20ExternalArchive(t *testing.T) {
+	// Set the current directory.
+	defaultArgs := args.DefaultArgs()
+	defaultArgs.CleanExternalRepos = true
+	args := setupTest(t, filepath.Join("20_external_archive"), &defaultArgs)
+
+	// Build up the command-line.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+
+	// Make sure the output is valid.
+	fileNames, binary := listOutputFiles(t, &args, "hello_world")
+	require.Len(t, fileNames, 4)
+	assert.Contains(t, fileNames, "main.cc.o")
+	assert.Contains(t, fileNames, filepath.Join("third_party", "mathlib", "passed.cc.o"))
+	require.Contains(t, fileNames, cc.BinaryName("hello_world"))
+
+	// The archive should have been cached by its digest.
+	assert.True(t, common.FileExists(filepath.Join(
+		args.ExternalRepoDir, ".cache", "downloads",
+		"a6d12827cee810287066f47a2630f25d325b3974899e1e06b3b33be584208074")))
+
+	// Run the binary and get the output.
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test21ExternalLocalPath(t *testing.T) {
+	// Set the current directory.
+	defaultArgs := args.DefaultArgs()
+	defaultArgs.CleanExternalRepos = true
+	args := setupTest(t, filepath.Join("21_external_local_path", "workspace"), &defaultArgs)
+
+	// Build up the command-line.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+
+	// Make sure the output is valid.
+	fileNames, binary := listOutputFiles(t, &args, "hello_world")
+	require.Len(t, fileNames, 4)
+	assert.Contains(t, fileNames, "main.cc.o")
+	assert.Contains(t, fileNames, filepath.Join("third_party", "shared", "passed.cc.o"))
+	require.Contains(t, fileNames, cc.BinaryName("hello_world"))
+
+	// Run the binary and get the output.
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	// Local repos aren't frozen, so touching their BUILD file should cause the
+	// library to be rebuilt.
+	past := time.Now().Add(-time.Hour)
+	for _, fileName := range fileNames {
+		outputFile := filepath.Join(args.OutputDir, fileName)
+		require.NoError(t, os.Chtimes(outputFile, past, past))
+	}
+
+	testDir := filepath.Join(cwd, "test", "21_external_local_path")
+	older := past.Add(-time.Hour)
+	for _, srcFile := range []string{
+		"workspace/WORKSPACE", "workspace/BUILD", "workspace/main.cc",
+		"shared-lib/passed.cc", "shared-lib/passed.h"} {
+		srcPath := filepath.Join(testDir, filepath.FromSlash(srcFile))
+		require.NoError(t, os.Chtimes(srcPath, older, older))
+	}
+
+	buildFile := filepath.Join(testDir, "shared-lib", "BUILD")
+	require.NoError(t, os.Chtimes(buildFile, time.Now(), time.Now()))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+
+	library := filepath.Join(args.OutputDir, "third_party", "shared", cc.LibraryName("shared"))
+	libraryStat, err := os.Stat(library)
+	require.NoError(t, err)
+	assert.True(t, libraryStat.ModTime().After(past))
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test22VendorExternals(t *testing.T) {
+	// Use a fresh external repo directory, so nothing has been fetched yet.
+	externalRepoDir, err := ioutil.TempDir("", "jbuild-external")
+	require.NoError(t, err)
+	defer os.RemoveAll(externalRepoDir)
+
+	defaultArgs := args.DefaultArgs()
+	defaultArgs.ExternalRepoDir = externalRepoDir
+	defaultArgs.Offline = true
+	offlineArgs := setupTest(t, filepath.Join("16_external_library"), &defaultArgs)
+
+	// Git repos can't be cloned offline, so this should fail.
+	err = jbuild.JBuildRun(offlineArgs, []string{"build", ":hello_world"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "offline")
+
+	// Vendor the externals.
+	defaultArgs.Offline = false
+	args := setupTest(t, filepath.Join("22_vendor_externals"), &defaultArgs)
+	defer os.RemoveAll(args.VendorDir)
+	require.NoError(t, jbuild.JBuildRun(args, []string{"vendor"}))
+	assert.True(t, common.FileExists(filepath.Join(args.VendorDir, "mathlib", "passed.cc")))
+
+	// Throw away everything that was fetched, and build offline again. This time
+	// the vendored copy should be used.
+	require.NoError(t, os.RemoveAll(externalRepoDir))
+	defaultArgs.Offline = true
+	offlineArgs = setupTest(t, filepath.Join("22_vendor_externals"), &defaultArgs)
+	require.NoError(t, jbuild.JBuildRun(offlineArgs, []string{"build", ":hello_world"}))
+
+	// Make sure the output is valid.
+	fileNames, binary := listOutputFiles(t, &offlineArgs, "hello_world")
+	require.Len(t, fileNames, 4)
+	assert.Contains(t, fileNames, "main.cc.o")
+	require.Contains(t, fileNames, cc.BinaryName("hello_world"))
+
+	// Run the binary and get the output.
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, offlineArgs)
+}
+
+func Test23TransitiveExternals(t *testing.T) {
+	// Set the current directory.
+	defaultArgs := args.DefaultArgs()
+	defaultArgs.CleanExternalRepos = true
+	args := setupTest(t, filepath.Join("23_transitive_externals", "workspace"), &defaultArgs)
+
+	// //third_party/shared is only mentioned in the WORKSPACE of //third_party/app.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+
+	fileNames, binary := listOutputFiles(t, &args, "hello_world")
+	assert.Contains(t, fileNames, filepath.Join("third_party", "app", "app.cc.o"))
+	assert.Contains(t, fileNames, filepath.Join("third_party", "shared", "passed.cc.o"))
+	require.Contains(t, fileNames, cc.BinaryName("hello_world"))
+
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	shared := args.ExternalRepos["//third_party/shared"]
+	require.NotNil(t, shared)
+	assert.Equal(t, []string{"//third_party/app"}, shared.RequestedBy)
+	jbuildClean(t, args)
+
+	// Two repos asking for different versions of the same repo is an error...
+	conflictArgs := setupTest(t, filepath.Join("23_transitive_externals", "conflict", "workspace"), &defaultArgs)
+	err = jbuild.JBuildRun(conflictArgs, []string{"build", ":hello_world"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "//third_party/shared")
+
+	// ...unless the WORKSPACE chooses one.
+	pinnedArgs := setupTest(t, filepath.Join("23_transitive_externals", "conflict", "pinned"), &defaultArgs)
+	require.NoError(t, jbuild.JBuildRun(pinnedArgs, []string{"build", ":hello_world"}))
+
+	_, binary = listOutputFiles(t, &pinnedArgs, "hello_world")
+	output, err = runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+	assert.Equal(t,
+		[]string{"//", "//third_party/first", "//third_party/second"},
+		pinnedArgs.ExternalRepos["//third_party/shared"].RequestedBy)
+
+	// 'jbuild externals' shows the resolved tree, and who requested each repo.
+	output, err = runCapturingStdout(t, pinnedArgs, []string{"externals"})
+	require.NoError(t, err)
+	first := "path:" + filepath.Join("..", "first-lib")
+	second := "path:" + filepath.Join("..", "second-lib")
+	pinned := "path:" + filepath.Join("..", "..", "shared-lib")
+	assert.Equal(t, strings.Join([]string{
+		"//third_party/first  " + first,
+		"  //third_party/shared  " + pinned,
+		"//third_party/second  " + second,
+		"  //third_party/shared  " + pinned,
+		"//third_party/shared  " + pinned,
+		"",
+		"//third_party/first  " + first + "  requested by WORKSPACE",
+		"//third_party/second  " + second + "  requested by WORKSPACE",
+		"//third_party/shared  " + pinned +
+			"  requested by WORKSPACE, //third_party/first, //third_party/second",
+		"",
+	}, "\n"), output)
+
+	// The version //third_party/second asked for isn't used, so isn't shown.
+	assert.NotContains(t, output, "other-shared-lib")
+
+	jbuildClean(t, pinnedArgs)
+}
+
+func Test24StarlarkBuild(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "24_starlark_build", nil)
+
+	// Build up the command-line.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+
+	// Make sure the output is valid.
+	fileNames, binary := listOutputFiles(t, &args, "hello_world")
+	assert.Contains(t, fileNames, filepath.Join("src", "main.cc.o"))
+	assert.Contains(t, fileNames, "greeting.cc.o")
+	require.Contains(t, fileNames, cc.BinaryName("hello_world"))
+
+	// Run the binary and get the output.
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test25StarlarkLoad(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "25_starlark_load", nil)
+
+	// Build up the command-line.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+
+	// Make sure the output is valid.
+	fileNames, binary := listOutputFiles(t, &args, "hello_world")
+	assert.Contains(t, fileNames, "passed.cc.o")
+	require.Contains(t, fileNames, cc.BinaryName("hello_world"))
+
+	// Run the binary and get the output.
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test26BuildValidation(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "26_build_validation", nil)
+
+	// Every problem with the target is reported, along with where it is.
+	err := jbuild.JBuildRun(args, []string{"build", ":hello_world"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(),
+		"BUILD:3:3: field 'srcs' of //:hello_world expects a list of strings, got a string")
+	assert.Contains(t, err.Error(),
+		"BUILD:4:3: unknown field 'dpes' in //:hello_world; did you mean 'deps'?")
+
+	// Problems found while loading a field also point at the field.
+	err = jbuild.JBuildRun(args, []string{"build", ":lib"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "BUILD:9:3: field 'srcs' of //:lib: Could not identify type of spec 'missing.cc'")
+
+	// The same goes for Starlark BUILD files.
+	err = jbuild.JBuildRun(args, []string{"build", "//starlark:hello_world"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), filepath.Join("starlark", "BUILD")+
+		":4:5: field 'link_flags' of //starlark:hello_world expects a list of strings, got a string")
+}
+
+func Test27FmtAndLint(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "27_fmt_and_lint", nil)
+
+	// Formatting rewrites the BUILD file in place, so put it back afterwards.
+	buildFile := filepath.Join(args.WorkspaceDir, "fmt", "BUILD")
+	original, err := ioutil.ReadFile(buildFile)
+	require.NoError(t, err)
+	defer ioutil.WriteFile(buildFile, original, 0644)
+
+	// Check mode reports the unformatted file without changing it.
+	args.FormatCheck = true
+	require.Error(t, jbuild.JBuildRun(args, []string{"fmt", "fmt"}))
+	content, err := ioutil.ReadFile(buildFile)
+	require.NoError(t, err)
+	assert.Equal(t, string(original), string(content))
+
+	// Formatting fixes it.
+	args.FormatCheck = false
+	require.NoError(t, jbuild.JBuildRun(args, []string{"fmt", "fmt"}))
+	expected, err := ioutil.ReadFile(buildFile + ".formatted")
+	require.NoError(t, err)
+	content, err = ioutil.ReadFile(buildFile)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(content))
+
+	args.FormatCheck = true
+	require.NoError(t, jbuild.JBuildRun(args, []string{"fmt", "fmt"}))
+
+	// Linting finds every problem in the workspace.
+	err = jbuild.JBuildRun(args, []string{"lint"})
+	require.Error(t, err)
+	assert.Equal(t, "Found 4 lint problem(s)", err.Error())
+
+	specs, err := config.MakeTargetSpec(&args, "//...", "", args.WorkspaceDir)
+	require.NoError(t, err)
+	problems := make([]string, 0)
+	for _, problem := range config.Lint(&args, specs) {
+		problems = append(problems, problem.String())
+	}
+
+	assert.Equal(t, []string{
+		"BUILD:3:3: //main.cc is in the srcs of both //:lib and //:main",
+		"BUILD:3:3: glob 'gen/*.cc' in field 'srcs' of //:main doesn't match any files",
+		"BUILD:4:3: dep //:base of //:main is redundant; it is already a dependency of //:lib",
+		"BUILD:18:1: filegroup //:unused_files isn't used by any target",
+	}, problems)
+}
+
+func Test28GlobExclusions(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "28_glob_exclusions", nil)
+
+	// Excluded files and files in other packages (sub/ and starlark/) are skipped.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+	fileNames, binary := listOutputFiles(t, &args, "hello_world")
+	require.Len(t, fileNames, 3)
+	assert.Contains(t, fileNames, "main.cc.o")
+	assert.Contains(t, fileNames, filepath.Join("lib", "lib.cc.o"))
+	require.Contains(t, fileNames, cc.BinaryName("hello_world"))
+
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	// Matches are always in the same order.
+	specs, err := config.MakeTargetSpec(&args, ":hello_world", "", args.WorkspaceDir)
+	require.NoError(t, err)
+	srcs := make([]string, 0)
+	for _, src := range specs[0].Target().(*cc.Target).Srcs {
+		srcs = append(srcs, src.String())
+	}
+
+	assert.Equal(t, []string{"//lib/lib.cc", "//main.cc"}, srcs)
+
+	// Building again without changing anything doesn't relink.
+	binaryStat, err := os.Stat(binary)
+	require.NoError(t, err)
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+	newBinaryStat, err := os.Stat(binary)
+	require.NoError(t, err)
+	assert.Equal(t, binaryStat.ModTime(), newBinaryStat.ModTime())
+
+	// New files are picked up, even if they are older than the binary.
+	past := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(binary, past, past))
+	extraFile := filepath.Join(args.WorkspaceDir, "lib", "extra.cc")
+	require.NoError(t, ioutil.WriteFile(extraFile, []byte("int extra() { return 1; }\n"), 0644))
+	defer os.Remove(extraFile)
+	older := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(extraFile, older, older))
+
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+	fileNames, _ = listOutputFiles(t, &args, "hello_world")
+	assert.Contains(t, fileNames, filepath.Join("lib", "extra.cc.o"))
+	binaryStat, err = os.Stat(binary)
+	require.NoError(t, err)
+	assert.True(t, binaryStat.ModTime().After(past))
+
+	// Removing a file relinks without it.
+	require.NoError(t, os.Remove(extraFile))
+	require.NoError(t, os.Chtimes(binary, past, past))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":hello_world"}))
+	binaryStat, err = os.Stat(binary)
+	require.NoError(t, err)
+	assert.True(t, binaryStat.ModTime().After(past))
+
+	// Starlark globs can exclude files too.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//starlark:hello_world"}))
+	_, binary = listOutputFiles(t, &args, filepath.Join("starlark", "hello_world"))
+	output, err = runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test29Visibility(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "29_visibility", nil)
+
+	// Public targets can be used anywhere, and everything else can be used where
+	// its visibility says.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//:main"}))
+	_, binary := listOutputFiles(t, &args, "main")
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//lib/sub:uses_internal"}))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//friend/deep:uses_secret"}))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//other:uses_secret"}))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//partner:uses_secret"}))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//starlark:main"}))
+
+	// Package groups aren't targets, so they are skipped when expanding targets.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//groups:all"}))
+
+	// Depending on a target which isn't visible names both ends of the dependency.
+	badBuild := filepath.Join("bad", "BUILD")
+	err = jbuild.JBuildRun(args, []string{"build", "//bad:uses_internal"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), badBuild+":4:3: field 'deps' of //bad:uses_internal: "+
+		"//bad:uses_internal depends on //lib:internal, which isn't visible to it "+
+		"(visibility = [//lib:__subpackages__])")
+
+	err = jbuild.JBuildRun(args, []string{"build", "//bad:uses_secret"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "//bad:uses_secret depends on //groups:secret, which isn't visible to it")
+
+	err = jbuild.JBuildRun(args, []string{"build", "//bad:uses_hidden"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "//bad:uses_hidden depends on //starlark:hidden, which isn't visible to it")
+
+	err = jbuild.JBuildRun(args, []string{"build", "//bad:uses_group"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "//groups:friends is a package group, not a target")
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test30LayeringCheck(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "30_layering_check", nil)
+	args.LayeringCheck = true
+
+	// Headers from direct deps can be included, as can the headers they include.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	_, binary := listOutputFiles(t, &args, "main")
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "PASSED", output)
+
+	// Including a header from a transitive dep suggests the dep to add.
+	err = jbuild.JBuildRun(args, []string{"build", ":uses_transitive_dep"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "//uses_transitive_dep.cc includes \"base.h\", which isn't in the "+
+		"hdrs of //:uses_transitive_dep or any of its direct deps; add //:base to its deps")
+
+	// The object isn't kept, so the problem is reported until it is fixed.
+	assert.False(t, common.FileExists(filepath.Join(args.OutputDir, "uses_transitive_dep.cc.o")))
+	require.Error(t, jbuild.JBuildRun(args, []string{"build", ":uses_transitive_dep"}))
+
+	// Headers which aren't in any target can't be included at all.
+	err = jbuild.JBuildRun(args, []string{"build", ":uses_undeclared_header"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "//uses_undeclared_header.cc includes \"stray.h\", which isn't in the "+
+		"hdrs of //:uses_undeclared_header or any of its direct deps; add it to the hdrs of a target")
+
+	// The check is opt-in.
+	args.LayeringCheck = false
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":uses_transitive_dep"}))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":uses_undeclared_header"}))
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test31DepsCheck(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "31_deps_check", nil)
+
+	// Fixing the deps rewrites the BUILD file in place, so put it back afterwards.
+	buildFile := filepath.Join(args.WorkspaceDir, "BUILD")
+	original, err := ioutil.ReadFile(buildFile)
+	require.NoError(t, err)
+	defer ioutil.WriteFile(buildFile, original, 0644)
+
+	// Every target in the workspace is checked by default.
+	err = jbuild.JBuildRun(args, []string{"deps-check"})
+	require.Error(t, err)
+	assert.Equal(t, "Found 4 dependency problem(s)", err.Error())
+
+	// Deps can be found to be used by the headers included or the symbols used.
+	specs, err := config.MakeTargetSpec(&args, ":main", "", args.WorkspaceDir)
+	require.NoError(t, err)
+	report, err := specs[0].Target().(*cc.Target).CheckDeps(&args)
+	require.NoError(t, err)
+	require.Len(t, report.Unused, 1)
+	assert.Equal(t, "//:unused", report.Unused[0].String())
+	require.Len(t, report.Missing, 1)
+	assert.Equal(t, "//:base", report.Missing[0].String())
+	assert.Equal(t, "includes \"base.h\"", report.Reasons["//:base"])
+
+	specs, err = config.MakeTargetSpec(&args, ":symbol_user", "", args.WorkspaceDir)
+	require.NoError(t, err)
+	report, err = specs[0].Target().(*cc.Target).CheckDeps(&args)
+	require.NoError(t, err)
+	assert.Len(t, report.Unused, 0)
+	require.Len(t, report.Missing, 1)
+	assert.Equal(t, "uses 'base()'", report.Reasons["//:base"])
+
+	require.NoError(t, jbuild.JBuildRun(args, []string{"deps-check", ":clean"}))
+
+	// Fixing the deps rewrites the BUILD file.
+	args.FixDeps = true
+	require.NoError(t, jbuild.JBuildRun(args, []string{"deps-check", ":main", ":symbol_user"}))
+	content, err := ioutil.ReadFile(buildFile)
+	require.NoError(t, err)
+	expected, err := ioutil.ReadFile(filepath.Join(args.WorkspaceDir, "BUILD.fixed"))
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(content))
+
+	args.FixDeps = false
+	require.NoError(t, jbuild.JBuildRun(args, []string{"deps-check", ":main", ":symbol_user"}))
+
+	// Starlark files can't be fixed automatically.
+	args.FixDeps = true
+	err = jbuild.JBuildRun(args, []string{"deps-check", "//starlark:main"})
+	require.Error(t, err)
+	assert.Equal(t, "Found 1 dependency problem(s)", err.Error())
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test32GenBuild(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "32_gen_build", nil)
+
+	// BUILD files are written (or rewritten) in place, so put them back afterwards.
+	existingBuildFile := filepath.Join(args.WorkspaceDir, "existing", "BUILD")
+	original, err := ioutil.ReadFile(existingBuildFile)
+	require.NoError(t, err)
+	defer ioutil.WriteFile(existingBuildFile, original, 0644)
+	defer os.Remove(filepath.Join(args.WorkspaceDir, "math", "BUILD"))
+	defer os.Remove(filepath.Join(args.WorkspaceDir, "app", "BUILD"))
+
+	// Libraries, binaries and tests are generated, with deps from their includes.
+	// Targets which are already there are left alone.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"gen-build"}))
+	contents := make(map[string]string)
+	for _, dir := range []string{"app", "existing", "math"} {
+		content, err := ioutil.ReadFile(filepath.Join(args.WorkspaceDir, dir, "BUILD"))
+		require.NoError(t, err)
+		expected, err := ioutil.ReadFile(filepath.Join(args.WorkspaceDir, dir, "BUILD.expected"))
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(content), dir)
+		contents[dir] = string(content)
+	}
+
+	// Starlark files are skipped.
+	content, err := ioutil.ReadFile(filepath.Join(args.WorkspaceDir, "starlark", "BUILD"))
+	require.NoError(t, err)
+	assert.Equal(t, "cc_library(name = \"starlark\", srcs = [\"starlark.cc\"])\n", string(content))
+
+	// Running it again doesn't change anything.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"gen-build", "."}))
+	for dir, expected := range contents {
+		content, err := ioutil.ReadFile(filepath.Join(args.WorkspaceDir, dir, "BUILD"))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content), dir)
+	}
+
+	// The generated targets build.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"test", "//math:add_test"}))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//app:main", "//existing"}))
+	_, binary := listOutputFiles(t, &args, "app/main")
+	require.NotEqual(t, "", binary)
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, world! 3\n", output)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test33ImportCompdb(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "33_import_compdb", nil)
+
+	// Compilation databases use absolute paths, so fill in the workspace.
+	template, err := ioutil.ReadFile(filepath.Join(args.WorkspaceDir, "compile_commands.json.in"))
+	require.NoError(t, err)
+	compdb := filepath.Join(args.WorkspaceDir, "compile_commands.json")
+	content := strings.Replace(string(template), "@WORKSPACE@", filepath.ToSlash(args.WorkspaceDir), -1)
+	require.NoError(t, ioutil.WriteFile(compdb, []byte(content), 0644))
+	defer os.Remove(compdb)
+
+	dirs := []string{"app", "src/math", "src/util", "tests"}
+	for _, dir := range dirs {
+		defer os.Remove(filepath.Join(args.WorkspaceDir, dir, "BUILD"))
+	}
+
+	// Sources are grouped by what they are linked into, then by CMake target and
+	// then by directory.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"import-compdb", "compile_commands.json"}))
+	contents := make(map[string]string)
+	for _, dir := range dirs {
+		content, err := ioutil.ReadFile(filepath.Join(args.WorkspaceDir, dir, "BUILD"))
+		require.NoError(t, err)
+		expected, err := ioutil.ReadFile(filepath.Join(args.WorkspaceDir, dir, "BUILD.expected"))
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(content), dir)
+		contents[dir] = string(content)
+	}
+
+	// Importing again doesn't change anything.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"import-compdb", compdb}))
+	for dir, expected := range contents {
+		content, err := ioutil.ReadFile(filepath.Join(args.WorkspaceDir, dir, "BUILD"))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content), dir)
+	}
+
+	// The imported targets build.
+	require.Error(t, jbuild.JBuildRun(args, []string{"import-compdb"}))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"test", "//tests:strings_test"}))
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//app:hello"}))
+	_, binary := listOutputFiles(t, &args, "app/hello")
+	require.NotEqual(t, "", binary)
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, world! 3\n", output)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test34Watch(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "34_watch", nil)
+	args.Watch = true
+	args.WatchBuilds = 4
+
+	// The files are changed while watching, so put them back afterwards.
+	libFile := filepath.Join(args.WorkspaceDir, "lib.cc")
+	buildFile := filepath.Join(args.WorkspaceDir, "BUILD")
+	newPartsDir := filepath.Join(args.WorkspaceDir, "parts", "more")
+	defer os.RemoveAll(newPartsDir)
+	for _, file := range []string{libFile, buildFile} {
+		original, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		defer ioutil.WriteFile(file, original, 0644)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- jbuild.JBuildRun(args, []string{"build", ":main", ":other"})
+	}()
+
+	// Waits for the main binary to print `expected`.
+	binary := filepath.Join(args.OutputDir, cc.BinaryName("main"))
+	waitForOutput := func(expected string) {
+		deadline := time.Now().Add(30 * time.Second)
+		for time.Now().Before(deadline) {
+			if output, err := runBinary(binary); err == nil && output == expected {
+				return
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		require.Fail(t, "Timed out waiting for the output "+expected)
+	}
+
+	waitForOutput("1\n")
+	other := filepath.Join(args.OutputDir, cc.BinaryName("other"))
+	otherStat, err := os.Stat(other)
+	require.NoError(t, err)
+
+	// Changing a source file only rebuilds the targets which use it.
+	require.NoError(t, ioutil.WriteFile(libFile, []byte("#include \"lib.h\"\n\nint lib() {\n  return 2;\n}\n"), 0644))
+	waitForOutput("2\n")
+	newOtherStat, err := os.Stat(other)
+	require.NoError(t, err)
+	assert.Equal(t, otherStat.ModTime(), newOtherStat.ModTime())
+
+	// Changing a BUILD file loads the targets again.
+	content, err := ioutil.ReadFile(buildFile)
+	require.NoError(t, err)
+	content = []byte(strings.Replace(string(content), "\"main.cc\"", "\"main_offset.cc\"", 1))
+	require.NoError(t, ioutil.WriteFile(buildFile, content, 0644))
+	waitForOutput("12\n")
+
+	// Adding a directory where a "**" glob searches loads the targets again, so
+	// that the files in it are built.
+	require.NoError(t, os.MkdirAll(newPartsDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(newPartsDir, "extra.cc"), []byte("int extra_part() {\n  return 0;\n}\n"), 0644))
+	extraObject := filepath.Join(args.OutputDir, "parts", "more", "extra.cc.o")
+	for deadline := time.Now().Add(30 * time.Second); !common.FileExists(extraObject); {
+		require.True(t, time.Now().Before(deadline), "Timed out waiting for parts/more/extra.cc to be built")
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(30 * time.Second):
+		require.Fail(t, "Watching didn't stop")
+	}
+
+	// Watching only works when building or testing.
+	args.WatchBuilds = 1
+	require.NoError(t, jbuild.JBuildRun(args, []string{"test", ":main"}))
+	require.Error(t, jbuild.JBuildRun(args, []string{"run", ":main"}))
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test35Server(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "35_server", nil)
+
+	// The BUILD file is changed while serving, so put it back afterwards.
+	buildFile := filepath.Join(args.WorkspaceDir, "BUILD")
+	original, err := ioutil.ReadFile(buildFile)
+	require.NoError(t, err)
+	defer ioutil.WriteFile(buildFile, original, 0644)
+
+	done := make(chan error)
+	go func() {
+		done <- jbuild.JBuildRun(args, []string{"serve"})
+	}()
+
+	// The socket is kept where only this user can connect to it.
+	socket, err := jbuild.ServerSocket(args.WorkspaceDir)
+	require.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		stat, err := os.Stat(filepath.Dir(socket))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); !common.FileExists(socket); {
+		require.True(t, time.Now().Before(deadline), "Timed out waiting for the server")
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Runs a command on the server, returning its output.
+	runOnServer := func(arguments ...string) (string, error) {
+		var output bytes.Buffer
+		err := jbuild.RunOnServer(args, arguments, &output)
+		return output.String(), err
+	}
+
+	binary := filepath.Join(args.OutputDir, cc.BinaryName("main"))
+	_, err = runOnServer("build", ":main")
+	require.NoError(t, err)
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "1\n", output)
+
+	// The targets stay loaded between commands.
+	spec := util.SpecCache["//:main"]
+	require.NotNil(t, spec)
+	_, err = runOnServer("build", ":main")
+	require.NoError(t, err)
+	assert.True(t, spec == util.SpecCache["//:main"])
+
+	// Changing a BUILD file loads the targets again.
+	content := strings.Replace(string(original), "\"main.cc\"", "\"other_main.cc\"", 1)
+	require.NoError(t, ioutil.WriteFile(buildFile, []byte(content), 0644))
+	_, err = runOnServer("build", ":main")
+	require.NoError(t, err)
+	assert.False(t, spec == util.SpecCache["//:main"])
+	output, err = runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "2\n", output)
+
+	// So does vendoring the external repos, which adds a vendor manifest, and
+	// removing them again.
+	require.NoError(t, os.MkdirAll(args.VendorDir, 0755))
+	defer os.RemoveAll(args.VendorDir)
+	spec = util.SpecCache["//:main"]
+	manifest := filepath.Join(args.VendorDir, "vendor.workspace")
+	require.NoError(t, ioutil.WriteFile(manifest, []byte("{\"external\": {}}"), 0644))
+	_, err = runOnServer("build", ":main")
+	require.NoError(t, err)
+	assert.False(t, spec == util.SpecCache["//:main"])
+
+	spec = util.SpecCache["//:main"]
+	require.NoError(t, os.Remove(manifest))
+	_, err = runOnServer("build", ":main")
+	require.NoError(t, err)
+	assert.False(t, spec == util.SpecCache["//:main"])
+
+	// Errors are passed back to the client, along with the output.
+	output, err = runOnServer("build", ":missing")
+	require.Error(t, err)
+	output, err = runOnServer("bogus")
+	require.Error(t, err)
+	assert.Contains(t, output, "Usage: jbuild")
+
+	// Shutting the server down stops it.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"shutdown"}))
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "The server didn't stop")
+	}
+
+	assert.False(t, common.FileExists(socket))
+
+	// A socket directory which others can use isn't trusted.
+	if runtime.GOOS != "windows" {
+		runtimeDir, err := ioutil.TempDir("", "jbuild-runtime")
+		require.NoError(t, err)
+		defer os.RemoveAll(runtimeDir)
+		require.NoError(t, os.Mkdir(filepath.Join(runtimeDir, "jbuild"), 0777))
+		require.NoError(t, os.Chmod(filepath.Join(runtimeDir, "jbuild"), 0777))
+		defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+		os.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+		_, err = jbuild.ServerSocket(args.WorkspaceDir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "drwx------")
+	}
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test36Profile(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "36_profile", nil)
+	args.Profile = filepath.Join(args.OutputDir, "..", "profile.json")
+	defer os.Remove(args.Profile)
+
+	// Finds the events in `profile` for `target` of the given kind.
+	findEvents := func(profile *common.Profile, target, kind string) []common.ProfileEvent {
+		events := make([]common.ProfileEvent, 0)
+		for _, event := range profile.Events {
+			if event.Target == target && event.Kind == kind {
+				events = append(events, event)
+			}
+		}
+
+		return events
+	}
+
+	// Every command run is recorded, along with the worker which ran it.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"test", ":lib_test", ":main"}))
+	profile, err := common.ReadProfile(args.Profile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"//:lib"}, profile.Deps["//:lib_test"])
+
+	compiles := findEvents(profile, "//:lib", common.CompileAction)
+	require.Len(t, compiles, 1)
+	assert.Equal(t, "//lib.cc", compiles[0].File)
+	assert.False(t, compiles[0].Cached)
+	assert.True(t, compiles[0].Slot >= 1 && compiles[0].Slot <= args.Threads)
+
+	links := findEvents(profile, "//:lib_test", common.LinkAction)
+	require.Len(t, links, 1)
+	assert.True(t, links[0].Start >= compiles[0].End())
+
+	tests := findEvents(profile, "//:lib_test", common.TestAction)
+	require.Len(t, tests, 1)
+	assert.False(t, tests[0].Cached)
+	assert.True(t, tests[0].Start >= links[0].End())
+
+	// The file can be loaded by chrome://tracing.
+	content, err := ioutil.ReadFile(args.Profile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "\"traceEvents\"")
+	assert.Contains(t, string(content), "\"ph\": \"X\"")
+
+	// Nothing needs to be built the second time, so only the test is run.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"test", ":lib_test", ":main"}))
+	profile, err = common.ReadProfile(args.Profile)
+	require.NoError(t, err)
+	require.NotEmpty(t, profile.Events)
+	for _, event := range profile.Events {
+		assert.Equal(t, event.Kind != common.TestAction, event.Cached, event.Name())
+	}
+
+	// The profile can be analyzed.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"analyze-profile", args.Profile}))
+	require.Error(t, jbuild.JBuildRun(args, []string{"analyze-profile"}))
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test37BuildEvents(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "37_build_events", nil)
+	args.BuildEventJsonFile = filepath.Join(args.WorkspaceDir, "events.json")
+	defer os.Remove(args.BuildEventJsonFile)
+
+	// Reads the events written by the last command.
+	readEvents := func() []common.BuildEvent {
+		content, err := ioutil.ReadFile(args.BuildEventJsonFile)
+		require.NoError(t, err)
+
+		events := make([]common.BuildEvent, 0)
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			var event common.BuildEvent
+			require.NoError(t, json.Unmarshal([]byte(line), &event), line)
+			events = append(events, event)
+		}
+
+		return events
+	}
+
+	// Finds the first event in `events` of the given type, for the given target
+	// and (if set) kind of action.
+	findEvent := func(events []common.BuildEvent, eventType, target, kind string) int {
+		for i, event := range events {
+			if event.Type == eventType && event.Target == target && (kind == "" || event.Kind == kind) {
+				return i
+			}
+		}
+
+		require.Fail(t, "Missing event", "%s %s %s", eventType, target, kind)
+		return -1
+	}
+
+	// Each target is configured, then its actions run, and then it is completed.
+	// A failing test fails the command, but every test is still run.
+	err := jbuild.JBuildRun(args, []string{"test", ":lib_test", ":failing_test"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 2 tests failed: //:failing_test")
+	events := readEvents()
+	require.True(t, len(events) > 2)
+	assert.Equal(t, common.BuildStartedEvent, events[0].Type)
+	assert.Equal(t, []string{"test", ":lib_test", ":failing_test"}, events[0].Arguments)
+	assert.Equal(t, common.BuildFinishedEvent, events[len(events)-1].Type)
+	require.NotNil(t, events[len(events)-1].ExitCode)
+	assert.Equal(t, 1, *events[len(events)-1].ExitCode)
+	assert.False(t, *events[len(events)-1].Success)
+
+	configured := findEvent(events, common.TargetConfiguredEvent, "//:lib", "")
+	assert.Equal(t, "c++/library", events[configured].TargetType)
+	started := findEvent(events, common.ActionStartedEvent, "//:lib", common.CompileAction)
+	assert.NotEmpty(t, events[started].Command)
+	finished := findEvent(events, common.ActionFinishedEvent, "//:lib", common.CompileAction)
+	assert.True(t, *events[finished].Success)
+	completed := findEvent(events, common.TargetCompletedEvent, "//:lib", "")
+	assert.True(t, *events[completed].Success)
+	assert.True(t, configured < started && started < finished && finished < completed)
+
+	// Test results are included, whether they pass or not.
+	passed := findEvent(events, common.TestResultEvent, "//:lib_test", "")
+	assert.True(t, *events[passed].Success)
+	assert.Equal(t, 1, events[passed].Run)
+	failed := findEvent(events, common.TestResultEvent, "//:failing_test", "")
+	assert.False(t, *events[failed].Success)
+	assert.Contains(t, events[failed].Output, "Something went wrong")
+
+	// The command only succeeds if every test passes.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"test", ":lib_test"}))
+	events = readEvents()
+	assert.Equal(t, common.BuildFinishedEvent, events[len(events)-1].Type)
+	assert.Equal(t, 0, *events[len(events)-1].ExitCode)
+	assert.True(t, *events[len(events)-1].Success)
+
+	// Genrule commands are actions too.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":gen_message"}))
+	events = readEvents()
+	genrule := findEvent(events, common.ActionFinishedEvent, "//:gen_message", common.GenruleAction)
+	assert.True(t, *events[genrule].Success)
+	assert.Equal(t, "sed", events[genrule].Command[0])
+
+	// Failures include the output of the command which failed.
+	require.Error(t, jbuild.JBuildRun(args, []string{"build", ":broken"}))
+	events = readEvents()
+	finished = findEvent(events, common.ActionFinishedEvent, "//:broken", common.CompileAction)
+	assert.False(t, *events[finished].Success)
+	assert.NotEmpty(t, events[finished].Output)
+	completed = findEvent(events, common.TargetCompletedEvent, "//:broken", "")
+	assert.False(t, *events[completed].Success)
+	assert.Equal(t, common.BuildFinishedEvent, events[len(events)-1].Type)
+	assert.Equal(t, 1, *events[len(events)-1].ExitCode)
+	assert.NotEmpty(t, events[len(events)-1].Error)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test38ResourceScheduling(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "38_resources", nil)
+	args.Threads = 4
+	args.LocalCpuResources = 4
+	args.LocalRamResources = 2048
+	args.Profile = filepath.Join(args.WorkspaceDir, "profile.json")
+	defer os.Remove(args.Profile)
+
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	output, err := runBinary(filepath.Join(args.OutputDir, cc.BinaryName("main")))
+	require.NoError(t, err)
+	assert.Equal(t, "", output)
+
+	// Actions which need more RAM together than is available never overlap. The
+	// heavy library needs all of it for each action.
+	profile, err := common.ReadProfile(args.Profile)
+	require.NoError(t, err)
+	ram := func(event common.ProfileEvent) float64 {
+		if event.Target == "//:heavy" {
+			return 2048
+		}
+
+		return common.DefaultResources[event.Kind].RAM
+	}
+
+	run := make([]common.ProfileEvent, 0)
+	for _, event := range profile.Events {
+		if !event.Cached {
+			run = append(run, event)
+		}
+	}
+
+	require.Len(t, run, 11)
+	for i, a := range run {
+		for _, b := range run[i+1:] {
+			if a.Start < b.End() && b.Start < a.End() {
+				assert.True(t, ram(a)+ram(b) <= 2048, "%s %s overlaps %s %s", a.Kind, a.Name(), b.Kind, b.Name())
+			}
+		}
+	}
+
+	// Resources are checked when loading targets.
+	problems := map[string]string{
+		"//bad:unknown_resource": "unknown resource 'gpu'",
+		"//bad:negative":         "'cpu' must be a positive number",
+		"//bad:not_numbers":      "expects an object of numbers",
+	}
+
+	for target, problem := range problems {
+		err := jbuild.JBuildRun(args, []string{"build", target})
+		require.Error(t, err, target)
+		assert.Contains(t, err.Error(), problem)
+	}
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test39RemoteExecution(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "39_remote", nil)
+	args.BuildEventJsonFile = filepath.Join(args.WorkspaceDir, "events.json")
+	defer os.Remove(args.BuildEventJsonFile)
+
+	// Start a worker on this machine.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	blobDir, err := ioutil.TempDir("", "jbuild-test-blobs")
+	require.NoError(t, err)
+	defer os.RemoveAll(blobDir)
+	go command.ServeWorker(&args, listener, blobDir)
+
+	// Returns the compile actions finished during the last command, and whether
+	// they ran remotely.
+	compiles := func() map[string]bool {
+		content, err := ioutil.ReadFile(args.BuildEventJsonFile)
+		require.NoError(t, err)
+
+		remote := make(map[string]bool)
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			var event common.BuildEvent
+			require.NoError(t, json.Unmarshal([]byte(line), &event), line)
+			if event.Type == common.ActionFinishedEvent && event.Kind == common.CompileAction {
+				assert.True(t, *event.Success, event.Output)
+				remote[event.File] = event.Remote
+			}
+		}
+
+		return remote
+	}
+
+	// Compiles are run by the worker, and the objects written locally.
+	args.RemoteExecutor = listener.Addr().String()
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	assert.Equal(t, map[string]bool{"//main.cc": true, "//lib/lib.cc": true}, compiles())
+	output, err := runBinary(filepath.Join(args.OutputDir, cc.BinaryName("main")))
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, remote world!\n", output)
+
+	// Compile errors from the worker are the result of the compile; it isn't
+	// run again locally.
+	err = jbuild.JBuildRun(args, []string{"build", ":broken"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not_declared")
+	content, err := ioutil.ReadFile(args.BuildEventJsonFile)
+	require.NoError(t, err)
+	brokenCompiles := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var event common.BuildEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &event), line)
+		if event.Type == common.ActionFinishedEvent && event.File == "//broken.cc" {
+			brokenCompiles++
+			assert.True(t, event.Remote)
+			assert.False(t, *event.Success)
+		}
+	}
+
+	assert.Equal(t, 1, brokenCompiles)
+
+	// A worker which returns files that weren't asked for isn't trusted; the
+	// compiles are run locally instead, and the extra files aren't written.
+	jbuildClean(t, args)
+	escaped := filepath.Join(filepath.Dir(args.WorkspaceDir), "escaped.txt")
+	defer os.Remove(escaped)
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: listener.Addr().String()})
+	proxy.ModifyResponse = func(response *http.Response) error {
+		if response.Request.URL.Path != common.RemoteExecutePath {
+			return nil
+		}
+
+		var remoteResponse common.RemoteResponse
+		if err := json.NewDecoder(response.Body).Decode(&remoteResponse); err != nil {
+			return err
+		}
+
+		remoteResponse.Outputs = append(remoteResponse.Outputs, common.RemoteOutput{
+			Path: "../escaped.txt", Content: []byte("escaped")})
+		content, err := json.Marshal(remoteResponse)
+		if err != nil {
+			return err
+		}
+
+		response.Body = ioutil.NopCloser(bytes.NewReader(content))
+		response.ContentLength = int64(len(content))
+		response.Header.Set("Content-Length", strconv.Itoa(len(content)))
+		return nil
+	}
+
+	untrusted, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer untrusted.Close()
+	go http.Serve(untrusted, proxy)
+
+	args.RemoteExecutor = untrusted.Addr().String()
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	assert.Equal(t, map[string]bool{"//main.cc": false, "//lib/lib.cc": false}, compiles())
+	_, err = os.Stat(escaped)
+	assert.True(t, os.IsNotExist(err))
+	output, err = runBinary(filepath.Join(args.OutputDir, cc.BinaryName("main")))
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, remote world!\n", output)
+
+	// If the worker can't be reached, everything is compiled locally instead.
+	jbuildClean(t, args)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	args.RemoteExecutor = closed.Addr().String()
+	closed.Close()
+
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	assert.Equal(t, map[string]bool{"//main.cc": false, "//lib/lib.cc": false}, compiles())
+	output, err = runBinary(filepath.Join(args.OutputDir, cc.BinaryName("main")))
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, remote world!\n", output)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test40ActionEnv(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "40_action_env", nil)
+	first := filepath.Join(args.WorkspaceDir, "first")
+	second := filepath.Join(args.WorkspaceDir, "second")
+
+	// The environment of jbuild isn't passed to the compiler.
+	os.Setenv("CPATH", first)
+	defer os.Unsetenv("CPATH")
+	err := jbuild.JBuildRun(args, []string{"build", ":main"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "message.h")
+
+	// Unless it is asked for.
+	args.ActionEnv = []string{"CPATH"}
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	output, err := runBinary(filepath.Join(args.OutputDir, cc.BinaryName("main")))
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", output)
+
+	// Changing the environment rebuilds the binary, even though no files changed.
+	args.ActionEnv = []string{"CPATH=" + second}
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	output, err = runBinary(filepath.Join(args.OutputDir, cc.BinaryName("main")))
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", output)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test41Reproducible(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "41_reproducible", nil)
+	binary := filepath.Join(args.OutputDir, cc.BinaryName("main"))
+
+	// Normally, binaries say where and when they were built.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	content, err := ioutil.ReadFile(binary)
+	require.NoError(t, err)
+	assert.True(t, bytes.Contains(content, []byte(args.WorkspaceDir)))
+
+	// Reproducible binaries don't.
+	args.Reproducible = true
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	content, err = ioutil.ReadFile(binary)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(content, []byte(args.WorkspaceDir)))
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, "./lib/lib.cc at redacted redacted\n", output)
+
+	// Building twice gives the same outputs.
+	args.Reproducible = false
+	require.NoError(t, jbuild.JBuildRun(args, []string{"verify-reproducible", ":main"}))
+	require.Error(t, jbuild.JBuildRun(args, []string{"verify-reproducible"}))
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test42Stamp(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "42_stamp", nil)
+	binary := filepath.Join(args.OutputDir, cc.BinaryName("main"))
+
+	// Without --stamp, the values are always the same.
+	unstamped := "revision=unknown\nstatus=unknown\ntimestamp=0\nuser=unknown\nrelease=\n"
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main", ":version"}))
+	output, err := runBinary(binary)
+	require.NoError(t, err)
+	assert.Equal(t, unstamped, output)
+	version, err := ioutil.ReadFile(filepath.Join(args.GenOutputDir, "version.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "BUILD_SCM_REVISION unknown\nBUILD_SCM_STATUS unknown\n"+
+		"BUILD_TIMESTAMP 0\nBUILD_USER unknown\nSTABLE_RELEASE \n", string(version))
+
+	// So the binary isn't built again.
+	binaryStat, err := os.Stat(binary)
+	require.NoError(t, err)
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	newBinaryStat, err := os.Stat(binary)
+	require.NoError(t, err)
+	assert.Equal(t, binaryStat.ModTime(), newBinaryStat.ModTime())
+
+	// With --stamp, they describe the build.
+	args.Stamp = true
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main", ":version"}))
+	output, err = runBinary(binary)
+	require.NoError(t, err)
+	assert.NotEqual(t, unstamped, output)
+	assert.Contains(t, output, "release=1.2.3\n")
+	assert.NotContains(t, output, "timestamp=0\n")
+	if usr, err := user.Current(); err == nil {
+		assert.Contains(t, output, "user="+usr.Username+"\n")
+	}
+
+	if revision, err := exec.Command("git", "-C", args.WorkspaceDir, "rev-parse", "HEAD").Output(); err == nil {
+		assert.Contains(t, output, "revision="+strings.TrimSpace(string(revision))+"\n")
+	}
+
+	version, err = ioutil.ReadFile(filepath.Join(args.GenOutputDir, "version.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(version), "STABLE_RELEASE 1.2.3\n")
+
+	// When the timestamp changes, only the objects which include the stamp
+	// header are compiled again.
+	mainStat, err := os.Stat(filepath.Join(args.OutputDir, "main.cc.o"))
+	require.NoError(t, err)
+	greetingStat, err := os.Stat(filepath.Join(args.OutputDir, "greeting.cc.o"))
+	require.NoError(t, err)
+	time.Sleep(time.Second)
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", ":main"}))
+	newMainStat, err := os.Stat(filepath.Join(args.OutputDir, "main.cc.o"))
+	require.NoError(t, err)
+	newGreetingStat, err := os.Stat(filepath.Join(args.OutputDir, "greeting.cc.o"))
+	require.NoError(t, err)
+	assert.NotEqual(t, mainStat.ModTime(), newMainStat.ModTime())
+	assert.Equal(t, greetingStat.ModTime(), newGreetingStat.ModTime())
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
+
+func Test43Runfiles(t *testing.T) {
+	// Set the current directory.
+	args := setupTest(t, "43_runfiles", nil)
+	args.BuildEventJsonFile = filepath.Join(args.WorkspaceDir, "events.json")
+	defer os.Remove(args.BuildEventJsonFile)
+
+	// Each executable has a runfiles tree with just its own data (and that of its
+	// dependencies) in it.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"build", "//tools:reader", "//tools:reader_test"}))
+	runfilesDir := filepath.Join(args.OutputDir, "tools", "reader.runfiles")
+	manifest, err := ioutil.ReadFile(filepath.Join(runfilesDir, "MANIFEST"))
+	require.NoError(t, err)
+	assert.Equal(t,
+		"data/config.txt "+filepath.Join(args.WorkspaceDir, "data", "config.txt")+"\n"+
+			"tools/greeting.txt "+filepath.Join(args.WorkspaceDir, "tools", "greeting.txt")+"\n",
+		string(manifest))
+	assert.True(t, common.FileExists(filepath.Join(runfilesDir, "tools", "greeting.txt")))
+
+	testRunfilesDir := filepath.Join(args.OutputDir, "tools", "reader_test.runfiles")
+	assert.True(t, common.FileExists(filepath.Join(testRunfilesDir, "data", "config.txt")))
+	assert.False(t, common.FileExists(filepath.Join(testRunfilesDir, "tools", "greeting.txt")))
+
+	// The runfiles are found next to the binary, wherever it is run from.
+	cmd := exec.Command(filepath.Join(args.OutputDir, "tools", cc.BinaryName("reader")))
+	cmd.Dir = os.TempDir()
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	assert.Equal(t, "//data/config.txt=config\ntools/greeting.txt=hello\n", string(output))
+
+	// Tests are told where their runfiles are.
+	require.NoError(t, jbuild.JBuildRun(args, []string{"test", "//tools:reader_test"}))
+	content, err := ioutil.ReadFile(args.BuildEventJsonFile)
+	require.NoError(t, err)
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var event common.BuildEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &event), line)
+		if event.Type == common.TestResultEvent {
+			found = true
+			assert.True(t, *event.Success, event.Output)
+			assert.Equal(t, "//data/config.txt=config\ntools/greeting.txt=missing\n", event.Output)
+		}
+	}
+
+	assert.True(t, found)
+
+	// Now, cleanup the output directory.
+	jbuildClean(t, args)
+}
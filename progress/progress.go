@@ -107,6 +107,10 @@ func Finish() {
 	close(progressBarUpdate)
 	progressBarUpdateFunction.Wait()
 	fmt.Printf("\n")
+
+	// Start again from scratch next time, e.g. when watching or serving.
+	progressBars = []*ProgressBar{}
+	progressBarUpdate = make(chan *ProgressBar)
 }
 
 func Disable() {
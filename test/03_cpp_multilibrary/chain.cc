@@ -0,0 +1,7 @@
+#include <stdio.h>
+
+const char* chain1();
+
+int main(int argc, char** argv) {
+  printf("%s", chain1());
+}
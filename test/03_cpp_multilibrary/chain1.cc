@@ -0,0 +1,5 @@
+const char* chain2();
+
+const char* chain1() {
+  return chain2();
+}
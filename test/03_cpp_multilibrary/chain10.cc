@@ -0,0 +1,3 @@
+const char* chain10() {
+  return "PASSED";
+}
@@ -0,0 +1,5 @@
+const char* chain3();
+
+const char* chain2() {
+  return chain3();
+}
@@ -0,0 +1,5 @@
+const char* chain4();
+
+const char* chain3() {
+  return chain4();
+}
@@ -0,0 +1,5 @@
+const char* chain5();
+
+const char* chain4() {
+  return chain5();
+}
@@ -0,0 +1,5 @@
+const char* chain6();
+
+const char* chain5() {
+  return chain6();
+}
@@ -0,0 +1,5 @@
+const char* chain7();
+
+const char* chain6() {
+  return chain7();
+}
@@ -0,0 +1,5 @@
+const char* chain8();
+
+const char* chain7() {
+  return chain8();
+}
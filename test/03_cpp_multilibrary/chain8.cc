@@ -0,0 +1,5 @@
+const char* chain9();
+
+const char* chain8() {
+  return chain9();
+}
@@ -0,0 +1,5 @@
+const char* chain10();
+
+const char* chain9() {
+  return chain10();
+}
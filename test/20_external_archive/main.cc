@@ -0,0 +1,6 @@
+#include <stdio.h>
+#include "third_party/mathlib/passed.h"
+
+int main(int argc, char** argv) {
+  printf("%s", passed());
+}
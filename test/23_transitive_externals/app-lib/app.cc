@@ -0,0 +1,6 @@
+#include "third_party/app/app.h"
+#include "third_party/shared/passed.h"
+
+const char* app_result() {
+  return passed();
+}
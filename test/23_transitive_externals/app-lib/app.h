@@ -0,0 +1 @@
+const char* app_result();
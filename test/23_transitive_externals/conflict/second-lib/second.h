@@ -0,0 +1 @@
+const char* second();
@@ -0,0 +1,6 @@
+#include <stdio.h>
+#include "third_party/app/app.h"
+
+int main(int argc, char** argv) {
+  printf("%s", app_result());
+}
@@ -0,0 +1,5 @@
+#include "third_party/shared/passed.h"
+
+const char* passed() {
+  return "WRONG";
+}
@@ -0,0 +1,3 @@
+const char* greeting() {
+  return GREETING_PREFIX GREETING_SUFFIX;
+}
@@ -0,0 +1,7 @@
+#include <stdio.h>
+
+const char* greeting();
+
+int main(int argc, char** argv) {
+  printf("%s", greeting());
+}
@@ -0,0 +1,7 @@
+#include <stdio.h>
+
+const char* passed();
+
+int main(int argc, char** argv) {
+  printf("%s", passed());
+}
@@ -0,0 +1,3 @@
+const char* passed() {
+  return RESULT;
+}
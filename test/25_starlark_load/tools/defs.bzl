@@ -0,0 +1,10 @@
+load(":flags.bzl", "DEFINES")
+
+COPTS = DEFINES
+
+def passed_library(name):
+    cc_library(
+        name = name,
+        srcs = [name + ".cc"],
+        compile_flags = COPTS,
+    )
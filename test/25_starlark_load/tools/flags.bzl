@@ -0,0 +1 @@
+DEFINES = ["-DRESULT=\"PASSED\""]
@@ -0,0 +1 @@
+#error "broken/ is excluded"
@@ -0,0 +1,8 @@
+#include <iostream>
+
+int lib();
+
+int main() {
+  std::cout << (lib() == 42 ? "PASSED" : "FAILED");
+  return 0;
+}
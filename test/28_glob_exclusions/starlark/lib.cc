@@ -0,0 +1,3 @@
+int lib() {
+  return 42;
+}
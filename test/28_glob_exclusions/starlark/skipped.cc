@@ -0,0 +1 @@
+#error "excluded"
@@ -0,0 +1 @@
+#error "sub/ is another package"
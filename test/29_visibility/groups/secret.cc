@@ -0,0 +1,3 @@
+#include "groups/secret.h"
+
+int secret() { return 7; }
@@ -0,0 +1,3 @@
+#include "lib/internal.h"
+
+int internal() { return 21; }
@@ -0,0 +1,5 @@
+#include "lib/lib.h"
+
+#include "lib/internal.h"
+
+int lib() { return internal() * 2; }
@@ -0,0 +1,3 @@
+#include "lib/internal.h"
+
+int main() { return internal() == 21 ? 0 : 1; }
@@ -0,0 +1,3 @@
+#include "groups/secret.h"
+
+int main() { return secret() == 7 ? 0 : 1; }
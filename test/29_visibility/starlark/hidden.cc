@@ -0,0 +1 @@
+int hidden() { return 0; }
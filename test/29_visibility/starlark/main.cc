@@ -0,0 +1,3 @@
+int hidden();
+
+int main() { return hidden(); }
@@ -0,0 +1,3 @@
+#include "base.h"
+
+int base() { return 21; }
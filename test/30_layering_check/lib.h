@@ -0,0 +1,7 @@
+#pragma once
+
+#include "base.h"
+
+inline int twice() { return base() * 2; }
+
+int lib();
@@ -0,0 +1,11 @@
+#include <iostream>
+
+#include "lib.h"
+
+int main() {
+  if (lib() == 42) {
+    std::cout << "PASSED";
+  }
+
+  return 0;
+}
@@ -0,0 +1,3 @@
+#pragma once
+
+int stray() { return 0; }
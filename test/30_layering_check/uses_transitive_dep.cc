@@ -0,0 +1,4 @@
+#include "base.h"
+#include "lib.h"
+
+int main() { return lib() - base() * 2; }
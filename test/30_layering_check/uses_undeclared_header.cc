@@ -0,0 +1,3 @@
+#include "stray.h"
+
+int main() { return stray(); }
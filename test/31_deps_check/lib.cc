@@ -0,0 +1,5 @@
+#include "lib.h"
+
+#include "base.h"
+
+int lib() { return base() * 2; }
@@ -0,0 +1,3 @@
+#include "lib.h"
+
+int main() { return lib() == 42 ? 0 : 1; }
@@ -0,0 +1,6 @@
+#include "lib.h"
+
+// Declared here rather than included.
+int base();
+
+int main() { return lib() - base() * 2; }
@@ -0,0 +1,3 @@
+#include "unused.h"
+
+int unused() { return 0; }
@@ -0,0 +1,5 @@
+#include "app/greet.h"
+
+std::string greet(const std::string& name) {
+  return "Hello, " + name + "!";
+}
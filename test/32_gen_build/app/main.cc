@@ -0,0 +1,9 @@
+#include <iostream>
+
+#include "greet.h"
+#include "math/add.h"
+
+int main(int argc, char** argv) {
+  std::cout << greet("world") << " " << add(1, 2) << std::endl;
+  return 0;
+}
@@ -0,0 +1,5 @@
+#include "existing/legacy.h"
+
+int legacy() {
+  return 42;
+}
@@ -0,0 +1,5 @@
+#include "existing/legacy.h"
+
+int other() {
+  return legacy() + 1;
+}
@@ -0,0 +1,5 @@
+#include "add.h"
+
+int main() {
+  return add(1, 2) == 3 ? 0 : 1;
+}
@@ -0,0 +1,3 @@
+int starlark() {
+  return 0;
+}
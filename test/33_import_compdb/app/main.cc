@@ -0,0 +1,9 @@
+#include <iostream>
+
+#include "math/add.h"
+#include "src/util/strings.h"
+
+int main() {
+  std::cout << exclaim(GREETING) << " " << add(1, 2) << std::endl;
+  return 0;
+}
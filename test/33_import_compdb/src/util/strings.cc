@@ -0,0 +1,5 @@
+#include "src/util/strings.h"
+
+std::string exclaim(const std::string& text) {
+  return text + std::string(UTIL_EXCLAMATIONS, '!');
+}
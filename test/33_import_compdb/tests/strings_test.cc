@@ -0,0 +1,5 @@
+#include "src/util/strings.h"
+
+int main() {
+  return exclaim("a") == "a!" ? 0 : 1;
+}
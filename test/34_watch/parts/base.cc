@@ -0,0 +1,3 @@
+int base_part() {
+  return 0;
+}
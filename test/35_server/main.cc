@@ -0,0 +1,6 @@
+#include <iostream>
+
+int main() {
+  std::cout << 1 << std::endl;
+  return 0;
+}
@@ -0,0 +1,6 @@
+#include <iostream>
+
+int main() {
+  std::cout << 2 << std::endl;
+  return 0;
+}
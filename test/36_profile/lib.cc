@@ -0,0 +1,5 @@
+#include "lib.h"
+
+int lib() {
+  return 1;
+}
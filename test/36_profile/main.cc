@@ -0,0 +1,8 @@
+#include <iostream>
+
+#include "lib.h"
+
+int main() {
+  std::cout << lib() << std::endl;
+  return 0;
+}
@@ -0,0 +1,3 @@
+int main() {
+  return this is not C++;
+}
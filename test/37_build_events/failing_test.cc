@@ -0,0 +1,6 @@
+#include <iostream>
+
+int main() {
+  std::cout << "Something went wrong" << std::endl;
+  return 1;
+}
@@ -0,0 +1,5 @@
+#include "lib.h"
+
+int main() {
+  return lib() == 1 ? 0 : 1;
+}
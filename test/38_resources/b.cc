@@ -0,0 +1,3 @@
+int b() {
+  return 1;
+}
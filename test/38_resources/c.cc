@@ -0,0 +1,3 @@
+int c() {
+  return 1;
+}
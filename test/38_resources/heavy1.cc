@@ -0,0 +1,3 @@
+int heavy1() {
+  return 1;
+}
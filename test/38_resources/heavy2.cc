@@ -0,0 +1,3 @@
+int heavy2() {
+  return 1;
+}
@@ -0,0 +1,9 @@
+int a();
+int b();
+int c();
+int heavy1();
+int heavy2();
+
+int main() {
+  return a() + b() + c() + heavy1() + heavy2() - 5;
+}
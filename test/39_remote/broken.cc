@@ -0,0 +1,3 @@
+int main() {
+  return not_declared;
+}
@@ -0,0 +1,5 @@
+#include "lib/lib.h"
+
+std::string Greeting() {
+  return "Hello, remote world!";
+}
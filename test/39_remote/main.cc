@@ -0,0 +1,8 @@
+#include <iostream>
+
+#include "lib/lib.h"
+
+int main() {
+  std::cout << Greeting() << std::endl;
+  return 0;
+}
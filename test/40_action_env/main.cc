@@ -0,0 +1,9 @@
+#include <iostream>
+
+// Only found if CPATH points at one of the directories containing it.
+#include "message.h"
+
+int main() {
+  std::cout << kMessage << std::endl;
+  return 0;
+}
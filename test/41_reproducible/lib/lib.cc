@@ -0,0 +1,5 @@
+#include "lib/lib.h"
+
+const char* BuildFile() {
+  return __FILE__;
+}
@@ -0,0 +1,4 @@
+#pragma once
+
+const char* BuildFile();
+const char* BuildTime();
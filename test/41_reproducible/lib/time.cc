@@ -0,0 +1,5 @@
+#include "lib/lib.h"
+
+const char* BuildTime() {
+  return __DATE__ " " __TIME__;
+}
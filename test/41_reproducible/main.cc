@@ -0,0 +1,8 @@
+#include <iostream>
+
+#include "lib/lib.h"
+
+int main() {
+  std::cout << BuildFile() << " at " << BuildTime() << std::endl;
+  return 0;
+}
@@ -0,0 +1,4 @@
+// Doesn't use the workspace status, so isn't compiled again when it changes.
+const char* Greeting() {
+  return "Hello";
+}
@@ -0,0 +1,12 @@
+#include <iostream>
+
+#include "jbuild/stamp.h"
+
+int main() {
+  std::cout << "revision=" << BUILD_SCM_REVISION << std::endl
+            << "status=" << BUILD_SCM_STATUS << std::endl
+            << "timestamp=" << BUILD_TIMESTAMP << std::endl
+            << "user=" << BUILD_USER << std::endl
+            << "release=" << STABLE_RELEASE << std::endl;
+  return 0;
+}
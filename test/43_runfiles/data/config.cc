@@ -0,0 +1,4 @@
+// The name of the config file, relative to the workspace.
+const char* ConfigPath() {
+  return "//data/config.txt";
+}
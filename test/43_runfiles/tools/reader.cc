@@ -0,0 +1,26 @@
+#include <fstream>
+#include <iostream>
+#include <string>
+
+#include "jbuild/runfiles.h"
+
+const char* ConfigPath();
+
+// Prints the contents of the runfile at `path`, or "missing" if there isn't one.
+bool Print(const std::string& path, const char* argv0) {
+  std::ifstream file(jbuild::runfiles::Rlocation(path, argv0));
+  std::string content;
+  if (!std::getline(file, content)) {
+    std::cout << path << "=missing" << std::endl;
+    return false;
+  }
+
+  std::cout << path << "=" << content << std::endl;
+  return true;
+}
+
+int main(int argc, char** argv) {
+  bool found = Print(ConfigPath(), argv[0]);
+  Print("tools/greeting.txt", argv[0]);
+  return found ? 0 : 1;
+}